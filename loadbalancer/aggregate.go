@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/querylang"
+)
+
+// Aggregates are computed in two steps: every shard computes partial
+// aggregates of its rows in SQLite, which the load balancer then combines.
+// Counts and sums add up and minimums and maximums are taken again, while an
+// average is computed from the sums and counts of the shards, as averaging
+// their averages would weigh small shards too much.
+
+// name returns the column an aggregate ends up in.
+func (a Aggregate) name() string {
+	return querylang.AggregateName(a.Func, a.Column, a.As)
+}
+
+// validateAggregation checks the columns and functions of an aggregation
+// against the schema.
+func validateAggregation(req AggregateRequest) error {
+	if len(schemaConfig.Columns) == 0 {
+		return errors.New("database is not configured")
+	}
+	if len(req.Aggregates) == 0 {
+		return errors.New("no aggregates")
+	}
+	if err := validateQuery(QueryRequest{Where: req.Where}); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, column := range req.GroupBy {
+		if !isColumn(column) {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if names[column] {
+			return fmt.Errorf("duplicate group column %q", column)
+		}
+		names[column] = true
+	}
+	for _, a := range req.Aggregates {
+		fn := strings.ToUpper(a.Func)
+		if !querylang.IsAggregateFunc(fn) {
+			return fmt.Errorf("unknown aggregate function %q", a.Func)
+		}
+		if a.Column == "" && fn != "COUNT" {
+			return fmt.Errorf("%s needs a column", fn)
+		}
+		if a.Column != "" && !isColumn(a.Column) {
+			return fmt.Errorf("unknown column %q", a.Column)
+		}
+		if names[a.name()] {
+			return fmt.Errorf("duplicate column %q", a.name())
+		}
+		names[a.name()] = true
+	}
+	return nil
+}
+
+// partialAggregates returns the aggregates the shards compute for an
+// aggregation, named after the position of the aggregate they are for.
+func partialAggregates(aggregates []Aggregate) []Aggregate {
+	partials := []Aggregate{}
+	for i, a := range aggregates {
+		fn := strings.ToUpper(a.Func)
+		name := fmt.Sprintf("_%d", i)
+		if fn == "AVG" {
+			partials = append(partials,
+				Aggregate{Func: "SUM", Column: a.Column, As: name},
+				Aggregate{Func: "COUNT", Column: a.Column, As: name + "_count"})
+			continue
+		}
+		partials = append(partials, Aggregate{Func: fn, Column: a.Column, As: name})
+	}
+	return partials
+}
+
+// addValues adds two numbers, keeping integers exact. Null is no value.
+func addValues(a interface{}, b interface{}) interface{} {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			i, errI := x.Int64()
+			j, errJ := y.Int64()
+			if errI == nil && errJ == nil {
+				return json.Number(fmt.Sprint(i + j))
+			}
+		}
+	}
+	x, _ := toFloat(a)
+	y, _ := toFloat(b)
+	return x + y
+}
+
+// combineAggregates combines the partial aggregates of every shard in a
+// group into the aggregates asked for.
+func combineAggregates(aggregates []Aggregate, partials []Row) Row {
+	row := Row{}
+	for i, a := range aggregates {
+		name := fmt.Sprintf("_%d", i)
+		fn := strings.ToUpper(a.Func)
+
+		var value interface{}
+		switch fn {
+		case "COUNT":
+			value = json.Number("0")
+		case "AVG":
+			var sum, count interface{}
+			for _, partial := range partials {
+				sum = addValues(sum, partial[name])
+				count = addValues(count, partial[name+"_count"])
+			}
+			if n, ok := toFloat(count); ok && n > 0 {
+				s, _ := toFloat(sum)
+				value = s / n
+			}
+			row[a.name()] = value
+			continue
+		}
+		for _, partial := range partials {
+			v := partial[name]
+			switch {
+			case fn == "COUNT" || fn == "SUM":
+				value = addValues(value, v)
+			case v == nil:
+			case value == nil:
+				value = v
+			case fn == "MIN" && compareValues(v, value) < 0:
+				value = v
+			case fn == "MAX" && compareValues(v, value) > 0:
+				value = v
+			}
+		}
+		row[a.name()] = value
+	}
+	return row
+}
+
+// mergeGroups combines the partial aggregates of the shards by group, in
+// order of the group columns.
+func mergeGroups(responses []*ServerReadResponse, req AggregateRequest) ([]Row, error) {
+	keys := []string{}
+	groups := map[string][]Row{}
+	for _, respData := range responses {
+		if respData == nil {
+			continue
+		}
+		for _, partial := range respData.Data {
+			values := []interface{}{}
+			for _, column := range req.GroupBy {
+				values = append(values, partial[column])
+			}
+			key, err := json.Marshal(values)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := groups[string(key)]; !ok {
+				keys = append(keys, string(key))
+			}
+			groups[string(key)] = append(groups[string(key)], partial)
+		}
+	}
+	// without groups there is always one row, even with no shard to ask
+	if len(req.GroupBy) == 0 && len(keys) == 0 {
+		keys = append(keys, "[]")
+	}
+
+	rows := []Row{}
+	for _, key := range keys {
+		row := combineAggregates(req.Aggregates, groups[key])
+		if partials := groups[key]; len(partials) > 0 {
+			for _, column := range req.GroupBy {
+				row[column] = partials[0][column]
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, column := range req.GroupBy {
+			if c := compareValues(rows[i][column], rows[j][column]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return rows, nil
+}
+
+func aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req AggregateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	settings, err := parseReadOptions(req.ReadOptions)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+	if err := validateAggregation(req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid aggregation: %v", err))
+		return
+	}
+
+	low, high := keyRange(req.Where)
+	shardsQueried := []Shard{}
+	if low <= high {
+		shardsQueried, err = getShardsInRange(low, high)
+		if err != nil {
+			writeError(w, r, errorFrom(err, "error finding shards in range"))
+			return
+		}
+	}
+	shardIDsQueried := []string{}
+	for _, shard := range shardsQueried {
+		shardIDsQueried = append(shardIDsQueried, shard.ShardID)
+	}
+
+	partials := partialAggregates(req.Aggregates)
+	ctx, cancel := context.WithTimeout(r.Context(), settings.timeout)
+	defer cancel()
+	responses, errs := scatter(ctx, shardsQueried, settings.parallelism, func(ctx context.Context, shard Shard) (*ServerReadResponse, error) {
+		payload := ServerAggregatePayload{
+			Shard:      shard.ShardID,
+			Aggregates: partials,
+			Where:      shardRangeWhere(shard, req.Where),
+			GroupBy:    req.GroupBy,
+		}
+		return readShard(ctx, shard.ShardID, "/aggregate", payload, settings.level, settings.hedgeAfter)
+	})
+	for shardID, err := range errs {
+		if isBadRequest(err) {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid aggregation: %v", err).WithShard(shardID))
+			return
+		}
+	}
+	if len(errs) > 0 {
+		for shardID, err := range errs {
+			slog.WarnContext(r.Context(), "error aggregating shard", "shard", shardID, logging.Error(err))
+		}
+		writeError(w, r, shardsError(errs))
+		return
+	}
+
+	data, err := mergeGroups(responses, req)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.Internal, "Error combining aggregates: %v", err))
+		return
+	}
+
+	response := AggregateResponse{
+		ShardsQueried: shardIDsQueried,
+		Data:          data,
+		Status:        "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
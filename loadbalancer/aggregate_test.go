@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: append([]Row{
+		student(30, "Kabir", 60),
+		student(250, "Aarav", 85),
+	}, testStudents...)}, nil)
+
+	aggregate := func(req AggregateRequest) AggregateResponse {
+		t.Helper()
+		var resp AggregateResponse
+		c.mustDo("POST", "/aggregate", req, &resp)
+		return resp
+	}
+
+	all := []Aggregate{{Func: "COUNT"}, {Func: "SUM", Column: "Stud_marks"}, {Func: "AVG", Column: "Stud_marks", As: "average"}, {Func: "MIN", Column: "Stud_name"}, {Func: "MAX", Column: "Stud_marks"}}
+	resp := aggregate(AggregateRequest{Aggregates: all})
+	assertRows(t, resp.Data, []Row{{"count": 7, "sum_Stud_marks": 504, "average": 72, "min_Stud_name": "Aarav", "max_Stud_marks": 92}})
+
+	// averages come from the sums and counts of all shards of a group
+	resp = aggregate(AggregateRequest{
+		Aggregates: []Aggregate{{Func: "AVG", Column: "Stud_marks"}, {Func: "COUNT", Column: "Stud_id"}},
+		GroupBy:    []string{"Stud_name"},
+	})
+	assertRows(t, resp.Data, []Row{
+		{"Stud_name": "Aarav", "avg_Stud_marks": 83, "count_Stud_id": 2},
+		{"Stud_name": "Diya", "avg_Stud_marks": 64, "count_Stud_id": 1},
+		{"Stud_name": "Kabir", "avg_Stud_marks": 76, "count_Stud_id": 2},
+		{"Stud_name": "Meera", "avg_Stud_marks": 47, "count_Stud_id": 1},
+		{"Stud_name": "Rohan", "avg_Stud_marks": 75, "count_Stud_id": 1},
+	})
+
+	resp = aggregate(AggregateRequest{Aggregates: all, Where: []Predicate{{Column: "Stud_id", Op: "<", Value: 100}}})
+	assertRows(t, resp.Data, []Row{{"count": 3, "sum_Stud_marks": 205, "average": 205.0 / 3, "min_Stud_name": "Aarav", "max_Stud_marks": 81}})
+	if len(resp.ShardsQueried) != 1 {
+		t.Errorf("got shards %v, want only sh1", resp.ShardsQueried)
+	}
+
+	resp = aggregate(AggregateRequest{Aggregates: all, Where: []Predicate{{Column: "Stud_id", Op: ">", Value: 1000}}})
+	assertRows(t, resp.Data, []Row{{"count": 0, "sum_Stud_marks": nil, "average": nil, "min_Stud_name": nil, "max_Stud_marks": nil}})
+
+	for _, bad := range []AggregateRequest{
+		{},
+		{Aggregates: []Aggregate{{Func: "MEDIAN", Column: "Stud_marks"}}},
+		{Aggregates: []Aggregate{{Func: "SUM", Column: "Stud_name"}}},
+		{Aggregates: []Aggregate{{Func: "COUNT"}}, GroupBy: []string{"Stud_age"}},
+	} {
+		if status := c.do("POST", "/aggregate", bad, nil); status != http.StatusBadRequest {
+			t.Errorf("aggregate %+v: got status %d, want 400", bad, status)
+		}
+	}
+}
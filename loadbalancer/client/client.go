@@ -0,0 +1,228 @@
+// Package client is the Go client of the GalaxyDB load balancer. A Client
+// sends each request with the context it is given, so a context with a
+// deadline bounds the request, retries included. Requests that fail are
+// returned as an *Error, the error envelope the load balancer answers with.
+// A context made with WithTraceparent or WithRequestID passes the caller's
+// trace on to the load balancer.
+//
+// Requests that are safe to repeat, reads, queries, updates, deletes and
+// status, are retried when they fail with a retryable code or the load
+// balancer cannot be reached, with a backoff that doubles after every
+// attempt. Writes, /init, /add and /rm are sent once, as an attempt that
+// failed may still have been applied.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+	DefaultMaxConns     = 64
+)
+
+// Options configure a Client. HTTPClient sends the requests, by default one
+// keeping up to MaxConns connections to the load balancer open for reuse.
+// Timeout, if set, bounds each attempt of a request. Retries is how many
+// times a request is retried, negative meaning never, and RetryBackoff how
+// long the first retry waits.
+type Options struct {
+	HTTPClient   *http.Client
+	MaxConns     int
+	Timeout      time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// WithDefaults fills in the options left at zero.
+func (o Options) WithDefaults() Options {
+	if o.MaxConns == 0 {
+		o.MaxConns = DefaultMaxConns
+	}
+	if o.HTTPClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = o.MaxConns
+		transport.MaxIdleConnsPerHost = o.MaxConns
+		o.HTTPClient = &http.Client{Transport: transport}
+	}
+	if o.Retries == 0 {
+		o.Retries = DefaultRetries
+	}
+	if o.RetryBackoff == 0 {
+		o.RetryBackoff = DefaultRetryBackoff
+	}
+	return o
+}
+
+// Client sends requests to a load balancer. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	options Options
+}
+
+// New returns a client of the load balancer at baseURL, as in
+// http://localhost:5000.
+func New(baseURL string, options Options) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), options: options.WithDefaults()}
+}
+
+// Init configures the cluster.
+func (c *Client) Init(ctx context.Context, req InitRequest) error {
+	return c.do(ctx, http.MethodPost, "/init", req, nil, false)
+}
+
+// Status returns the configuration of the cluster.
+func (c *Client) Status(ctx context.Context) (*StatusResponse, error) {
+	var resp StatusResponse
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &resp, true); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Add starts new servers and adds new shards.
+func (c *Client) Add(ctx context.Context, req AddRequest) (*AddResponse, error) {
+	var resp AddResponse
+	if err := c.do(ctx, http.MethodPost, "/add", req, &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Remove removes servers.
+func (c *Client) Remove(ctx context.Context, req RemoveRequest) (*RemoveResponse, error) {
+	var resp RemoveResponse
+	if err := c.do(ctx, http.MethodDelete, "/rm", req, &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Read returns the rows in a range of the shard key.
+func (c *Client) Read(ctx context.Context, req ReadRequest) (*ReadResponse, error) {
+	var resp ReadResponse
+	if err := c.do(ctx, http.MethodPost, "/read", req, &resp, true); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Query returns a page of the rows a query selects. See Pages to go through
+// all of them.
+func (c *Client) Query(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	var resp QueryResponse
+	if err := c.do(ctx, http.MethodPost, "/query", req, &resp, true); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Write adds rows.
+func (c *Client) Write(ctx context.Context, req WriteRequest) (*WriteResponse, error) {
+	var resp WriteResponse
+	if err := c.do(ctx, http.MethodPost, "/write", req, &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Update changes a row.
+func (c *Client) Update(ctx context.Context, req UpdateRequest) (*UpdateResponse, error) {
+	var resp UpdateResponse
+	if err := c.do(ctx, http.MethodPut, "/update", req, &resp, true); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Delete removes a row.
+func (c *Client) Delete(ctx context.Context, req DeleteRequest) (*DeleteResponse, error) {
+	var resp DeleteResponse
+	if err := c.do(ctx, http.MethodDelete, "/del", req, &resp, true); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do sends a request to the load balancer and decodes its response into out,
+// retrying it if retry is set.
+func (c *Client) do(ctx context.Context, method string, endpoint string, in interface{}, out interface{}, retry bool) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	backoff := c.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, endpoint, payload, out)
+		if err == nil || !retry || attempt >= c.options.Retries || ctx.Err() != nil || !Retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one attempt at a request.
+func (c *Client) send(ctx context.Context, method string, endpoint string, payload []byte, out interface{}) error {
+	if c.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	injectTrace(ctx, req.Header)
+	resp, err := c.options.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
+
+// Retryable reports whether a request that failed with err may succeed if
+// sent again: the load balancer answered with a retryable code, could not be
+// reached, or an attempt ran out of time.
+func Retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
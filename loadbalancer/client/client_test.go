@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers the first failures requests with err, with status, and
+// the rest with an empty success, counting the requests in attempts.
+func flakyServer(t *testing.T, failures int32, status int, err *Error, attempts *int32) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(attempts, 1) <= failures {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message":"done","status":"success"}`))
+	}))
+	t.Cleanup(server.Close)
+	return New(server.URL, Options{RetryBackoff: time.Millisecond})
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	unavailable := &Error{Status: "failure", Code: Unavailable, Message: "no replica of sh1 is up", Shard: "sh1"}
+
+	// reads are retried past retryable failures
+	var attempts int32
+	c := flakyServer(t, 2, http.StatusServiceUnavailable, unavailable, &attempts)
+	if _, err := c.Read(ctx, ReadRequest{StudID: KeyRange{Low: 0, High: 99}}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+
+	// until the retries run out
+	attempts = 0
+	c = flakyServer(t, 10, http.StatusServiceUnavailable, unavailable, &attempts)
+	_, err := c.Update(ctx, UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 90}})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != Unavailable || apiErr.Message != unavailable.Message || apiErr.Shard != "sh1" {
+		t.Errorf("got %v, want the unavailable error", err)
+	}
+	if attempts != DefaultRetries+1 {
+		t.Errorf("got %d attempts, want %d", attempts, DefaultRetries+1)
+	}
+
+	// writes are not retried, nor are errors that are not retryable
+	attempts = 0
+	c = flakyServer(t, 1, http.StatusServiceUnavailable, unavailable, &attempts)
+	if _, err := c.Write(ctx, WriteRequest{Data: []Row{{"Stud_id": 10}}}); !Retryable(err) || attempts != 1 {
+		t.Errorf("got %v after %d attempts, want the unavailable error after 1", err, attempts)
+	}
+	attempts = 0
+	c = flakyServer(t, 1, http.StatusBadRequest, &Error{Status: "failure", Code: BadRequest, Message: "low is greater than high"}, &attempts)
+	if _, err := c.Read(ctx, ReadRequest{StudID: KeyRange{Low: 9, High: 0}}); Retryable(err) || attempts != 1 {
+		t.Errorf("got %v after %d attempts, want a bad_request after 1", err, attempts)
+	}
+
+	// the context bounds the retries
+	attempts = 0
+	c = flakyServer(t, 10, http.StatusServiceUnavailable, unavailable, &attempts)
+	c.options.RetryBackoff = time.Hour
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Delete(deadline, DeleteRequest{StudID: 10}); !Retryable(err) || time.Since(start) > time.Second {
+		t.Errorf("got %v after %v, want the unavailable error at the deadline", err, time.Since(start))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// an attempt that runs out of time is retried like one that failed
+	c := New(server.URL, Options{Timeout: 10 * time.Millisecond, Retries: 1, RetryBackoff: time.Millisecond})
+	_, err := c.Status(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) || !Retryable(err) {
+		t.Errorf("got %v, want a retryable deadline exceeded", err)
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	// a proxy in front of the load balancer may answer without an envelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream connect error", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := New(server.URL, Options{Retries: -1})
+	_, err := c.Status(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != Unavailable || apiErr.Message != "upstream connect error" || !Retryable(err) {
+		t.Errorf("got %v, want a retryable unavailable error", err)
+	}
+}
+
+func TestTraceHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		w.Write([]byte(`{"message":"done","status":"success"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, Options{})
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := WithRequestID(WithTraceparent(context.Background(), traceparent), "import-42")
+	if _, err := c.Delete(ctx, DeleteRequest{StudID: 10}); err != nil {
+		t.Fatal(err)
+	}
+	header := <-headers
+	if header.Get("traceparent") != traceparent || header.Get("X-Request-ID") != "import-42" {
+		t.Errorf("got traceparent %q and request id %q", header.Get("traceparent"), header.Get("X-Request-ID"))
+	}
+
+	// without a trace the load balancer starts one
+	if _, err := c.Delete(context.Background(), DeleteRequest{StudID: 10}); err != nil {
+		t.Fatal(err)
+	}
+	header = <-headers
+	if header.Get("traceparent") != "" || header.Get("X-Request-ID") != "" {
+		t.Errorf("got traceparent %q and request id %q, want none", header.Get("traceparent"), header.Get("X-Request-ID"))
+	}
+}
+
+// TestBuildsOnItsOwn builds the package as a module of its own, with no
+// workspace, replace directive or module proxy, as a program outside this
+// repository would get it: it must not import the rest of the repository.
+func TestBuildsOnItsOwn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+
+	dir := t.TempDir()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), source, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/client\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goBin, "build", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod", "GOPROXY=off")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("building the client on its own: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Code is the code of an error envelope, saying what went wrong. The load
+// balancer's README lists what each code means and the status it comes with.
+type Code string
+
+const (
+	BadRequest       Code = "bad_request"
+	NotFound         Code = "not_found"
+	MethodNotAllowed Code = "method_not_allowed"
+	Conflict         Code = "conflict"
+	IndexConflict    Code = "index_conflict"
+	TermConflict     Code = "term_conflict"
+	Internal         Code = "internal"
+	ServerError      Code = "server_error"
+	Unavailable      Code = "unavailable"
+	Timeout          Code = "timeout"
+)
+
+// Retryable reports whether a request that failed with the code may succeed
+// if sent again.
+func (c Code) Retryable() bool {
+	return c == Unavailable || c == Timeout
+}
+
+// codeForStatus returns the code of an HTTP status, for errors that only come
+// with a status.
+func codeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return BadRequest
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusBadGateway:
+		return ServerError
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return Timeout
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return Internal
+}
+
+// Error is the error envelope a failed request is answered with. Shard and
+// Server name the shard and server the error concerns, when there is one.
+type Error struct {
+	Status  string `json:"status"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Shard   string `json:"shard,omitempty"`
+	Server  string `json:"server,omitempty"`
+
+	// CurrentIndex and CurrentTerm are where the shard is at, on
+	// index_conflict and term_conflict.
+	CurrentIndex *int `json:"current_idx,omitempty"`
+	CurrentTerm  *int `json:"current_term,omitempty"`
+	// Errors is the error of each shard a read failed on.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// readError returns the error a response with a status other than 200
+// carries. A body that is not an error envelope, as from a proxy in between,
+// becomes the message of an error with the code of the status.
+func readError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(resp.Body)
+	var e Error
+	if err := json.Unmarshal(body, &e); err == nil && e.Code != "" {
+		e.Status = "failure"
+		return &e
+	}
+	return &Error{Status: "failure", Code: codeForStatus(resp.StatusCode), Message: string(bytes.TrimSpace(body))}
+}
@@ -0,0 +1,70 @@
+package client
+
+import "context"
+
+// Pages goes through the pages of a query, each continuing after the last
+// row of the one before:
+//
+//	pages := c.QueryPages(client.QueryRequest{Limit: 100})
+//	for pages.Next(ctx) {
+//		for _, row := range pages.Page().Data {
+//			...
+//		}
+//	}
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+type Pages struct {
+	client *Client
+	req    QueryRequest
+	page   *QueryResponse
+	done   bool
+	err    error
+}
+
+// QueryPages returns the pages of req, starting at its Cursor. Limit is the
+// size of a page.
+func (c *Client) QueryPages(req QueryRequest) *Pages {
+	return &Pages{client: c, req: req}
+}
+
+// Next fetches the next page, reporting whether there is one.
+func (p *Pages) Next(ctx context.Context) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+	p.page, p.err = p.client.Query(ctx, p.req)
+	if p.err != nil {
+		return false
+	}
+	p.req.Cursor = p.page.NextCursor
+	p.done = p.page.NextCursor == ""
+	return true
+}
+
+// Page returns the page Next fetched.
+func (p *Pages) Page() *QueryResponse {
+	return p.page
+}
+
+// Cursor returns the cursor of the page after the last one fetched, which a
+// later QueryPages can start at. It is empty once all pages are fetched.
+func (p *Pages) Cursor() string {
+	return p.req.Cursor
+}
+
+// Err returns the error that stopped Next, if any.
+func (p *Pages) Err() error {
+	return p.err
+}
+
+// QueryAll returns every row req selects, fetched a page of req.Limit rows at
+// a time.
+func (c *Client) QueryAll(ctx context.Context, req QueryRequest) ([]Row, error) {
+	rows := []Row{}
+	pages := c.QueryPages(req)
+	for pages.Next(ctx) {
+		rows = append(rows, pages.Page().Data...)
+	}
+	return rows, pages.Err()
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	requestIDHeader   = "X-Request-ID"
+)
+
+type traceparentKey struct{}
+type requestIDKey struct{}
+
+// WithTraceparent returns ctx with a W3C traceparent header, as in
+// 00-<trace id>-<span id>-01, that requests sent with it carry, so the load
+// balancer continues the caller's trace instead of starting one.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+// WithRequestID returns ctx with the request id requests sent with it carry,
+// which the load balancer logs them under in place of the trace id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// injectTrace sets the headers that pass the trace context of ctx on to the
+// load balancer.
+func injectTrace(ctx context.Context, header http.Header) {
+	if traceparent, _ := ctx.Value(traceparentKey{}).(string); traceparent != "" {
+		header.Set(traceparentHeader, traceparent)
+	}
+	if requestID, _ := ctx.Value(requestIDKey{}).(string); requestID != "" {
+		header.Set(requestIDHeader, requestID)
+	}
+}
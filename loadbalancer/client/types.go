@@ -0,0 +1,195 @@
+package client
+
+// These are the requests and responses of the load balancer's API, as the
+// load balancer defines them in its types.go.
+
+// Shard is a range of the shard key. Replicas is how many copies of it the
+// cluster keeps and Selector how reads pick one of them: "ring" (the
+// default), "rendezvous", "jump" or "bounded".
+type Shard struct {
+	StudIDLow int    `json:"Stud_id_low"`
+	ShardID   string `json:"Shard_id"`
+	ShardSize int    `json:"Shard_size"`
+	Replicas  int    `json:"replicas,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+}
+
+type SchemaConfig struct {
+	Columns []string `json:"columns"`
+	Dtypes  []string `json:"dtypes"`
+}
+
+// HashRingOptions tune the hash map of every shard. Probing is "linear" (the
+// default), "quadratic" or "double".
+type HashRingOptions struct {
+	Slots        int    `json:"slots,omitempty"`
+	VirtualNodes int    `json:"virtual_nodes,omitempty"`
+	Probing      string `json:"probing,omitempty"`
+}
+
+// InitRequest configures the cluster. Servers maps server names to the
+// shards they hold. Shards it does not place anywhere, or all of them if it is
+// left out, are spread over the servers, N new servers being started if
+// Servers is left out. Weights gives servers a capacity weight. ReadRouting
+// is how reads pick a replica: "p2c" (the default), "least_loaded" or "hash".
+type InitRequest struct {
+	N           int                 `json:"N"`
+	Schema      SchemaConfig        `json:"schema"`
+	Shards      []Shard             `json:"shards"`
+	Servers     map[string][]string `json:"servers,omitempty"`
+	Replicas    int                 `json:"replicas,omitempty"`
+	HashRing    HashRingOptions     `json:"hash_ring"`
+	Weights     map[string]int      `json:"weights,omitempty"`
+	ReadRouting string              `json:"read_routing,omitempty"`
+}
+
+// StatusResponse is the configuration of the cluster: the servers with the
+// shards they hold and their weights.
+type StatusResponse struct {
+	N       int                 `json:"N"`
+	Schema  SchemaConfig        `json:"schema"`
+	Shards  []Shard             `json:"shards"`
+	Servers map[string][]string `json:"servers"`
+	Weights map[string]int      `json:"weights"`
+}
+
+// AddRequest starts new servers and adds new shards, placed as in
+// InitRequest. With Rebalance set, replicas of existing shards are then moved
+// onto the new servers until every server holds about as many.
+type AddRequest struct {
+	N         int                 `json:"n"`
+	NewShards []Shard             `json:"new_shards"`
+	Servers   map[string][]string `json:"servers,omitempty"`
+	Rebalance bool                `json:"rebalance"`
+	Weights   map[string]int      `json:"weights,omitempty"`
+}
+
+type AddResponse struct {
+	N       int    `json:"N"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// RemoveRequest removes N servers: those in Servers and, if it names fewer,
+// others picked at random.
+type RemoveRequest struct {
+	N       int      `json:"n"`
+	Servers []string `json:"servers"`
+}
+
+type RemoveResponse struct {
+	Message struct {
+		N       int      `json:"N"`
+		Servers []string `json:"servers"`
+	} `json:"message"`
+	Status string `json:"status"`
+}
+
+// Row is one entry of the table, keyed by column name. Numbers read back are
+// json.Numbers, so that keys too large for a float64 stay exact.
+type Row map[string]interface{}
+
+// ReadOptions are how reads and queries read the shards. Consistency is
+// "ONE", "QUORUM" or "ALL". Parallelism caps how many shards are read at once
+// and TimeoutMs how long the whole read may take, 0 meaning the defaults.
+// HedgeAfterMs, if set, is how long a shard's replica may take before the
+// read is also sent to another.
+type ReadOptions struct {
+	Consistency  string `json:"consistency,omitempty"`
+	Parallelism  int    `json:"parallelism,omitempty"`
+	TimeoutMs    int    `json:"timeout_ms,omitempty"`
+	HedgeAfterMs int    `json:"hedge_after_ms,omitempty"`
+}
+
+// KeyRange is the range of shard keys from Low to High, both included.
+type KeyRange struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// ReadRequest selects rows by a range of the shard key. With AllowPartial
+// shards that fail are reported in the response instead of failing the read.
+type ReadRequest struct {
+	StudID KeyRange `json:"Stud_id"`
+	ReadOptions
+	AllowPartial bool `json:"allow_partial,omitempty"`
+}
+
+// ReadResponse has the rows in key order. Errors holds, by shard id, why the
+// shards that could not be read failed, for reads that allow partial results.
+type ReadResponse struct {
+	ShardsQueried []string          `json:"shards_queried"`
+	Data          []Row             `json:"data"`
+	Errors        map[string]string `json:"errors,omitempty"`
+	Status        string            `json:"status"`
+}
+
+// Predicate compares a column with a value: Op is one of =, !=, <, <=, >, >=,
+// LIKE or IN, which takes a list. = and != with a null value test for null.
+type Predicate struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+}
+
+// OrderBy sorts by a column, ascending unless Desc is set.
+type OrderBy struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// QueryRequest selects the Columns, all if empty, of the rows that match every
+// predicate in Where, sorted by OrderBy and then by the shard key. With Limit
+// set at most that many rows are returned, and Cursor, the NextCursor of the
+// previous page, continues after its last row.
+type QueryRequest struct {
+	Columns []string    `json:"columns,omitempty"`
+	Where   []Predicate `json:"where,omitempty"`
+	OrderBy []OrderBy   `json:"order_by,omitempty"`
+	Limit   int         `json:"limit,omitempty"`
+	Cursor  string      `json:"cursor,omitempty"`
+	ReadOptions
+}
+
+// QueryResponse has a NextCursor when there are rows after the last one.
+type QueryResponse struct {
+	ShardsQueried []string `json:"shards_queried"`
+	Data          []Row    `json:"data"`
+	NextCursor    string   `json:"next_cursor,omitempty"`
+	Status        string   `json:"status"`
+}
+
+// WriteRequest adds rows. Rows for several shards are written to all of them
+// or none.
+type WriteRequest struct {
+	Data        []Row  `json:"data"`
+	Consistency string `json:"consistency,omitempty"`
+}
+
+type WriteResponse struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// UpdateRequest sets the columns in Data of the row with shard key StudID.
+type UpdateRequest struct {
+	StudID      int    `json:"Stud_id"`
+	Data        Row    `json:"data"`
+	Consistency string `json:"consistency,omitempty"`
+}
+
+type UpdateResponse struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// DeleteRequest removes the row with shard key StudID.
+type DeleteRequest struct {
+	StudID      int    `json:"Stud_id"`
+	Consistency string `json:"consistency,omitempty"`
+}
+
+type DeleteResponse struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
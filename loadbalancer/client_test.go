@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/client"
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// convert copies v into out through JSON, as the load balancer would decode
+// it.
+func convert(t *testing.T, v interface{}, out interface{}) {
+	t.Helper()
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(payload, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fromClientRows(rows []client.Row) []Row {
+	converted := []Row{}
+	for _, row := range rows {
+		converted = append(converted, Row(row))
+	}
+	return converted
+}
+
+func TestClient(t *testing.T) {
+	c := newTestCluster(t)
+	ctx := context.Background()
+	lb := client.New(c.lb.URL, client.Options{})
+
+	var initReq client.InitRequest
+	convert(t, testInitRequest(), &initReq)
+	if err := lb.Init(ctx, initReq); err != nil {
+		t.Fatal(err)
+	}
+	status, err := lb.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.N != 3 || len(status.Shards) != 3 || len(status.Servers["Server1"]) != 2 || status.Weights["Server2"] != 1 {
+		t.Errorf("got status %+v, want the cluster of testInitRequest", status)
+	}
+
+	var rows []client.Row
+	convert(t, testStudents, &rows)
+	if _, err := lb.Write(ctx, client.WriteRequest{Data: rows, Consistency: "ALL"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.Update(ctx, client.UpdateRequest{StudID: 10, Data: client.Row{"Stud_marks": 99}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.Delete(ctx, client.DeleteRequest{StudID: 150}); err != nil {
+		t.Fatal(err)
+	}
+	read, err := lb.Read(ctx, client.ReadRequest{StudID: client.KeyRange{Low: 0, High: 299}, ReadOptions: client.ReadOptions{Consistency: "QUORUM"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Row{student(10, "Aarav", 99), testStudents[1], testStudents[3], testStudents[4]}
+	assertRows(t, fromClientRows(read.Data), want)
+
+	// pages of one row by marks going up: Meera 47, Diya 64, Rohan 75, Aarav 99
+	pages := lb.QueryPages(client.QueryRequest{Columns: []string{"Stud_name"}, OrderBy: []client.OrderBy{{Column: "Stud_marks"}}, Limit: 1})
+	names := []Row{}
+	for pages.Next(ctx) {
+		if len(names) > 4 {
+			t.Fatal("more than 4 pages of 1 row for 4 rows")
+		}
+		names = append(names, fromClientRows(pages.Page().Data)...)
+	}
+	if err := pages.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertRows(t, names, []Row{{"Stud_name": "Meera"}, {"Stud_name": "Diya"}, {"Stud_name": "Rohan"}, {"Stud_name": "Aarav"}})
+
+	all, err := lb.QueryAll(ctx, client.QueryRequest{Where: []client.Predicate{{Column: "Stud_marks", Op: ">", Value: 70}}, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRows(t, fromClientRows(all), []Row{want[0], want[3]})
+
+	// failures come back as the error envelope
+	_, err = lb.Write(ctx, client.WriteRequest{Data: []client.Row{{"Stud_name": "Anika"}}})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != client.BadRequest || client.Retryable(err) {
+		t.Errorf("got %v writing a row without a key, want a bad_request that is not retryable", err)
+	}
+
+	added, err := lb.Add(ctx, client.AddRequest{N: 1, NewShards: []client.Shard{{StudIDLow: 300, ShardID: "sh4", ShardSize: 100}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.N != 4 {
+		t.Errorf("got %d servers after adding one, want 4", added.N)
+	}
+	removed, err := lb.Remove(ctx, client.RemoveRequest{N: 1, Servers: []string{"Server4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Message.N != 3 || len(removed.Message.Servers) != 1 || removed.Message.Servers[0] != "Server4" {
+		t.Errorf("got %+v removing Server4", removed.Message)
+	}
+}
+
+// TestClientCodes checks the client, which keeps its own copy of the error
+// codes, decodes every code the load balancer answers with.
+func TestClientCodes(t *testing.T) {
+	codes := map[apierror.Code]client.Code{
+		apierror.BadRequest:       client.BadRequest,
+		apierror.NotFound:         client.NotFound,
+		apierror.MethodNotAllowed: client.MethodNotAllowed,
+		apierror.Conflict:         client.Conflict,
+		apierror.IndexConflict:    client.IndexConflict,
+		apierror.TermConflict:     client.TermConflict,
+		apierror.Internal:         client.Internal,
+		apierror.ServerError:      client.ServerError,
+		apierror.Unavailable:      client.Unavailable,
+		apierror.Timeout:          client.Timeout,
+	}
+	for code, clientCode := range codes {
+		if string(code) != string(clientCode) || code.Retryable() != clientCode.Retryable() {
+			t.Errorf("client code %q does not match %q", clientCode, code)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
+	"github.com/Sarita-Singh/galaxyDB/server/shardserver"
+)
+
+// inProcessServer is one shard server running on an httptest server with its
+// own temporary SQLite file.
+type inProcessServer struct {
+	httpServer  *httptest.Server
+	db          *sql.DB
+	unavailable atomic.Bool
+	delay       atomic.Int64
+	reads       atomic.Int64
+}
+
+// inProcessOrchestrator implements orchestrator.Orchestrator by running the
+// shard server handlers inside the test process.
+type inProcessOrchestrator struct {
+	t       *testing.T
+	mutex   sync.Mutex
+	servers map[string]*inProcessServer
+}
+
+func (o *inProcessOrchestrator) Build(string) error { return nil }
+
+func (o *inProcessOrchestrator) Spawn(hostname string, id int) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if _, ok := o.servers[hostname]; ok {
+		return fmt.Errorf("server instance '%s' is already running", hostname)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(o.t.TempDir(), hostname+".db"))
+	if err != nil {
+		return err
+	}
+	server, err := shardserver.New(fmt.Sprint(id), db)
+	if err != nil {
+		return err
+	}
+
+	instance := &inProcessServer{db: db}
+	handler := server.Handler()
+	instance.httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if instance.unavailable.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/read" {
+			instance.reads.Add(1)
+		}
+		time.Sleep(time.Duration(instance.delay.Load()))
+		handler.ServeHTTP(w, r)
+	}))
+	o.servers[hostname] = instance
+	return nil
+}
+
+// setAvailable makes a server fail every request while unavailable, without
+// stopping it, as if it were cut off from the rest of the cluster.
+func (o *inProcessOrchestrator) setAvailable(hostname string, available bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.servers[hostname].unavailable.Store(!available)
+}
+
+// setDelay makes a server answer every request d late.
+func (o *inProcessOrchestrator) setDelay(hostname string, d time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.servers[hostname].delay.Store(int64(d))
+}
+
+// reads returns how many reads a server has been sent and starts counting
+// again.
+func (o *inProcessOrchestrator) reads(hostname string) int64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.servers[hostname].reads.Swap(0)
+}
+
+func (o *inProcessOrchestrator) Stop(hostname string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	server, ok := o.servers[hostname]
+	if !ok {
+		return fmt.Errorf("server instance '%s' is not running", hostname)
+	}
+	delete(o.servers, hostname)
+
+	server.httpServer.Close()
+	return server.db.Close()
+}
+
+func (o *inProcessOrchestrator) Address(hostname string) (string, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	server, ok := o.servers[hostname]
+	if !ok {
+		return "", fmt.Errorf("server instance '%s' is not running", hostname)
+	}
+	return strings.TrimPrefix(server.httpServer.URL, "http://"), nil
+}
+
+func (o *inProcessOrchestrator) List() ([]string, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	hostnames := []string{}
+	for hostname := range o.servers {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	return hostnames, nil
+}
+
+// testCluster is a load balancer and its shard servers running in the test
+// process. The load balancer keeps its state in package globals, so only one
+// testCluster may be alive at a time.
+type testCluster struct {
+	t      *testing.T
+	orch   *inProcessOrchestrator
+	lb     *httptest.Server
+	dbPath string
+}
+
+func newTestCluster(t *testing.T) *testCluster {
+	t.Helper()
+
+	testOrch := &inProcessOrchestrator{t: t, servers: make(map[string]*inProcessServer)}
+
+	c := &testCluster{
+		t:      t,
+		orch:   testOrch,
+		dbPath: filepath.Join(t.TempDir(), DB_FILENAME),
+	}
+	orch = testOrch
+	c.startLoadBalancer()
+
+	t.Cleanup(func() {
+		c.lb.Close()
+		stopBackground()
+		cleanupServers(getServerIDs())
+		setServerIDs(nil)
+		db.Close()
+	})
+
+	return c
+}
+
+// startLoadBalancer sets up the load balancer state from the cluster database
+// the way main does, recovering whatever an earlier run left in it.
+func (c *testCluster) startLoadBalancer() {
+	c.t.Helper()
+
+	var err error
+	db, err = openDatabase(c.dbPath)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	schemaConfig = SchemaConfig{}
+	hashRingOptions = consistenthashmap.Options{}
+	readRouting = DEFAULT_READ_ROUTING
+	serverLoads = map[int]*serverLoad{}
+	heartbeatChecks = map[int]chan struct{}{}
+	resetShardTConfigs()
+	setServerIDs(nil)
+	serverDown = make(chan int)
+	backgroundStop = make(chan struct{})
+
+	if err := recoverState(); err != nil {
+		c.t.Fatal(err)
+	}
+	c.lb = httptest.NewServer(newRouter())
+}
+
+// restartLoadBalancer throws away all in-memory load balancer state while the
+// shard servers keep running.
+func (c *testCluster) restartLoadBalancer() {
+	c.t.Helper()
+
+	c.lb.Close()
+	stopBackground()
+	setServerIDs(nil)
+	db.Close()
+	c.startLoadBalancer()
+}
+
+// do sends a JSON request to the load balancer and decodes the JSON response
+// into out, returning the status code.
+func (c *testCluster) do(method string, endpoint string, in interface{}, out interface{}) int {
+	c.t.Helper()
+
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		body = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequest(method, c.lb.URL+endpoint, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: error decoding response: %v", method, endpoint, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// mustDo is do for requests that are expected to succeed.
+func (c *testCluster) mustDo(method string, endpoint string, in interface{}, out interface{}) {
+	c.t.Helper()
+
+	if status := c.do(method, endpoint, in, out); status != http.StatusOK {
+		c.t.Fatalf("%s %s: got status %d, want %d", method, endpoint, status, http.StatusOK)
+	}
+}
+
+var testSchema = SchemaConfig{
+	Columns: []string{"Stud_id", "Stud_name", "Stud_marks"},
+	Dtypes:  []string{"Number", "String", "Number"},
+}
+
+// testInitRequest describes a cluster of three shards of size 100 placed on
+// three servers, with every shard on two of them.
+func testInitRequest() InitRequest {
+	return InitRequest{
+		N:      3,
+		Schema: testSchema,
+		Shards: []Shard{
+			{StudIDLow: 0, ShardID: "sh1", ShardSize: 100},
+			{StudIDLow: 100, ShardID: "sh2", ShardSize: 100},
+			{StudIDLow: 200, ShardID: "sh3", ShardSize: 100},
+		},
+		Servers: map[string][]string{
+			"Server1": {"sh1", "sh2"},
+			"Server2": {"sh2", "sh3"},
+			"Server3": {"sh3", "sh1"},
+		},
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// A consistency level is the number of replicas of a shard, out of the n it
+// has, that must apply a change before it is acknowledged, or that a read
+// consults: 1 for ONE, n/2+1 for QUORUM and n for ALL. Writes and reads at
+// QUORUM always share a replica, so such reads see every acknowledged write.
+
+func parseConsistency(level string, fallback string) (string, error) {
+	if level == "" {
+		return fallback, nil
+	}
+	level = strings.ToUpper(level)
+	switch level {
+	case CONSISTENCY_ONE, CONSISTENCY_QUORUM, CONSISTENCY_ALL:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown consistency level %q", level)
+	}
+}
+
+func requiredReplicas(level string, n int) int {
+	switch level {
+	case CONSISTENCY_ALL:
+		return n
+	case CONSISTENCY_QUORUM:
+		return n/2 + 1
+	default:
+		return 1
+	}
+}
+
+// checkAcks fails a change that fewer replicas acknowledged than its
+// consistency level needs. The change stays applied where it was, and the
+// primary keeps passing it on to the others.
+func checkAcks(shardID string, respData *ServerWriteResponse, needed int) error {
+	if respData.Acks < needed {
+		return fmt.Errorf("%s: change acknowledged by %d replicas, %d needed", shardID, respData.Acks, needed)
+	}
+	return nil
+}
+
+// isBadRequest reports a request a server turned down as invalid. Every
+// replica would turn it down, so it is neither retried nor held against the
+// server.
+func isBadRequest(err error) bool {
+	var respErr *serverError
+	return errors.As(err, &respErr) && respErr.status == http.StatusBadRequest
+}
+
+// readFromServer sends a read of a shard, a /read or a /query, to a server.
+func readFromServer(ctx context.Context, serverID int, endpoint string, payload interface{}) (result *ServerReadResponse, err error) {
+	done := trackRead(serverID)
+	defer func() {
+		done(err)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			serverErrors.Inc(fmt.Sprintf("Server%d", serverID), endpoint)
+		}
+	}()
+
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getServerURL(serverID, endpoint), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readServerError(serverID, resp)
+	}
+
+	var respData ServerReadResponse
+	if err := decodeJSON(resp.Body, &respData); err != nil {
+		return nil, err
+	}
+	return &respData, nil
+}
+
+// replicaRead is the answer of one replica to a read.
+type replicaRead struct {
+	serverID int
+	respData *ServerReadResponse
+	err      error
+}
+
+// readShard sends a read of a shard to as many replicas as level needs,
+// starting with the one chooseReplica picks. A replica that fails is retried
+// on another the shard's selector picks, and is reported to the failure
+// detector. With hedgeAfter set, a read still waiting on its replicas after
+// that long is also sent to one more replica, and whichever answers first is
+// used. It answers with the rows of the replica at the highest index, and the
+// others it read from are brought up to that index in the background (read
+// repair).
+// Replicas that have not answered when ctx is done count as failed.
+func readShard(ctx context.Context, shardID string, endpoint string, payload interface{}, level string, hedgeAfter time.Duration) (_ *ServerReadResponse, err error) {
+	shardReads.Inc(shardID)
+	ctx, span := tracing.Start(ctx, "read shard", tracing.SpanKindInternal)
+	span.SetAttribute("galaxydb.shard", shardID)
+	span.SetAttribute("galaxydb.consistency", level)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	serverIDs, err := getServerIDsForShard(db, shardID)
+	if err != nil {
+		return nil, err
+	}
+	needed := requiredReplicas(level, len(serverIDs))
+
+	// reads still running once enough replicas answered are cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reads := make(chan replicaRead, len(serverIDs))
+	tried := map[int]bool{}
+	inFlight := 0
+	send := func(serverID int, done func()) {
+		tried[serverID] = true
+		inFlight++
+		go func() {
+			defer done()
+			respData, err := readFromServer(ctx, serverID, endpoint, payload)
+			reads <- replicaRead{serverID, respData, err}
+		}()
+	}
+	sendNext := func() bool {
+		serverID, done := nextReplica(shardID, serverIDs, tried)
+		if serverID == -1 {
+			return false
+		}
+		send(serverID, done)
+		return true
+	}
+
+	if first, done := chooseReplica(shardID, serverIDs); first != -1 {
+		send(first, done)
+	}
+	for inFlight < needed && sendNext() {
+	}
+
+	var hedge <-chan time.Time
+	if hedgeAfter > 0 {
+		timer := time.NewTimer(hedgeAfter)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	responses := map[int]*ServerReadResponse{}
+	for len(responses) < needed && inFlight > 0 {
+		select {
+		case read := <-reads:
+			inFlight--
+			if isBadRequest(read.err) {
+				return nil, read.err
+			}
+			if read.err != nil {
+				slog.WarnContext(ctx, "error reading from server", "shard", shardID, "server", fmt.Sprintf("Server%d", read.serverID), logging.Error(read.err))
+				if ctx.Err() == nil {
+					suspectServer(read.serverID)
+				}
+				sendNext()
+				continue
+			}
+			responses[read.serverID] = read.respData
+		case <-hedge:
+			hedge = nil
+			if sendNext() {
+				slog.DebugContext(ctx, "hedging read", "shard", shardID, "after_ms", hedgeAfter.Milliseconds())
+			}
+		}
+	}
+	if len(responses) < needed || len(responses) == 0 {
+		return nil, fmt.Errorf("%s: %d replicas answered, %d needed", shardID, len(responses), needed)
+	}
+
+	freshest := -1
+	for serverID, respData := range responses {
+		if freshest == -1 || respData.CurrentIndex > responses[freshest].CurrentIndex {
+			freshest = serverID
+		}
+	}
+	behind := []int{}
+	for serverID, respData := range responses {
+		if respData.CurrentIndex != responses[freshest].CurrentIndex {
+			behind = append(behind, serverID)
+		}
+	}
+	if len(behind) > 0 {
+		repairReplicas(shardID, freshest, behind)
+	}
+
+	return responses[freshest], nil
+}
+
+// repairing holds the shards a read repair is running for, so reads that
+// find the same replicas behind do not start another.
+var (
+	repairingMutex sync.Mutex
+	repairing      = map[string]bool{}
+)
+
+// repairReplicas brings replicas of a shard that are behind up to the index
+// of source in the background, under the shard mutex, unless a repair of the
+// shard is already running.
+func repairReplicas(shardID string, sourceServerID int, serverIDs []int) {
+	repairingMutex.Lock()
+	defer repairingMutex.Unlock()
+	if repairing[shardID] {
+		return
+	}
+	repairing[shardID] = true
+
+	goBackground(func(stop <-chan struct{}) {
+		defer func() {
+			repairingMutex.Lock()
+			defer repairingMutex.Unlock()
+			delete(repairing, shardID)
+		}()
+
+		unlock, ok := lockShard(shardID)
+		if !ok {
+			return
+		}
+		defer unlock()
+		for _, serverID := range serverIDs {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			slog.Info("repairing replica", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID), "source", fmt.Sprintf("Server%d", sourceServerID))
+			if _, err := catchUpReplica(context.Background(), shardID, sourceServerID, serverID); err != nil {
+				slog.Warn("error repairing replica", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID), logging.Error(err))
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequiredReplicas(t *testing.T) {
+	tests := []struct {
+		level string
+		n     int
+		want  int
+	}{
+		{"one", 3, 1},
+		{"QUORUM", 1, 1},
+		{"QUORUM", 2, 2},
+		{"Quorum", 3, 2},
+		{"QUORUM", 4, 3},
+		{"ALL", 3, 3},
+		{"", 3, 1},
+	}
+
+	for _, test := range tests {
+		level, err := parseConsistency(test.level, CONSISTENCY_ONE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := requiredReplicas(level, test.n); got != test.want {
+			t.Errorf("%s of %d: got %d replicas, want %d", test.level, test.n, got, test.want)
+		}
+	}
+
+	if _, err := parseConsistency("TWO", CONSISTENCY_ONE); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestConsistencyLevels(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	if status := c.do("POST", "/write", WriteRequest{Data: testStudents[:1], Consistency: "TWO"}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown level, want 400", status)
+	}
+
+	// cut off the replica of sh1 that is not its primary
+	c.orch.setAvailable("Server3", false)
+
+	if status := c.do("PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 91}, Consistency: "ALL"}, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("got status %d for an update at ALL with a replica down, want 503", status)
+	}
+	if status := c.do("PUT", "/update", UpdateRequest{StudID: 20, Data: Row{"Stud_marks": 92}, Consistency: "QUORUM"}, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("got status %d for an update at QUORUM with 1 of 2 replicas, want 503", status)
+	}
+	c.mustDo("DELETE", "/del", DeleteRequest{StudID: 20, Consistency: "ONE"}, nil)
+
+	var req ReadRequest
+	req.StudID.Low, req.StudID.High = 0, 99
+	req.Consistency = "ALL"
+	if status := c.do("POST", "/read", req, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("got status %d for a read at ALL with a replica down, want 503", status)
+	}
+
+	// the replica comes back behind; a read at QUORUM sees the latest changes
+	// and has it repaired in the background
+	c.orch.setAvailable("Server3", true)
+	want := []Row{student(10, "Aarav", 91)}
+	if idx, err := getServerIndex(context.Background(), 3, "sh1"); err != nil || idx == c.validIdx("sh1") {
+		t.Fatalf("Server3 is at index %d (%v), want it behind", idx, err)
+	}
+
+	req.Consistency = "QUORUM"
+	var resp ReadResponse
+	c.mustDo("POST", "/read", req, &resp)
+	assertRows(t, resp.Data, want)
+
+	var idx int
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		idx, err = getServerIndex(context.Background(), 3, "sh1")
+		if (err == nil && idx == c.validIdx("sh1")) || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil || idx != c.validIdx("sh1") {
+		t.Errorf("Server3 is at index %d (%v) after read repair, want %d", idx, err, c.validIdx("sh1"))
+	}
+	assertRows(t, c.shardData(3, "sh1"), want)
+}
@@ -1,18 +1,57 @@
 package main
 
+import "time"
+
 const (
-	SERVER_DOCKER_IMAGE_NAME = "galaxydb-server"
-	DOCKER_NETWORK_NAME      = "galaxydb-network"
-	SERVER_PORT              = 5000
-	DB_FILENAME              = "galaxy-lb.db"
-	INIT_DB                  = `CREATE TABLE IF NOT EXISTS shardt (
+	CONSISTENCY_ONE    = "ONE"
+	CONSISTENCY_QUORUM = "QUORUM"
+	CONSISTENCY_ALL    = "ALL"
+
+	DEFAULT_READ_CONSISTENCY  = CONSISTENCY_ONE
+	DEFAULT_WRITE_CONSISTENCY = CONSISTENCY_QUORUM
+)
+
+// DEFAULT_REPLICAS is how many copies of each shard the placement planner
+// makes when /init does not say, or fewer if there are fewer servers.
+const DEFAULT_REPLICAS = 3
+
+// RECONCILE_INTERVAL is how often the load balancer looks for shards with
+// fewer replicas than their replication factor.
+const RECONCILE_INTERVAL = 10 * time.Second
+
+const (
+	DB_FILENAME = "galaxy-lb.db"
+	INIT_DB     = `CREATE TABLE IF NOT EXISTS shardt (
 									stud_id_low INT PRIMARY KEY,
 									shard_id TEXT,
 									shard_size INT,
-									valid_idx INT
+									valid_idx INT,
+									primary_server INT,
+									term INT,
+									replicas INT,
+									selector TEXT
 								);
 								CREATE TABLE IF NOT EXISTS mapt (
 									shard_id TEXT,
-									server_id INT
+									server_id INT,
+									UNIQUE (shard_id, server_id)
+								);
+								-- databases made before mapt had its constraint
+								DELETE FROM mapt WHERE rowid NOT IN (SELECT MIN(rowid) FROM mapt GROUP BY shard_id, server_id);
+								CREATE UNIQUE INDEX IF NOT EXISTS mapt_shard_server ON mapt (shard_id, server_id);
+								CREATE TABLE IF NOT EXISTS servert (
+									server_id INT PRIMARY KEY,
+									weight INT
+								);
+								CREATE TABLE IF NOT EXISTS txnt (
+									tx_id TEXT,
+									shard_id TEXT,
+									curr_idx INT,
+									data TEXT,
+									PRIMARY KEY (tx_id, shard_id)
+								);
+								CREATE TABLE IF NOT EXISTS configt (
+									key TEXT PRIMARY KEY,
+									value TEXT
 								);`
 )
@@ -2,4 +2,9 @@ module github.com/Sarita-Singh/galaxyDB/loadbalancer
 
 go 1.21.0
 
-require github.com/mattn/go-sqlite3 v1.14.22
+require (
+	github.com/Sarita-Singh/galaxyDB/server v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
+replace github.com/Sarita-Singh/galaxyDB/server => ../server
@@ -1,84 +1,223 @@
 package consistenthashmap
 
+import (
+	"fmt"
+	"sync"
+)
+
+// Default ring settings, used for any option left at zero.
 const (
 	Slots = 512
 	K     = 9
 )
 
+// Probing is how a virtual server looks for another slot when the one it
+// hashes to is taken.
+type Probing string
+
+const (
+	Linear    Probing = "linear"
+	Quadratic Probing = "quadratic"
+	Double    Probing = "double"
+)
+
+// Options configure a ring: how many slots it has, how many virtual servers
+// each server gets and how collisions between them are resolved.
+type Options struct {
+	Slots        int     `json:"slots,omitempty"`
+	VirtualNodes int     `json:"virtual_nodes,omitempty"`
+	Probing      Probing `json:"probing,omitempty"`
+}
+
+// WithDefaults fills in the options left at zero.
+func (o Options) WithDefaults() Options {
+	if o.Slots == 0 {
+		o.Slots = Slots
+	}
+	if o.VirtualNodes == 0 {
+		o.VirtualNodes = K
+	}
+	if o.Probing == "" {
+		o.Probing = Linear
+	}
+	return o
+}
+
+// Validate reports options a ring cannot be built with.
+func (o Options) Validate() error {
+	o = o.WithDefaults()
+	if o.Slots < 1 {
+		return fmt.Errorf("slots must be positive, got %d", o.Slots)
+	}
+	if o.VirtualNodes < 1 {
+		return fmt.Errorf("virtual_nodes must be positive, got %d", o.VirtualNodes)
+	}
+	switch o.Probing {
+	case Linear, Quadratic, Double:
+		return nil
+	default:
+		return fmt.Errorf("unknown probing %q, expected %s, %s or %s", o.Probing, Linear, Quadratic, Double)
+	}
+}
+
 // New hash functions
 func H(i uint32) uint32 {
-	i = (((i >> 16) ^ i) * 0x45d9f3b) >> 16 ^ i
+	i = (((i>>16)^i)*0x45d9f3b)>>16 ^ i
 	return i
 }
 
 func assistH(i, j uint32) uint32 {
 	return H(i + H(j))
 }
+
 func hashRequest(i int) int {
-	return int(assistH(uint32(i), uint32(i))) 
+	return int(assistH(uint32(i), uint32(i)))
 }
 
 func hashVirtualServer(i, j int) int {
-	return int(assistH(uint32(i), uint32(j))) 
+	return int(assistH(uint32(i), uint32(j)))
 }
+
 type ConsistentHashMap struct {
-	virtualServers [Slots]int
+	mutex          sync.RWMutex
+	options        Options
+	virtualServers []int
+	// the slots each server's virtual servers ended up in
+	serverSlots map[int][]int
+}
+
+// New returns an empty ring with the given options.
+func New(options Options) *ConsistentHashMap {
+	hm := &ConsistentHashMap{options: options}
+	hm.Init()
+	return hm
 }
 
+// Options returns the options of the ring, with the defaults filled in.
+func (hm *ConsistentHashMap) Options() Options {
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+	return hm.options
+}
+
+// Init empties the ring, keeping the options it was created with or the
+// defaults.
 func (hm *ConsistentHashMap) Init() {
-	for i := 0; i < Slots; i++ {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	hm.options = hm.options.WithDefaults()
+	hm.virtualServers = make([]int, hm.options.Slots)
+	for i := range hm.virtualServers {
 		hm.virtualServers[i] = -1
 	}
+	hm.serverSlots = map[int][]int{}
+}
+
+// probe returns the i-th slot to try for a virtual server hashing to
+// hashValue.
+func (hm *ConsistentHashMap) probe(hashValue int, i int) int {
+	slots := hm.options.Slots
+	switch hm.options.Probing {
+	case Quadratic:
+		// triangular numbers visit every slot when slots is a power of two
+		return (hashValue + i*(i+1)/2) % slots
+	case Double:
+		step := 1
+		if slots > 1 {
+			step += int(H(uint32(hashValue))) % (slots - 1)
+		}
+		return (hashValue + i*step) % slots
+	default:
+		return (hashValue + i) % slots
+	}
 }
 
 func (hm *ConsistentHashMap) findEmptyServerSlot(hashValue int) int {
-	slot := hashValue % Slots
-	i := 0
-	for hm.virtualServers[slot] != -1 && i <= Slots {
-		slot = (slot + 1) % Slots
-		i++
+	slots := hm.options.Slots
+	for i := 0; i < slots; i++ {
+		slot := hm.probe(hashValue, i)
+		if hm.virtualServers[slot] == -1 {
+			return slot
+		}
 	}
-	if i > Slots {
-		return -1
+	// quadratic and double hashing do not reach every slot for all slot
+	// counts, so fall back to a scan before giving up
+	for i := 0; i < slots; i++ {
+		slot := (hashValue + i) % slots
+		if hm.virtualServers[slot] == -1 {
+			return slot
+		}
 	}
-	return slot
+	return -1
 }
 
-// Updated to use the Phi hash function
+// AddServer places the virtual servers of a server on the ring. Virtual
+// servers that find no free slot are left out.
+func (hm *ConsistentHashMap) AddServer(serverID int) {
+	hm.AddWeightedServer(serverID, 1)
+}
 
+// AddWeightedServer places a server on the ring with weight times as many
+// virtual servers as AddServer, so it gets about weight times the requests.
+func (hm *ConsistentHashMap) AddWeightedServer(serverID int, weight int) {
+	hm.SetWeight(serverID, weight)
+}
 
-func (hm *ConsistentHashMap) AddServer(serverID int) {
-	for j := 0; j < K; j++ {
+// SetWeight changes the weight of a server, adding it if it is not on the
+// ring. Only the virtual servers added or removed move, so requests for the
+// rest keep going where they went.
+func (hm *ConsistentHashMap) SetWeight(serverID int, weight int) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	want := hm.options.VirtualNodes * max(weight, 1)
+	slots := hm.serverSlots[serverID]
+	for len(slots) > want {
+		hm.virtualServers[slots[len(slots)-1]] = -1
+		slots = slots[:len(slots)-1]
+	}
+	for j := len(slots); j < want; j++ {
 		slot := hm.findEmptyServerSlot(hashVirtualServer(serverID, j))
+		if slot == -1 {
+			break
+		}
 		hm.virtualServers[slot] = serverID
+		slots = append(slots, slot)
 	}
+	hm.serverSlots[serverID] = slots
 }
 
 func (hm *ConsistentHashMap) GetServerForRequest(requestID int) int {
-	slot := hashRequest(requestID) % Slots
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+	slots := hm.options.Slots
+	slot := hashRequest(requestID) % slots
 	i := 0
-	for hm.virtualServers[slot] == -1 && i <= Slots {
-		slot = (slot + 1) % Slots
+	for hm.virtualServers[slot] == -1 && i <= slots {
+		slot = (slot + 1) % slots
 		i++
 	}
-	if i > Slots {
+	if i > slots {
 		return -1
 	}
 	return hm.virtualServers[slot]
 }
 
+// SlotCounts returns how many slots of the ring each server holds.
+func (hm *ConsistentHashMap) SlotCounts() map[int]int {
+	hm.mutex.RLock()
+	defer hm.mutex.RUnlock()
+	counts := map[int]int{}
+	for serverID, slots := range hm.serverSlots {
+		counts[serverID] = len(slots)
+	}
+	return counts
+}
+
 func (hm *ConsistentHashMap) RemoveServer(serverID int) {
-	for j := 0; j < K; j++ {
-		virtualServerHash := hashVirtualServer(serverID, j)
-		slot := virtualServerHash % Slots
-		if hm.virtualServers[slot] == serverID {
-			hm.virtualServers[slot] = -1
-
-			nextSlot := (slot + 1) % Slots
-			for hm.virtualServers[nextSlot] == serverID {
-				hm.virtualServers[nextSlot] = -1
-				nextSlot = (nextSlot + 1) % Slots
-			}
-		}
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	for _, slot := range hm.serverSlots[serverID] {
+		hm.virtualServers[slot] = -1
 	}
+	delete(hm.serverSlots, serverID)
 }
@@ -0,0 +1,92 @@
+package consistenthashmap
+
+import "testing"
+
+func TestProbing(t *testing.T) {
+	for _, probing := range []Probing{Linear, Quadratic, Double} {
+		t.Run(string(probing), func(t *testing.T) {
+			hm := New(Options{Slots: 64, VirtualNodes: 8, Probing: probing})
+
+			// eight servers with eight virtual servers each fill every slot
+			for serverID := 1; serverID <= 8; serverID++ {
+				hm.AddServer(serverID)
+			}
+			counts := map[int]int{}
+			for _, serverID := range hm.virtualServers {
+				counts[serverID]++
+			}
+			for serverID := 1; serverID <= 8; serverID++ {
+				if counts[serverID] != 8 {
+					t.Errorf("Server%d has %d slots, want 8", serverID, counts[serverID])
+				}
+			}
+
+			// a full ring leaves further servers out
+			hm.AddServer(9)
+			if len(hm.serverSlots[9]) != 0 {
+				t.Errorf("Server9 got %d slots on a full ring", len(hm.serverSlots[9]))
+			}
+
+			hm.RemoveServer(3)
+			for slot, serverID := range hm.virtualServers {
+				if serverID == 3 {
+					t.Errorf("slot %d still holds Server3", slot)
+				}
+			}
+			for requestID := 0; requestID < 1000; requestID++ {
+				if serverID := hm.GetServerForRequest(requestID); serverID < 1 || serverID > 8 || serverID == 3 {
+					t.Fatalf("request %d went to Server%d", requestID, serverID)
+				}
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if got := New(Options{}).Options(); got != (Options{Slots: Slots, VirtualNodes: K, Probing: Linear}) {
+		t.Errorf("got default options %+v", got)
+	}
+	for _, options := range []Options{{Slots: -1}, {VirtualNodes: -2}, {Probing: "cubic"}} {
+		if err := options.Validate(); err == nil {
+			t.Errorf("options %+v are valid", options)
+		}
+	}
+	if err := (Options{Slots: 1000, Probing: Double}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	hm := New(Options{Slots: 4096, VirtualNodes: 16})
+	hm.AddServer(1)
+	hm.AddWeightedServer(2, 3)
+	if got := len(hm.serverSlots[1]); got != 16 {
+		t.Errorf("Server1 has %d slots, want 16", got)
+	}
+	if got := len(hm.serverSlots[2]); got != 48 {
+		t.Errorf("Server2 has %d slots, want 48", got)
+	}
+
+	before := map[int]int{}
+	for slot, serverID := range hm.virtualServers {
+		if serverID != -1 {
+			before[slot] = serverID
+		}
+	}
+
+	// lowering the weight only frees slots of that server
+	hm.SetWeight(2, 1)
+	if got := len(hm.serverSlots[2]); got != 16 {
+		t.Errorf("Server2 has %d slots after SetWeight, want 16", got)
+	}
+	for slot, serverID := range hm.virtualServers {
+		if serverID != -1 && before[slot] != serverID {
+			t.Errorf("slot %d moved from Server%d to Server%d", slot, before[slot], serverID)
+		}
+	}
+
+	hm.SetWeight(3, 2)
+	if got := len(hm.serverSlots[3]); got != 32 {
+		t.Errorf("Server3 has %d slots after SetWeight, want 32", got)
+	}
+}
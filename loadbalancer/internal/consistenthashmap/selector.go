@@ -0,0 +1,315 @@
+package consistenthashmap
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ReplicaSelector picks the replica of a shard a request goes to. Servers are
+// added with a capacity weight, and a server of weight w should get about w
+// times the requests of one of weight 1. Done tells the selector a request
+// sent to a server has finished, for selectors that track load. Selectors are
+// safe for concurrent use.
+type ReplicaSelector interface {
+	AddServer(serverID int)
+	AddWeightedServer(serverID int, weight int)
+	SetWeight(serverID int, weight int)
+	RemoveServer(serverID int)
+	GetServerForRequest(requestID int) int
+	Done(serverID int)
+}
+
+// Names of the selectors, as given in a shard's config.
+const (
+	SelectorRing       = "ring"
+	SelectorRendezvous = "rendezvous"
+	SelectorJump       = "jump"
+	SelectorBounded    = "bounded"
+)
+
+// BOUNDED_LOAD_FACTOR is how far above its share of the requests in flight a
+// server may go under bounded-load consistent hashing.
+const BOUNDED_LOAD_FACTOR = 1.25
+
+// ValidateSelector reports an unknown selector name. The empty name stands for
+// the ring.
+func ValidateSelector(name string) error {
+	switch name {
+	case "", SelectorRing, SelectorRendezvous, SelectorJump, SelectorBounded:
+		return nil
+	default:
+		return fmt.Errorf("unknown selector %q, expected %s, %s, %s or %s", name, SelectorRing, SelectorRendezvous, SelectorJump, SelectorBounded)
+	}
+}
+
+// NewSelector returns an empty selector of the named kind. Ring options apply
+// to the ring and to bounded-load hashing, which walks a ring.
+func NewSelector(name string, options Options) (ReplicaSelector, error) {
+	switch name {
+	case "", SelectorRing:
+		return New(options), nil
+	case SelectorRendezvous:
+		return NewRendezvous(), nil
+	case SelectorJump:
+		return NewJump(), nil
+	case SelectorBounded:
+		return NewBoundedLoad(options, BOUNDED_LOAD_FACTOR), nil
+	default:
+		return nil, ValidateSelector(name)
+	}
+}
+
+// Done does nothing, the ring does not track load.
+func (hm *ConsistentHashMap) Done(int) {}
+
+// walk calls visit with the servers on the ring clockwise from where a
+// request hashes to, each server once, until visit returns false. It does not
+// take the ring's mutex, so callers must keep the ring from changing.
+func (hm *ConsistentHashMap) walk(requestID int, visit func(serverID int) bool) {
+	slots := hm.options.Slots
+	start := hashRequest(requestID) % slots
+	seen := map[int]bool{}
+	for i := 0; i < slots && len(seen) < len(hm.serverSlots); i++ {
+		serverID := hm.virtualServers[(start+i)%slots]
+		if serverID == -1 || seen[serverID] {
+			continue
+		}
+		seen[serverID] = true
+		if !visit(serverID) {
+			return
+		}
+	}
+}
+
+// mix is the finalizer of SplitMix64. Rendezvous hashing needs the scores of
+// one request on different servers to be independent, which H applied to the
+// sum of both ids does not give.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Rendezvous is highest random weight hashing: a request goes to the server
+// with the highest score for it. Adding or removing a server only moves the
+// requests it wins or won.
+type Rendezvous struct {
+	mutex   sync.RWMutex
+	weights map[int]int
+}
+
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{weights: map[int]int{}}
+}
+
+func (r *Rendezvous) AddServer(serverID int) {
+	r.AddWeightedServer(serverID, 1)
+}
+
+func (r *Rendezvous) AddWeightedServer(serverID int, weight int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.weights[serverID] = max(weight, 1)
+}
+
+func (r *Rendezvous) SetWeight(serverID int, weight int) {
+	r.AddWeightedServer(serverID, weight)
+}
+
+func (r *Rendezvous) RemoveServer(serverID int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.weights, serverID)
+}
+
+func (r *Rendezvous) GetServerForRequest(requestID int) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	chosen, best := -1, 0.0
+	for serverID, weight := range r.weights {
+		// -w/ln(u) for u uniform in (0, 1) is the weighted score of
+		// logarithmic rendezvous hashing
+		u := (float64(mix(uint64(uint32(requestID))<<32|uint64(uint32(serverID)))>>11) + 0.5) / (1 << 53)
+		score := -float64(weight) / math.Log(u)
+		if chosen == -1 || score > best || (score == best && serverID < chosen) {
+			chosen, best = serverID, score
+		}
+	}
+	return chosen
+}
+
+func (r *Rendezvous) Done(int) {}
+
+// Jump is jump consistent hashing over the servers in order of id, each
+// taking as many buckets as its weight. It needs no memory beyond the list of
+// buckets, but only adding or removing the server with the highest id moves
+// the minimum of requests.
+type Jump struct {
+	mutex   sync.RWMutex
+	weights map[int]int
+	buckets []int
+}
+
+func NewJump() *Jump {
+	return &Jump{weights: map[int]int{}}
+}
+
+func (j *Jump) AddServer(serverID int) {
+	j.AddWeightedServer(serverID, 1)
+}
+
+func (j *Jump) AddWeightedServer(serverID int, weight int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.weights[serverID] = max(weight, 1)
+	j.rebuild()
+}
+
+func (j *Jump) SetWeight(serverID int, weight int) {
+	j.AddWeightedServer(serverID, weight)
+}
+
+func (j *Jump) RemoveServer(serverID int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	delete(j.weights, serverID)
+	j.rebuild()
+}
+
+// rebuild lays out the buckets again. Callers must hold the mutex.
+func (j *Jump) rebuild() {
+	serverIDs := []int{}
+	for serverID := range j.weights {
+		serverIDs = append(serverIDs, serverID)
+	}
+	sort.Ints(serverIDs)
+
+	j.buckets = []int{}
+	for _, serverID := range serverIDs {
+		for i := 0; i < j.weights[serverID]; i++ {
+			j.buckets = append(j.buckets, serverID)
+		}
+	}
+}
+
+// jumpHash maps a key to one of n buckets, as in Lamping and Veach, "A Fast,
+// Minimal Memory, Consistent Hash Algorithm".
+func jumpHash(key uint64, n int) int {
+	b, next := int64(-1), int64(0)
+	for next < int64(n) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (j *Jump) GetServerForRequest(requestID int) int {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	if len(j.buckets) == 0 {
+		return -1
+	}
+	return j.buckets[jumpHash(mix(uint64(requestID)), len(j.buckets))]
+}
+
+func (j *Jump) Done(int) {}
+
+// BoundedLoad is consistent hashing with bounded loads: a request goes to the
+// first server clockwise on the ring that has fewer requests in flight than
+// factor times its share of them, so no server gets far more than its share
+// even when requests cluster on the ring.
+type BoundedLoad struct {
+	ring    *ConsistentHashMap
+	factor  float64
+	weights map[int]int
+
+	mutex sync.Mutex
+	load  map[int]int
+	total int
+}
+
+func NewBoundedLoad(options Options, factor float64) *BoundedLoad {
+	return &BoundedLoad{
+		ring:    New(options),
+		factor:  factor,
+		weights: map[int]int{},
+		load:    map[int]int{},
+	}
+}
+
+func (b *BoundedLoad) AddServer(serverID int) {
+	b.AddWeightedServer(serverID, 1)
+}
+
+func (b *BoundedLoad) AddWeightedServer(serverID int, weight int) {
+	b.SetWeight(serverID, weight)
+}
+
+func (b *BoundedLoad) SetWeight(serverID int, weight int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ring.SetWeight(serverID, weight)
+	b.weights[serverID] = max(weight, 1)
+}
+
+func (b *BoundedLoad) RemoveServer(serverID int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ring.RemoveServer(serverID)
+	delete(b.weights, serverID)
+	b.total -= b.load[serverID]
+	delete(b.load, serverID)
+}
+
+func (b *BoundedLoad) GetServerForRequest(requestID int) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	totalWeight := 0
+	for _, weight := range b.weights {
+		totalWeight += weight
+	}
+	chosen := -1
+	b.ring.walk(requestID, func(serverID int) bool {
+		capacity := math.Ceil(b.factor * float64(b.total+1) * float64(b.weights[serverID]) / float64(totalWeight))
+		if float64(b.load[serverID]) < capacity {
+			chosen = serverID
+			return false
+		}
+		return true
+	})
+	if chosen == -1 {
+		// only servers without a slot on a full ring are left
+		return b.ring.GetServerForRequest(requestID)
+	}
+	b.load[chosen]++
+	b.total++
+	return chosen
+}
+
+// Options returns the options of the ring walked.
+func (b *BoundedLoad) Options() Options {
+	return b.ring.Options()
+}
+
+// SlotCounts returns how many slots of the ring walked each server holds.
+func (b *BoundedLoad) SlotCounts() map[int]int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.ring.SlotCounts()
+}
+
+func (b *BoundedLoad) Done(serverID int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.load[serverID] > 0 {
+		b.load[serverID]--
+		b.total--
+	}
+}
@@ -0,0 +1,202 @@
+package consistenthashmap
+
+import (
+	"sync"
+	"testing"
+)
+
+const (
+	testServers  = 10
+	testRequests = 20000
+	// requests kept in flight, for selectors that track load
+	testInFlight = 64
+)
+
+var testSelectors = []string{SelectorRing, SelectorRendezvous, SelectorJump, SelectorBounded}
+
+// route sends requests through a selector, keeping testInFlight of them in
+// flight, and returns the server each one went to.
+func route(selector ReplicaSelector) []int {
+	servers := make([]int, testRequests)
+	for requestID := range servers {
+		servers[requestID] = selector.GetServerForRequest(requestID)
+		if requestID >= testInFlight {
+			selector.Done(servers[requestID-testInFlight])
+		}
+	}
+	for _, serverID := range servers[testRequests-testInFlight:] {
+		selector.Done(serverID)
+	}
+	return servers
+}
+
+// selectorStats measures how evenly a selector spreads requests over
+// testServers servers, as the busiest server's load over the mean, and which
+// share of the requests move when a server is added and when one is removed.
+type selectorStats struct {
+	balance     float64
+	addChurn    float64
+	removeChurn float64
+	// requests that moved although neither their old nor their new server
+	// was the one added or removed
+	strayMoves int
+}
+
+func measure(t testing.TB, name string) selectorStats {
+	selector, err := NewSelector(name, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for serverID := 1; serverID <= testServers; serverID++ {
+		selector.AddServer(serverID)
+	}
+
+	before := route(selector)
+	load := map[int]int{}
+	for _, serverID := range before {
+		if serverID < 1 || serverID > testServers {
+			t.Fatalf("%s: request went to Server%d", name, serverID)
+		}
+		load[serverID]++
+	}
+	stats := selectorStats{}
+	for _, count := range load {
+		stats.balance = max(stats.balance, float64(count)*testServers/testRequests)
+	}
+
+	moved := func(after []int, changed int) float64 {
+		count := 0
+		for requestID := range after {
+			if after[requestID] == before[requestID] {
+				continue
+			}
+			count++
+			if after[requestID] != changed && before[requestID] != changed {
+				stats.strayMoves++
+			}
+		}
+		return float64(count) / testRequests
+	}
+
+	selector.AddServer(testServers + 1)
+	stats.addChurn = moved(route(selector), testServers+1)
+	selector.RemoveServer(testServers + 1)
+
+	selector.RemoveServer(5)
+	after := route(selector)
+	for requestID, serverID := range after {
+		if serverID == 5 {
+			t.Fatalf("%s: request %d went to removed Server5", name, requestID)
+		}
+	}
+	stats.removeChurn = moved(after, 5)
+	return stats
+}
+
+func TestSelectors(t *testing.T) {
+	for _, name := range testSelectors {
+		stats := measure(t, name)
+		t.Logf("%s: %+v", name, stats)
+
+		// the ring and rendezvous hashing only move what they must
+		if (name == SelectorRing || name == SelectorRendezvous) && stats.strayMoves > 0 {
+			t.Errorf("%s: %d requests moved between servers that stayed", name, stats.strayMoves)
+		}
+		if stats.balance > 2 {
+			t.Errorf("%s: busiest server got %.2f times its share", name, stats.balance)
+		}
+	}
+
+	if _, err := NewSelector("random", Options{}); err == nil {
+		t.Error("got a selector for an unknown name")
+	}
+}
+
+func TestWeightedSelectors(t *testing.T) {
+	for _, name := range testSelectors {
+		selector, _ := NewSelector(name, Options{Slots: 4096, VirtualNodes: 32})
+		selector.AddServer(1)
+		selector.AddWeightedServer(2, 3)
+
+		load := map[int]int{}
+		for _, serverID := range route(selector) {
+			load[serverID]++
+		}
+		if ratio := float64(load[2]) / float64(load[1]); ratio < 2 || ratio > 4.5 {
+			t.Errorf("%s: Server2 of weight 3 got %.2f times the requests of Server1", name, ratio)
+		}
+	}
+}
+
+func TestConcurrentSelectors(t *testing.T) {
+	for _, name := range testSelectors {
+		selector, _ := NewSelector(name, Options{})
+		for serverID := 1; serverID <= testServers; serverID++ {
+			selector.AddServer(serverID)
+		}
+
+		// reads go on while servers come, go and change weight, as they do
+		// on a live shard
+		var wg sync.WaitGroup
+		for reader := 0; reader < 4; reader++ {
+			wg.Add(1)
+			go func(reader int) {
+				defer wg.Done()
+				for requestID := reader; requestID < testRequests; requestID += 4 {
+					selector.Done(selector.GetServerForRequest(requestID))
+				}
+			}(reader)
+		}
+		for i := 0; i < 100; i++ {
+			selector.AddWeightedServer(testServers+1, 1+i%3)
+			selector.SetWeight(1, 1+i%2)
+			selector.RemoveServer(testServers + 1)
+		}
+		wg.Wait()
+
+		if serverID := selector.GetServerForRequest(0); serverID < 1 || serverID > testServers {
+			t.Errorf("%s: got Server%d, want one of the servers left", name, serverID)
+		}
+	}
+}
+
+// BenchmarkSelectors times picking a replica and reports how evenly each
+// selector spreads requests ("balance", the busiest of ten servers over the
+// mean) and which share of requests move when an eleventh server is added or
+// the fifth removed. With ideal balance and minimal movement these are 1,
+// 1/11 and 1/10.
+func BenchmarkSelectors(b *testing.B) {
+	for _, name := range testSelectors {
+		b.Run(name, func(b *testing.B) {
+			stats := measure(b, name)
+			selector, _ := NewSelector(name, Options{})
+			for serverID := 1; serverID <= testServers; serverID++ {
+				selector.AddServer(serverID)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				selector.Done(selector.GetServerForRequest(i))
+			}
+			b.ReportMetric(stats.balance, "balance")
+			b.ReportMetric(stats.addChurn, "add-churn")
+			b.ReportMetric(stats.removeChurn, "remove-churn")
+		})
+	}
+}
+
+// BenchmarkH reports how evenly H spreads consecutive request ids over the
+// ring's slots, as the fullest slot over the mean.
+func BenchmarkH(b *testing.B) {
+	counts := make([]int, Slots)
+	for i := 0; i < b.N; i++ {
+		counts[hashRequest(i)%Slots]++
+	}
+	fullest := 0
+	for _, count := range counts {
+		fullest = max(fullest, count)
+	}
+	if b.N >= Slots {
+		b.ReportMetric(float64(fullest)*Slots/float64(b.N), "balance")
+	}
+}
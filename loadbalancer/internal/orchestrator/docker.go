@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	DockerImageName   = "galaxydb-server"
+	DockerNetworkName = "galaxydb-network"
+	DockerServerPort  = 5000
+)
+
+// Docker runs every server in its own container on a shared docker network.
+type Docker struct {
+	Image   string
+	Network string
+	Port    int
+}
+
+func NewDocker() *Docker {
+	return &Docker{
+		Image:   DockerImageName,
+		Network: DockerNetworkName,
+		Port:    DockerServerPort,
+	}
+}
+
+func (d *Docker) docker(args ...string) *exec.Cmd {
+	return exec.Command("sudo", append([]string{"docker"}, args...)...)
+}
+
+func (d *Docker) Build(serverPath string) error {
+	if err := d.docker("build", "--tag", d.Image, serverPath).Run(); err != nil {
+		return fmt.Errorf("failed to build server image: %w", err)
+	}
+	return nil
+}
+
+// forwardedEnv are the variables passed on to the servers, so they log at the
+// same level and export their traces to the same collector as the load
+// balancer.
+var forwardedEnv = []string{"LOG_LEVEL", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"}
+
+// runArgs returns the arguments of the docker run starting a server.
+func (d *Docker) runArgs(hostname string, id int) []string {
+	args := []string{"run", "--rm", "-d", "--name", hostname, "--network", d.Network, "-e", fmt.Sprintf("id=%d", id)}
+	for _, name := range forwardedEnv {
+		if value := os.Getenv(name); value != "" {
+			args = append(args, "-e", name+"="+value)
+		}
+	}
+	return append(args, fmt.Sprintf("%s:latest", d.Image))
+}
+
+func (d *Docker) Spawn(hostname string, id int) error {
+	cmd := d.docker(d.runArgs(hostname, id)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to start server instance '%s': %w, stderr: %s", hostname, err, stderr.String())
+	}
+	return nil
+}
+
+func (d *Docker) Stop(hostname string) error {
+	if err := d.docker("stop", hostname).Run(); err != nil {
+		return fmt.Errorf("failed to stop server instance '%s': %w", hostname, err)
+	}
+	return nil
+}
+
+func (d *Docker) Address(hostname string) (string, error) {
+	output, err := d.docker("inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", hostname).Output()
+	if err != nil {
+		return "", fmt.Errorf("error running docker inspect: %w", err)
+	}
+
+	ip := strings.TrimSpace(string(output))
+	if len(ip) == 0 {
+		return "", fmt.Errorf("server instance '%s' has no address", hostname)
+	}
+	return fmt.Sprintf("%s:%d", ip, d.Port), nil
+}
+
+func (d *Docker) List() ([]string, error) {
+	output, err := d.docker("ps", "--filter", "ancestor="+d.Image, "--format", "{{.Names}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running docker ps: %w", err)
+	}
+	return strings.Fields(string(output)), nil
+}
@@ -0,0 +1,39 @@
+package orchestrator
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDockerCommand(t *testing.T) {
+	d := NewDocker()
+	cmd := d.docker("stop", "Server1")
+	if got := cmd.Args; !slices.Equal(got, []string{"sudo", "docker", "stop", "Server1"}) {
+		t.Errorf("got %v, want sudo docker stop Server1", got)
+	}
+}
+
+func TestDockerRunArgs(t *testing.T) {
+	for _, name := range forwardedEnv {
+		t.Setenv(name, "")
+	}
+	d := NewDocker()
+	want := []string{"run", "--rm", "-d", "--name", "Server3", "--network", DockerNetworkName, "-e", "id=3", DockerImageName + ":latest"}
+	if got := d.runArgs("Server3", 3); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the servers log and trace as the load balancer does
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	d.Image = "galaxydb-server-dev"
+	d.Network = "galaxydb-test"
+	want = []string{
+		"run", "--rm", "-d", "--name", "Server3", "--network", "galaxydb-test", "-e", "id=3",
+		"-e", "LOG_LEVEL=debug", "-e", "OTEL_EXPORTER_OTLP_ENDPOINT=http://collector:4318",
+		"galaxydb-server-dev:latest",
+	}
+	if got := d.runArgs("Server3", 3); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
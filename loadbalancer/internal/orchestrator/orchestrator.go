@@ -0,0 +1,32 @@
+package orchestrator
+
+import "fmt"
+
+// Orchestrator manages the lifecycle of shard server instances. Instances are
+// identified by their hostname (ServerN) and the id they are started with.
+type Orchestrator interface {
+	// Build prepares whatever Spawn needs to start a server from the source
+	// tree at serverPath, e.g. a docker image or a compiled binary.
+	Build(serverPath string) error
+	// Spawn starts a new server instance.
+	Spawn(hostname string, id int) error
+	// Stop stops a running server instance.
+	Stop(hostname string) error
+	// Address resolves the host:port the load balancer can reach an
+	// instance at. It fails if the instance is not running.
+	Address(hostname string) (string, error)
+	// List returns the hostnames of all running instances.
+	List() ([]string, error)
+}
+
+// New returns the orchestrator registered under name.
+func New(name string) (Orchestrator, error) {
+	switch name {
+	case "", "docker":
+		return NewDocker(), nil
+	case "process":
+		return NewProcess()
+	default:
+		return nil, fmt.Errorf("unknown orchestrator %q", name)
+	}
+}
@@ -0,0 +1,296 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	ProcessBinaryName   = "galaxydb-server"
+	processInstanceFile = "instance.json"
+	processStartupWait  = 10 * time.Second
+	processStopWait     = 5 * time.Second
+)
+
+type process struct {
+	Pid  int `json:"pid"`
+	Port int `json:"port"`
+
+	dir string
+	// exited is closed when a process started by this orchestrator exits. It
+	// is nil for processes adopted from an earlier run, which are probed
+	// with signal 0 instead.
+	exited chan struct{}
+}
+
+func (proc *process) running() bool {
+	if proc.exited != nil {
+		select {
+		case <-proc.exited:
+			return false
+		default:
+			return true
+		}
+	}
+
+	osProcess, err := os.FindProcess(proc.Pid)
+	if err != nil {
+		return false
+	}
+	return osProcess.Signal(syscall.Signal(0)) == nil
+}
+
+func (proc *process) stop() {
+	osProcess, err := os.FindProcess(proc.Pid)
+	if err != nil {
+		return
+	}
+	osProcess.Signal(os.Interrupt)
+
+	deadline := time.Now().Add(processStopWait)
+	for proc.running() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if proc.running() {
+		osProcess.Kill()
+	}
+	if proc.exited != nil {
+		<-proc.exited
+	}
+}
+
+// Process runs every server as a local child process listening on its own
+// port, with its own working directory for the server database. It needs
+// neither docker nor sudo. Each working directory records the pid and port
+// of its server, so a new Process on the same WorkDir adopts servers that are
+// still running.
+type Process struct {
+	// Binary is the server executable. If empty, Build compiles one into
+	// WorkDir.
+	Binary  string
+	WorkDir string
+	// StartupWait is how long Spawn waits for a server to accept
+	// connections, processStartupWait if zero.
+	StartupWait time.Duration
+
+	mutex     sync.Mutex
+	processes map[string]*process
+	// starting holds the names of servers being spawned, so that two
+	// Spawns of the same name cannot both start it
+	starting map[string]bool
+}
+
+// NewProcess returns a process orchestrator. SERVER_BINARY points it at a
+// prebuilt server executable, SERVER_WORKDIR at the directory server
+// instances keep their data in.
+func NewProcess() (*Process, error) {
+	workDir := os.Getenv("SERVER_WORKDIR")
+	if workDir == "" {
+		var err error
+		workDir, err = os.MkdirTemp("", "galaxydb-servers-")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	p := &Process{
+		Binary:    os.Getenv("SERVER_BINARY"),
+		WorkDir:   workDir,
+		processes: make(map[string]*process),
+		starting:  make(map[string]bool),
+	}
+	if err := p.adopt(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Process) adopt() error {
+	entries, err := os.ReadDir(p.WorkDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(p.WorkDir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, processInstanceFile))
+		if err != nil {
+			continue
+		}
+
+		proc := &process{dir: dir}
+		if err := json.Unmarshal(data, proc); err != nil {
+			continue
+		}
+		if proc.running() {
+			p.processes[entry.Name()] = proc
+		}
+	}
+	return nil
+}
+
+func (p *Process) Build(serverPath string) error {
+	if p.Binary != "" {
+		return nil
+	}
+
+	binary, err := filepath.Abs(filepath.Join(p.WorkDir, ProcessBinaryName))
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = serverPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build server binary: %w, output: %s", err, output)
+	}
+	p.Binary = binary
+	return nil
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (p *Process) Spawn(hostname string, id int) error {
+	p.mutex.Lock()
+	if _, ok := p.processes[hostname]; ok || p.starting[hostname] {
+		p.mutex.Unlock()
+		return fmt.Errorf("server instance '%s' is already running", hostname)
+	}
+	p.starting[hostname] = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.starting, hostname)
+		p.mutex.Unlock()
+	}()
+
+	port, err := freePort()
+	if err != nil {
+		return fmt.Errorf("failed to find a free port: %w", err)
+	}
+
+	dir := filepath.Join(p.WorkDir, hostname)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(p.Binary)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), fmt.Sprintf("id=%d", id), fmt.Sprintf("port=%d", port))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start server instance '%s': %w", hostname, err)
+	}
+
+	proc := &process{Pid: cmd.Process.Pid, Port: port, dir: dir, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(proc.exited)
+	}()
+
+	instance, err := json.Marshal(proc)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, processInstanceFile), instance, 0o644)
+	}
+	if err != nil {
+		proc.stop()
+		return fmt.Errorf("failed to record server instance '%s': %w", hostname, err)
+	}
+
+	p.mutex.Lock()
+	p.processes[hostname] = proc
+	p.mutex.Unlock()
+
+	// wait until the server accepts connections so it can be configured
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	startupWait := p.StartupWait
+	if startupWait == 0 {
+		startupWait = processStartupWait
+	}
+	deadline := time.Now().Add(startupWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-proc.exited:
+			p.forget(hostname)
+			return fmt.Errorf("server instance '%s' exited during startup", hostname)
+		default:
+		}
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	proc.stop()
+	p.forget(hostname)
+	return fmt.Errorf("server instance '%s' did not start listening on %s", hostname, address)
+}
+
+func (p *Process) forget(hostname string) *process {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	proc := p.processes[hostname]
+	delete(p.processes, hostname)
+	return proc
+}
+
+func (p *Process) Stop(hostname string) error {
+	proc := p.forget(hostname)
+	if proc == nil {
+		return fmt.Errorf("server instance '%s' is not running", hostname)
+	}
+
+	proc.stop()
+	return os.RemoveAll(proc.dir)
+}
+
+func (p *Process) Address(hostname string) (string, error) {
+	p.mutex.Lock()
+	proc, ok := p.processes[hostname]
+	p.mutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("server instance '%s' is not running", hostname)
+	}
+
+	if !proc.running() {
+		return "", fmt.Errorf("server instance '%s' has exited", hostname)
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(proc.Port)), nil
+}
+
+func (p *Process) List() ([]string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	hostnames := []string{}
+	for hostname, proc := range p.processes {
+		if proc.running() {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+	return hostnames, nil
+}
@@ -0,0 +1,169 @@
+package orchestrator
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TEST_SERVER_ENV makes the test binary stand in for a server: "listen"
+// accepts connections on the port it is given, "exit" exits at once and
+// "hang" never listens.
+const TEST_SERVER_ENV = "ORCHESTRATOR_TEST_SERVER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(TEST_SERVER_ENV) {
+	case "":
+		os.Exit(m.Run())
+	case "listen":
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", os.Getenv("port")))
+		if err != nil {
+			os.Exit(1)
+		}
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				os.Exit(1)
+			}
+			conn.Close()
+		}
+	case "exit":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Hour)
+	}
+}
+
+// newTestProcess returns a process orchestrator running the test binary as
+// its servers, in the given mode, and stops them when the test ends.
+func newTestProcess(t *testing.T, mode string) *Process {
+	t.Helper()
+
+	t.Setenv(TEST_SERVER_ENV, mode)
+	t.Setenv("SERVER_BINARY", os.Args[0])
+	t.Setenv("SERVER_WORKDIR", t.TempDir())
+	p, err := NewProcess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		hostnames, _ := p.List()
+		for _, hostname := range hostnames {
+			p.Stop(hostname)
+		}
+	})
+	return p
+}
+
+func TestProcess(t *testing.T) {
+	p := newTestProcess(t, "listen")
+	for i, hostname := range []string{"Server1", "Server2"} {
+		if err := p.Spawn(hostname, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Spawn("Server1", 3); err == nil {
+		t.Error("spawned Server1 twice")
+	}
+
+	hostnames, err := p.List()
+	if err != nil || !slices.Equal(hostnames, []string{"Server1", "Server2"}) {
+		t.Errorf("listed %v (%v), want Server1 and Server2", hostnames, err)
+	}
+	address, err := p.Address("Server1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dialing Server1 at %s: %v", address, err)
+	}
+	conn.Close()
+
+	if err := p.Stop("Server1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Address("Server1"); err == nil {
+		t.Error("Server1 has an address after being stopped")
+	}
+	if _, err := os.Stat(filepath.Join(p.WorkDir, "Server1")); !os.IsNotExist(err) {
+		t.Errorf("the directory of Server1 is left after it stopped (%v)", err)
+	}
+	if err := p.Stop("Server1"); err == nil {
+		t.Error("stopped Server1 twice")
+	}
+	if hostnames, _ := p.List(); !slices.Equal(hostnames, []string{"Server2"}) {
+		t.Errorf("listed %v, want Server2", hostnames)
+	}
+}
+
+func TestProcessAdoption(t *testing.T) {
+	p := newTestProcess(t, "listen")
+	if err := p.Spawn("Server1", 1); err != nil {
+		t.Fatal(err)
+	}
+	address, err := p.Address("Server1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a load balancer restarted on the same directory finds Server1 running
+	restarted, err := NewProcess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostnames, err := restarted.List(); err != nil || !slices.Equal(hostnames, []string{"Server1"}) {
+		t.Fatalf("listed %v (%v) after the restart, want Server1", hostnames, err)
+	}
+	if got, err := restarted.Address("Server1"); err != nil || got != address {
+		t.Errorf("got address %q (%v) after the restart, want %q", got, err, address)
+	}
+	if err := restarted.Spawn("Server1", 1); err == nil {
+		t.Error("spawned the adopted Server1 again")
+	}
+
+	if err := restarted.Stop("Server1"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(processStopWait); p.processes["Server1"].running(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the adopted Server1 is still running after being stopped")
+		}
+	}
+}
+
+func TestProcessFailedStartup(t *testing.T) {
+	for _, mode := range []string{"exit", "hang"} {
+		t.Run(mode, func(t *testing.T) {
+			p := newTestProcess(t, mode)
+			p.StartupWait = 500 * time.Millisecond
+			err := p.Spawn("Server1", 1)
+			if err == nil {
+				t.Fatal("spawned a server that never listens")
+			}
+			if !strings.Contains(err.Error(), "Server1") {
+				t.Errorf("got %v, want an error naming Server1", err)
+			}
+
+			// the server is forgotten and stopped, so its name can be used
+			// again
+			p.mutex.Lock()
+			proc, ok := p.processes["Server1"]
+			p.mutex.Unlock()
+			if ok {
+				t.Errorf("Server1 is still recorded, running: %v", proc.running())
+			}
+			if hostnames, _ := p.List(); len(hostnames) != 0 {
+				t.Errorf("listed %v, want none", hostnames)
+			}
+			t.Setenv(TEST_SERVER_ENV, "listen")
+			if err := p.Spawn("Server1", 1); err != nil {
+				t.Errorf("spawning Server1 again: %v", err)
+			}
+		})
+	}
+}
@@ -1,83 +1,121 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"slices"
 	"syscall"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/orchestrator"
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
 )
 
 var (
-	schemaConfig  SchemaConfig
-	shardTConfigs map[string]ShardTConfig
-	serverIDs     []int
-	db            *sql.DB
-	serverDown    chan int
+	schemaConfig    SchemaConfig
+	hashRingOptions consistenthashmap.Options
+	db              *sql.DB
+	serverDown      chan int
+	orch            orchestrator.Orchestrator
 )
 
 func initHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
 	var req InitRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
 		return
 	}
-
-	schemaConfig = req.Schema
-
-	for rawServerName, shardIDs := range req.Servers {
-		serverID := getServerID(rawServerName)
-
-		for _, shardID := range shardIDs {
-			_, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", shardID, serverID)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		serverIDs = append(serverIDs, serverID)
-
-		spawnNewServerInstance(fmt.Sprintf("Server%d", serverID), serverID)
-		configNewServerInstance(serverID, shardIDs, req.Schema)
-		go checkHeartbeat(serverID, serverDown)
+	if err := validateSchema(req.Schema); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid schema: %v", err))
+		return
+	}
+	if err := req.HashRing.Validate(); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid hash ring: %v", err))
+		return
+	}
+	if err := validateReadRouting(req.ReadRouting); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
 	}
 
+	numServers := len(req.Servers)
+	if numServers == 0 {
+		numServers = req.N
+	}
+	replicas := req.Replicas
+	if replicas <= 0 {
+		replicas = min(DEFAULT_REPLICAS, numServers)
+	}
+	shardIDs := []string{}
 	for _, shard := range req.Shards {
-		_, err := db.Exec("INSERT INTO shardt (stud_id_low, shard_id, shard_size, valid_idx) VALUES (?, ?, ?, ?);", shard.StudIDLow, shard.ShardID, shard.ShardSize, 0)
-		if err != nil {
-			log.Fatal(err)
+		if err := consistenthashmap.ValidateSelector(shard.Selector); err != nil {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard %s: %v", shard.ShardID, err))
+			return
 		}
+		shardIDs = append(shardIDs, shard.ShardID)
+	}
+	shardReplicas := getShardReplicas(req.Shards, req.Servers, replicas)
+	placement, newServerIDs, err := placeShards(req.N, req.Servers, shardIDs, shardReplicas)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid placement: %v", err))
+		return
+	}
+	weights, err := getServerWeights(req.Weights, newServerIDs)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid weights: %v", err))
+		return
+	}
 
-		config := shardTConfigs[shard.ShardID]
-		config.chm = &consistenthashmap.ConsistentHashMap{}
-		config.mutex = &sync.Mutex{}
-		shardTConfigs[shard.ShardID] = config
+	schemaConfig = req.Schema
+	hashRingOptions = req.HashRing.WithDefaults()
+	readRouting = req.ReadRouting
+	if readRouting == "" {
+		readRouting = DEFAULT_READ_ROUTING
+	}
+	err = saveConfig("schema", schemaConfig)
+	if err == nil {
+		err = saveConfig("replicas", replicas)
+	}
+	if err == nil {
+		err = saveConfig("hash_ring", hashRingOptions)
+	}
+	if err == nil {
+		err = saveConfig("read_routing", readRouting)
+	}
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error saving configuration"))
+		return
+	}
 
-		shardTConfigs[shard.ShardID].chm.Init()
-		rows, err := db.Query("SELECT server_id FROM mapt WHERE shard_id = ?;", shard.ShardID)
-		if err != nil {
-			log.Fatal(err)
+	for _, serverID := range newServerIDs {
+		if err := addServerInstance(context.WithoutCancel(r.Context()), serverID, placement[serverID], weights[serverID], req.Schema); err != nil {
+			writeError(w, r, errorFrom(err, "error adding server").WithServer(fmt.Sprintf("Server%d", serverID)))
+			return
 		}
-		defer rows.Close()
+	}
 
-		for rows.Next() {
-			var serverID int
-			err = rows.Scan(&serverID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			shardTConfigs[shard.ShardID].chm.AddServer(serverID)
+	for _, shard := range req.Shards {
+		if err := addShardRecord(shard, shardReplicas[shard.ShardID]); err != nil {
+			writeError(w, r, errorFrom(err, "error adding shard").WithShard(shard.ShardID))
+			return
+		}
+		if err := electPrimary(context.WithoutCancel(r.Context()), shard.ShardID); err != nil {
+			slog.WarnContext(r.Context(), "error electing primary", "shard", shard.ShardID, logging.Error(err))
 		}
 	}
 
@@ -86,43 +124,35 @@ func initHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Configured Database", "status": "success"})
 }
 
-func statusHandler(w http.ResponseWriter, _ *http.Request) {
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
 	servers := make(map[string][]string)
+	weights := make(map[string]int)
 
-	for _, serverID := range serverIDs {
+	for _, serverID := range getServerIDs() {
 		serverName := fmt.Sprintf("Server%d", serverID)
-		servers[serverName] = []string{}
-
-		rows, err := db.Query("SELECT shard_id FROM mapt WHERE server_id = ?;", serverID)
+		shardIDs, err := getShardIDsForServer(serverID)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, errorFrom(err, "error reading placement").WithServer(serverName))
+			return
 		}
-		defer rows.Close()
+		servers[serverName] = shardIDs
 
-		for rows.Next() {
-			var shardID string
-			err = rows.Scan(&shardID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			servers[serverName] = append(servers[serverName], shardID)
+		weights[serverName], err = getServerWeight(serverID)
+		if err != nil {
+			writeError(w, r, errorFrom(err, "error reading weight").WithServer(serverName))
+			return
 		}
 	}
 
-	shards := []Shard{}
-	rows, err := db.Query("SELECT stud_id_low, shard_id, shard_size FROM shardt;")
+	shards, err := getShards()
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var shard Shard
-		err = rows.Scan(&shard.StudIDLow, &shard.ShardID, &shard.ShardSize)
-		if err != nil {
-			log.Fatal(err)
-		}
-		shards = append(shards, shard)
+		writeError(w, r, errorFrom(err, "error reading shards"))
+		return
 	}
 
 	response := map[string]interface{}{
@@ -130,6 +160,7 @@ func statusHandler(w http.ResponseWriter, _ *http.Request) {
 		"schema":  schemaConfig,
 		"shards":  shards,
 		"servers": servers,
+		"weights": weights,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -138,69 +169,107 @@ func statusHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 func addServersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
 	var req AddRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
 		return
 	}
 
-	if len(req.Servers) < req.N {
-		resp := AddResponseFailed{
-			Message: "<Error> Number of new servers (n) is greater than newly added instances",
-			Status:  "failure",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(resp)
+	if len(req.Servers) > 0 && len(req.Servers) < req.N {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Number of new servers (n) is greater than newly added instances"))
 		return
 	}
 
-	serverIDsAdded := []int{}
-
-	for rawServerName, shardIDs := range req.Servers {
-		serverID := getServerID(rawServerName)
-		serverIDsAdded = append(serverIDsAdded, serverID)
+	newShardIDs := []string{}
+	for _, shard := range req.NewShards {
+		newShardIDs = append(newShardIDs, shard.ShardID)
+	}
+	replicas, err := getReplicationFactor()
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error reading replication factor"))
+		return
+	}
+	shardReplicas := getShardReplicas(req.NewShards, req.Servers, replicas)
+	placement, serverIDsAdded, err := placeShards(req.N, req.Servers, newShardIDs, shardReplicas)
+	var weights map[int]int
+	if err == nil {
+		weights, err = getServerWeights(req.Weights, serverIDsAdded)
+	}
+	for _, shard := range req.NewShards {
+		if err == nil {
+			err = consistenthashmap.ValidateSelector(shard.Selector)
+		}
+	}
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
 
+	shardIDsAdded := map[string]bool{}
+	for serverID, shardIDs := range placement {
 		for _, shardID := range shardIDs {
-			_, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", shardID, serverID)
-			if err != nil {
-				log.Fatal(err)
-			}
+			shardIDsAdded[shardID] = true
+		}
+		if !isServer(serverID) {
+			continue
 		}
 
-		serverIDs = append(serverIDs, serverID)
+		// new shards the planner put on servers already running
+		if err := placeOnServer(context.WithoutCancel(r.Context()), serverID, shardIDs); err != nil {
+			writeError(w, r, errorFrom(err, "error placing shards").WithServer(fmt.Sprintf("Server%d", serverID)))
+			return
+		}
+	}
 
-		spawnNewServerInstance(fmt.Sprintf("Server%d", serverID), serverID)
-		configNewServerInstance(serverID, shardIDs, schemaConfig)
-		go checkHeartbeat(serverID, serverDown)
+	for _, serverID := range serverIDsAdded {
+		if err := addServerInstance(context.WithoutCancel(r.Context()), serverID, placement[serverID], weights[serverID], schemaConfig); err != nil {
+			writeError(w, r, errorFrom(err, "error adding server").WithServer(fmt.Sprintf("Server%d", serverID)))
+			return
+		}
 	}
 
 	for _, shard := range req.NewShards {
-		_, err := db.Exec("INSERT INTO shardt (stud_id_low, shard_id, shard_size, valid_idx) VALUES (?, ?, ?, ?);", shard.StudIDLow, shard.ShardID, shard.ShardSize, 0)
-		if err != nil {
-			log.Fatal(err)
+		if err := addShardRecord(shard, shardReplicas[shard.ShardID]); err != nil {
+			writeError(w, r, errorFrom(err, "error adding shard").WithShard(shard.ShardID))
+			return
 		}
+	}
 
-		config := shardTConfigs[shard.ShardID]
-		config.chm = &consistenthashmap.ConsistentHashMap{}
-		config.mutex = &sync.Mutex{}
-		shardTConfigs[shard.ShardID] = config
-
-		shardTConfigs[shard.ShardID].chm.Init()
-		rows, err := db.Query("SELECT server_id FROM mapt WHERE shard_id = ?;", shard.ShardID)
+	// a new election also brings the new replicas of existing shards up to
+	// date with the primary
+	for shardID := range shardIDsAdded {
+		unlock, ok := lockShard(shardID)
+		if !ok {
+			continue
+		}
+		// a shard is kept at least at the replicas it was given
+		_, err := db.Exec("UPDATE shardt SET replicas = MAX(COALESCE(replicas, 0), (SELECT COUNT(*) FROM mapt WHERE shard_id = ?)) WHERE shard_id = ?;", shardID, shardID)
+		if err == nil {
+			err = reloadShardHashMap(shardID)
+		}
+		if err == nil {
+			if err := electPrimary(context.WithoutCancel(r.Context()), shardID); err != nil {
+				slog.WarnContext(r.Context(), "error electing primary", "shard", shardID, logging.Error(err))
+			}
+		}
+		unlock()
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, errorFrom(err, "error adding replicas").WithShard(shardID))
+			return
 		}
-		defer rows.Close()
+	}
 
-		for rows.Next() {
-			var serverID int
-			err = rows.Scan(&serverID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			shardTConfigs[shard.ShardID].chm.AddServer(serverID)
+	if req.Rebalance {
+		moved, err := rebalanceShards()
+		if err != nil {
+			slog.WarnContext(r.Context(), "error rebalancing shards", logging.Error(err))
 		}
+		slog.InfoContext(r.Context(), "rebalanced shards", "moved", moved)
 	}
 
 	addServerMessage := "Add "
@@ -216,7 +285,7 @@ func addServersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := AddResponseSuccess{
-		N:       len(serverIDs),
+		N:       len(getServerIDs()),
 		Message: addServerMessage,
 		Status:  "successful",
 	}
@@ -227,89 +296,95 @@ func addServersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func removeServersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
 	var req RemoveRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
 		return
 	}
 
 	if len(req.Servers) > req.N {
-		resp := RemoveResponseFailed{
-			Message: "<Error> Length of server list is more than removable instances",
-			Status:  "failure",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(resp)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Length of server list is more than removable instances"))
 		return
 	}
 
 	serverIDsRemoved := []int{}
 	for _, serverName := range req.Servers {
-		serverIDsRemoved = append(serverIDsRemoved, getServerID(serverName))
+		serverID, err := getServerID(serverName)
+		if err != nil {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err).WithServer(serverName))
+			return
+		}
+		serverIDsRemoved = append(serverIDsRemoved, serverID)
 	}
 
+	runningServerIDs := getServerIDs()
 	additionalRemovalsNeeded := req.N - len(serverIDsRemoved)
 	for additionalRemovalsNeeded > 0 {
-		if serverID := chooseRandomServerForRemoval(serverIDs, serverIDsRemoved); serverID != -1 {
-			serverIDsRemoved = append(serverIDsRemoved, serverID)
-			additionalRemovalsNeeded -= 1
+		serverID := chooseRandomServerForRemoval(runningServerIDs, serverIDsRemoved)
+		if serverID == -1 {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "Cannot remove %d servers, %d are running", req.N, len(runningServerIDs)))
+			return
 		}
+		serverIDsRemoved = append(serverIDsRemoved, serverID)
+		additionalRemovalsNeeded -= 1
 	}
 
-	for _, serverIDRemoved := range serverIDsRemoved {
-		shardIDsRemoved := []string{}
-		rows, err := db.Query("SELECT shard_id FROM mapt WHERE server_id = ?;", serverIDRemoved)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var shardID string
-			err = rows.Scan(&shardID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			shardIDsRemoved = append(shardIDsRemoved, shardID)
-		}
+	if status, err := rehomeReplicas(serverIDsRemoved); err != nil {
+		writeError(w, r, statusError(status, err, "cannot remove servers"))
+		return
+	}
 
-		for _, shardIDRemoved := range shardIDsRemoved {
-			shardTConfigs[shardIDRemoved].chm.RemoveServer(serverIDRemoved)
+	for _, serverIDRemoved := range serverIDsRemoved {
+		if err := forgetServer(serverIDRemoved); err != nil {
+			writeError(w, r, errorFrom(err, "error removing server").WithServer(fmt.Sprintf("Server%d", serverIDRemoved)))
+			return
 		}
+	}
 
-		_, err = db.Exec("DELETE FROM mapt WHERE server_id = ?;", serverIDRemoved)
+	shardIDs, err := getShardIDs()
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error listing shards"))
+		return
+	}
+	for _, shardID := range shardIDs {
+		primaryServerID, _, err := getShardPrimary(db, shardID)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, errorFrom(err, "error reading primary").WithShard(shardID))
+			return
 		}
-	}
-
-	newServerIDs := []int{}
-	for _, serverID := range serverIDs {
-		isPresent := false
-		for _, serverIDRemoved := range serverIDsRemoved {
-			if serverIDRemoved == serverID {
-				isPresent = true
-				break
-			}
+		if !slices.Contains(serverIDsRemoved, primaryServerID) {
+			continue
 		}
-		if !isPresent {
-			newServerIDs = append(newServerIDs, serverID)
+		unlock, ok := lockShard(shardID)
+		if !ok {
+			continue
+		}
+		if err := electPrimary(context.WithoutCancel(r.Context()), shardID); err != nil {
+			slog.WarnContext(r.Context(), "error electing primary", "shard", shardID, logging.Error(err))
 		}
+		unlock()
 	}
-	serverIDs = newServerIDs
+
+	removeServerIDs(serverIDsRemoved...)
 
 	serverNamesRemoved := []string{}
 	for _, serverIDRemoved := range serverIDsRemoved {
 		serverNameRemoved := fmt.Sprintf("Server%d", serverIDRemoved)
-		removeServerInstance(serverNameRemoved)
+		if err := removeServerInstance(serverNameRemoved); err != nil {
+			slog.WarnContext(r.Context(), "failed to stop server", "server", serverNameRemoved, logging.Error(err))
+		}
 
 		serverNamesRemoved = append(serverNamesRemoved, serverNameRemoved)
 	}
 
 	response := RemoveResponseSuccess{
 		Message: map[string]interface{}{
-			"N":       len(serverIDs),
+			"N":       len(getServerIDs()),
 			"servers": serverNamesRemoved,
 		},
 		Status: "successful",
@@ -322,62 +397,41 @@ func removeServersHandler(w http.ResponseWriter, r *http.Request) {
 
 func readHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
 		return
 	}
 
 	var req ReadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
 		return
 	}
-
-	shardIDsQueried := []string{}
-	rows, err := db.Query("SELECT shard_id FROM shardt WHERE (stud_id_low BETWEEN ? AND ?) OR (stud_id_low+shard_size BETWEEN ? AND ?);", req.StudID.Low, req.StudID.High, req.StudID.Low, req.StudID.High)
+	settings, err := parseReadOptions(req.ReadOptions)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var shardID string
-		err = rows.Scan(&shardID)
-		if err != nil {
-			log.Fatal(err)
-		}
-		shardIDsQueried = append(shardIDsQueried, shardID)
+	shardsQueried, err := getShardsInRange(req.StudID.Low, req.StudID.High)
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding shards in range"))
+		return
+	}
+	shardIDsQueried := []string{}
+	for _, shard := range shardsQueried {
+		shardIDsQueried = append(shardIDsQueried, shard.ShardID)
 	}
 
-	var studData []StudT
-	for _, shardIDQueried := range shardIDsQueried {
-		payload := ServerReadPayload{
-			Shard:  shardIDQueried,
-			StudID: req.StudID,
-		}
-		payloadData, err := json.Marshal(payload)
-		if err != nil {
-			log.Fatalln("Error marshaling JSON: ", err)
-			return
-		}
-
-		serverID := shardTConfigs[shardIDQueried].chm.GetServerForRequest(getRandomID())
-
-		resp, err := http.Post("http://"+getServerIP(fmt.Sprintf("Server%d", serverID))+":"+fmt.Sprint(SERVER_PORT)+"/read", "application/json", bytes.NewBuffer(payloadData))
-		if err != nil {
-			log.Println("Error reading from Server:", err)
-			return
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Println("Error reading response body:", err)
-		}
-
-		var respData ServerReadResponse
-		json.Unmarshal(body, &respData)
-		resp.Body.Close()
+	ctx, cancel := context.WithTimeout(r.Context(), settings.timeout)
+	defer cancel()
+	studData, errs := scatterRead(ctx, shardsQueried, req.StudID.Low, req.StudID.High, settings)
 
-		studData = append(studData, respData.Data...)
+	for shardID, err := range errs {
+		slog.WarnContext(r.Context(), "error reading shard", "shard", shardID, logging.Error(err))
+	}
+	if len(errs) > 0 && !req.AllowPartial {
+		writeError(w, r, shardsError(errs))
+		return
 	}
 
 	response := ReadResponse{
@@ -385,6 +439,13 @@ func readHandler(w http.ResponseWriter, r *http.Request) {
 		Data:          studData,
 		Status:        "success",
 	}
+	if len(errs) > 0 {
+		response.Errors = map[string]string{}
+		for shardID, err := range errs {
+			response.Errors[shardID] = err.Error()
+		}
+		response.Status = "partial"
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -393,67 +454,79 @@ func readHandler(w http.ResponseWriter, r *http.Request) {
 
 func WriteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
 		return
 	}
 
 	var req WriteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
 		return
 	}
-
-	studDataToWrite := map[string][]StudT{}
-	for _, studData := range req.Data {
-		shardID := getShardIDFromStudID(db, studData.StudID)
-		studDataToWrite[shardID] = append(studDataToWrite[shardID], studData)
+	level, err := parseConsistency(req.Consistency, DEFAULT_WRITE_CONSISTENCY)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
 	}
 
-	for shardID, studData := range studDataToWrite {
-		shardTConfigs[shardID].mutex.Lock()
-
-		currentIndex := getValidIDx(db, shardID)
-
-		payload := ServerWritePayload{
-			Shard:        shardID,
-			Data:         studData,
-			CurrentIndex: currentIndex,
-		}
-		payloadData, err := json.Marshal(payload)
+	studIDs := []int{}
+	for _, studData := range req.Data {
+		studID, err := getRowKey(studData)
 		if err != nil {
-			log.Fatalln("Error marshaling JSON: ", err)
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid data entry: %v", err))
 			return
 		}
+		studIDs = append(studIDs, studID)
+	}
+	shardIDs, unlock, err := lockShardsForKeys(studIDs)
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding shard"))
+		return
+	}
+	defer unlock()
+	studDataToWrite := map[string][]Row{}
+	for i, shardID := range shardIDs {
+		if shardID == "" {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "No shard for Stud_id: %d", studIDs[i]))
+			return
+		}
+		studDataToWrite[shardID] = append(studDataToWrite[shardID], req.Data[i])
+	}
 
-		serverIDs := getServerIDsForShard(db, shardID)
-		for _, serverID := range serverIDs {
-			resp, err := http.Post("http://"+getServerIP(fmt.Sprintf("Server%d", serverID))+":"+fmt.Sprint(SERVER_PORT)+"/write", "application/json", bytes.NewBuffer(payloadData))
-			if err != nil {
-				log.Println("Error writing to Server:", err)
-				return
-			}
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Println("Error reading response body:", err)
-			}
-
-			var respData ServerWriteResponse
-			json.Unmarshal(body, &respData)
-			resp.Body.Close()
+	// a write is traced under the request but carried through even if the
+	// client goes away, so valid_idx keeps up with the primaries
+	ctx := context.WithoutCancel(r.Context())
 
-			if respData.CurrentIndex != currentIndex+len(studData) {
-				log.Println("Error writing to Server: Invalid Index")
-				return
-			}
+	// a batch for several shards must apply on all of them or none
+	if len(studDataToWrite) > 1 {
+		if status, err := writeTransaction(ctx, studDataToWrite, level); err != nil {
+			slog.WarnContext(ctx, "error writing batch", logging.Error(err))
+			writeError(w, r, statusError(status, err, "error writing batch"))
+			return
 		}
+		for shardID, studData := range studDataToWrite {
+			shardWrites.Add(float64(len(studData)), shardID)
+		}
+		studDataToWrite = nil
+	}
 
-		_, err = db.Exec("UPDATE shardt SET valid_idx = ? WHERE shard_id = ?;", currentIndex+len(studData), shardID)
+	for shardID, studData := range studDataToWrite {
+		shardServerIDs, err := getServerIDsForShard(db, shardID)
+		needed := requiredReplicas(level, len(shardServerIDs))
+		var respData *ServerWriteResponse
+		if err == nil {
+			respData, err = writeToShard(ctx, shardID, studData, needed)
+		}
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, errorFrom(err, "error writing to shard").WithShard(shardID))
+			return
 		}
+		shardWrites.Add(float64(len(studData)), shardID)
 
-		shardTConfigs[shardID].mutex.Unlock()
+		if err := checkAcks(shardID, respData, needed); err != nil {
+			writeError(w, r, apierror.Errorf(apierror.Unavailable, "Error writing to %s: %v", shardID, err).WithShard(shardID))
+			return
+		}
 	}
 
 	response := WriteResponse{
@@ -468,46 +541,50 @@ func WriteHandler(w http.ResponseWriter, r *http.Request) {
 
 func updateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
 		return
 	}
 
 	var req UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	level, err := parseConsistency(req.Consistency, DEFAULT_WRITE_CONSISTENCY)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
 		return
 	}
 
-	shardID := getShardIDFromStudID(db, req.StudID)
-	shardTConfigs[shardID].mutex.Lock()
+	shardIDs, unlock, err := lockShardsForKeys([]int{req.StudID})
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding shard"))
+		return
+	}
+	shardID := shardIDs[0]
+	if shardID == "" {
+		unlock()
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "No shard for Stud_id: %d", req.StudID))
+		return
+	}
 
-	payload := ServerUpdatePayload{
-		Shard:  shardID,
-		StudID: req.StudID,
-		Data:   req.Data,
+	shardServerIDs, err := getServerIDsForShard(db, shardID)
+	needed := requiredReplicas(level, len(shardServerIDs))
+	var respData *ServerWriteResponse
+	if err == nil {
+		respData, err = changeShard(context.WithoutCancel(r.Context()), shardID, "PUT", "/update", &ServerUpdatePayload{StudID: req.StudID, Data: req.Data}, needed)
 	}
-	payloadData, err := json.Marshal(payload)
+	unlock()
 	if err != nil {
-		log.Fatalln("Error marshaling JSON: ", err)
+		writeError(w, r, errorFrom(err, "error updating shard").WithShard(shardID))
 		return
 	}
+	shardWrites.Inc(shardID)
 
-	serverIDs := getServerIDsForShard(db, shardID)
-	for _, serverID := range serverIDs {
-		req, err := http.NewRequest("PUT", "http://"+getServerIP(fmt.Sprintf("Server%d", serverID))+":"+fmt.Sprint(SERVER_PORT)+"/update", bytes.NewBuffer(payloadData))
-		if err != nil {
-			log.Println("Error updating Server:", err)
-			return
-		}
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error updating Server:", err)
-			return
-		}
-		resp.Body.Close()
+	if err := checkAcks(shardID, respData, needed); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.Unavailable, "Error updating %s: %v", shardID, err).WithShard(shardID))
+		return
 	}
-	shardTConfigs[shardID].mutex.Unlock()
 
 	response := UpdateResponse{
 		Status:  "success",
@@ -521,47 +598,51 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
 		return
 	}
 
 	var req DeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	level, err := parseConsistency(req.Consistency, DEFAULT_WRITE_CONSISTENCY)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
 		return
 	}
 
-	shardID := getShardIDFromStudID(db, req.StudID)
-	shardTConfigs[shardID].mutex.Lock()
+	shardIDs, unlock, err := lockShardsForKeys([]int{req.StudID})
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding shard"))
+		return
+	}
+	shardID := shardIDs[0]
+	if shardID == "" {
+		unlock()
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "No shard for Stud_id: %d", req.StudID))
+		return
+	}
 
-	payload := ServerDeletePayload{
-		Shard:  shardID,
-		StudID: req.StudID,
+	shardServerIDs, err := getServerIDsForShard(db, shardID)
+	needed := requiredReplicas(level, len(shardServerIDs))
+	var respData *ServerWriteResponse
+	if err == nil {
+		respData, err = changeShard(context.WithoutCancel(r.Context()), shardID, "DELETE", "/delete", &ServerDeletePayload{StudID: req.StudID}, needed)
 	}
-	payloadData, err := json.Marshal(payload)
+	unlock()
 	if err != nil {
-		log.Fatalln("Error marshaling JSON: ", err)
+		writeError(w, r, errorFrom(err, "error deleting from shard").WithShard(shardID))
 		return
 	}
+	shardWrites.Inc(shardID)
 
-	serverIDs := getServerIDsForShard(db, shardID)
-	for _, serverID := range serverIDs {
-		req, err := http.NewRequest("DELETE", "http://"+getServerIP(fmt.Sprintf("Server%d", serverID))+":"+fmt.Sprint(SERVER_PORT)+"/delete", bytes.NewBuffer(payloadData))
-		if err != nil {
-			log.Println("Error deleting from Server:", err)
-			return
-		}
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error deleting from Server:", err)
-			return
-		}
-		resp.Body.Close()
+	if err := checkAcks(shardID, respData, needed); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.Unavailable, "Error deleting from %s: %v", shardID, err).WithShard(shardID))
+		return
 	}
 
-	shardTConfigs[shardID].mutex.Unlock()
-
 	response := DeleteResponse{
 		Message: fmt.Sprintf("Data entry with Stud_id: %d removed from all replicas", req.StudID),
 		Status:  "success",
@@ -572,47 +653,79 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func openDatabase(filename string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(INIT_DB)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/init", initHandler)
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/add", addServersHandler)
+	mux.HandleFunc("/rm", removeServersHandler)
+	mux.HandleFunc("/read", readHandler)
+	mux.HandleFunc("/query", queryHandler)
+	mux.HandleFunc("/aggregate", aggregateHandler)
+	mux.HandleFunc("/write", WriteHandler)
+	mux.HandleFunc("/update", updateHandler)
+	mux.HandleFunc("/del", deleteHandler)
+	mux.HandleFunc("/split", splitHandler)
+	mux.HandleFunc("/merge", mergeHandler)
+	mux.HandleFunc("/migrate", migrateHandler)
+	mux.HandleFunc("/weight", weightHandler)
+	mux.Handle("/metrics", metricsRegistry.Handler())
+	return tracer.Middleware(httpMetrics.Instrument(mux), "/metrics")
+}
+
 func main() {
-	buildServerInstance()
+	logging.Setup("galaxydb-loadbalancer")
+
+	var err error
+	orch, err = orchestrator.New(os.Getenv("ORCHESTRATOR"))
+	if err != nil {
+		slog.Error("error setting up orchestrator", logging.Error(err))
+		os.Exit(1)
+	}
+
+	if err := buildServerInstance(); err != nil {
+		slog.Error("error building server instance", logging.Error(err))
+		os.Exit(1)
+	}
+	tracer = tracing.NewTracer("galaxydb-loadbalancer", "", tracing.ExportersFromEnv()...)
 
 	sigs := make(chan os.Signal, 1)
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	_, err := os.Stat(DB_FILENAME)
-	if err == nil {
-		err = os.Remove(DB_FILENAME)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	db, err = sql.Open("sqlite3", DB_FILENAME)
+	db, err = openDatabase(DB_FILENAME)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("error opening database", logging.Error(err))
+		os.Exit(1)
 	}
 	defer db.Close()
 
-	_, err = db.Exec(INIT_DB)
+	err = recoverState()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("error recovering state", logging.Error(err))
+		os.Exit(1)
 	}
 
-	shardTConfigs = make(map[string]ShardTConfig)
-
 	serverDown = make(chan int)
 	go monitorServers(sigs)
+	goBackground(monitorReplication)
 
-	http.HandleFunc("/init", initHandler)
-	http.HandleFunc("/status", statusHandler)
-	http.HandleFunc("/add", addServersHandler)
-	http.HandleFunc("/rm", removeServersHandler)
-	http.HandleFunc("/read", readHandler)
-	http.HandleFunc("/write", WriteHandler)
-	http.HandleFunc("/update", updateHandler)
-	http.HandleFunc("/del", deleteHandler)
-
-	server := &http.Server{Addr: ":5000", Handler: nil}
+	server := &http.Server{Addr: ":5000", Handler: newRouter()}
 
 	go func() {
 		<-sigs
@@ -620,14 +733,22 @@ func main() {
 		server.Shutdown(context.Background())
 	}()
 
-	log.Println("Load Balancer running on port 5000")
+	slog.Info("load balancer running", "port", 5000)
 	err = server.ListenAndServe()
 	if err != http.ErrServerClosed {
-		log.Fatalln(err)
-	} else {
-		log.Println("Load Balancer shut down successfully")
+		slog.Error("error serving", logging.Error(err))
+		os.Exit(1)
 	}
+	slog.Info("load balancer shut down")
+	stopBackground()
 
-	cleanupServers(serverIDs)
+	// the servers outlive the load balancer, which re-adopts them on its next
+	// start, unless RESET_ON_EXIT asks for the cluster to be wiped
+	if os.Getenv("RESET_ON_EXIT") == "true" {
+		cleanupServers(getServerIDs())
+		if err := resetState(); err != nil {
+			slog.Error("error resetting metadata", logging.Error(err))
+		}
+	}
 	os.Exit(0)
 }
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// shardData reads a shard straight from one server, bypassing the load
+// balancer, so tests can check what every replica holds.
+func (c *testCluster) shardData(serverID int, shardID string) []Row {
+	c.t.Helper()
+
+	payload, err := json.Marshal(ServerCopyPayload{Shards: []string{shardID}})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", getServerURL(serverID, "/copy"), bytes.NewBuffer(payload))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var respData map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		c.t.Fatal(err)
+	}
+	var data []Row
+	if err := json.Unmarshal(respData[shardID], &data); err != nil {
+		c.t.Fatal(err)
+	}
+	sortRows(c.t, data)
+	return data
+}
+
+// validIdx returns the valid_idx the load balancer has for a shard.
+func (c *testCluster) validIdx(shardID string) int {
+	c.t.Helper()
+
+	idx, err := getValidIDx(db, shardID)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return idx
+}
+
+// primary returns the primary of a shard and its term.
+func (c *testCluster) primary(shardID string) (int, int) {
+	c.t.Helper()
+
+	primaryServerID, term, err := getShardPrimary(db, shardID)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return primaryServerID, term
+}
+
+// replicas returns the servers mapt places a shard on.
+func (c *testCluster) replicas(shardID string) []int {
+	c.t.Helper()
+
+	serverIDs, err := getServerIDsForShard(db, shardID)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return serverIDs
+}
+
+func (c *testCluster) read(low int, high int) ReadResponse {
+	c.t.Helper()
+
+	var req ReadRequest
+	req.StudID.Low = low
+	req.StudID.High = high
+
+	var resp ReadResponse
+	c.mustDo("POST", "/read", req, &resp)
+	return resp
+}
+
+func sortRows(t *testing.T, data []Row) {
+	t.Helper()
+
+	keys := make(map[string]int)
+	for _, row := range data {
+		key, err := getRowKey(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[fmt.Sprint(row)] = key
+	}
+	sort.Slice(data, func(i, j int) bool { return keys[fmt.Sprint(data[i])] < keys[fmt.Sprint(data[j])] })
+}
+
+// assertRows compares rows by their JSON encoding, which does not depend on
+// how the numbers in them were decoded.
+func assertRows(t *testing.T, got []Row, want []Row) {
+	t.Helper()
+
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("got entries %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func student(studID int, studName string, studMarks int) Row {
+	return Row{"Stud_id": studID, "Stud_name": studName, "Stud_marks": studMarks}
+}
+
+var testStudents = []Row{
+	student(10, "Aarav", 81),
+	student(20, "Diya", 64),
+	student(150, "Kabir", 92),
+	student(210, "Meera", 47),
+	student(299, "Rohan", 75),
+}
+
+func TestInitAndStatus(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	var status struct {
+		N       int                 `json:"N"`
+		Schema  SchemaConfig        `json:"schema"`
+		Shards  []Shard             `json:"shards"`
+		Servers map[string][]string `json:"servers"`
+	}
+	c.mustDo("GET", "/status", nil, &status)
+
+	if status.N != 3 {
+		t.Errorf("got N=%d, want 3", status.N)
+	}
+	if len(status.Shards) != 3 {
+		t.Errorf("got %d shards, want 3", len(status.Shards))
+	}
+	for serverName, shardIDs := range testInitRequest().Servers {
+		got := append([]string{}, status.Servers[serverName]...)
+		sort.Strings(got)
+		want := append([]string{}, shardIDs...)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got shards %v, want %v", serverName, got, want)
+		}
+	}
+
+	hostnames, _ := c.orch.List()
+	if len(hostnames) != 3 {
+		t.Errorf("got %d running servers, want 3", len(hostnames))
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	var writeResp WriteResponse
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, &writeResp)
+	if writeResp.Status != "success" {
+		t.Fatalf("got write status %q", writeResp.Status)
+	}
+
+	resp := c.read(0, 299)
+	assertRows(t, resp.Data, testStudents)
+	if len(resp.ShardsQueried) != 3 {
+		t.Errorf("got shards queried %v, want all 3 shards", resp.ShardsQueried)
+	}
+
+	resp = c.read(15, 25)
+	assertRows(t, resp.Data, testStudents[1:2])
+	if fmt.Sprint(resp.ShardsQueried) != "[sh1]" {
+		t.Errorf("got shards queried %v, want [sh1]", resp.ShardsQueried)
+	}
+
+	// every replica holds the shard data and valid_idx counts the writes
+	assertRows(t, c.shardData(1, "sh1"), testStudents[:2])
+	assertRows(t, c.shardData(3, "sh1"), testStudents[:2])
+	assertRows(t, c.shardData(1, "sh2"), testStudents[2:3])
+	assertRows(t, c.shardData(2, "sh2"), testStudents[2:3])
+	assertRows(t, c.shardData(2, "sh3"), testStudents[3:])
+	assertRows(t, c.shardData(3, "sh3"), testStudents[3:])
+	if validIDx := c.validIdx("sh1"); validIDx != 2 {
+		t.Errorf("got valid_idx %d for sh1, want 2", validIDx)
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	updated := student(150, "Kabir", 30)
+	c.mustDo("PUT", "/update", UpdateRequest{StudID: 150, Data: Row{"Stud_marks": 30}}, nil)
+	c.mustDo("DELETE", "/del", DeleteRequest{StudID: 210}, nil)
+
+	want := []Row{testStudents[0], testStudents[1], updated, testStudents[4]}
+	assertRows(t, c.read(0, 299).Data, want)
+
+	assertRows(t, c.shardData(1, "sh2"), []Row{updated})
+	assertRows(t, c.shardData(2, "sh2"), []Row{updated})
+	assertRows(t, c.shardData(2, "sh3"), testStudents[4:])
+	assertRows(t, c.shardData(3, "sh3"), testStudents[4:])
+}
+
+func TestAddAndRemoveServers(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents[:2]}, nil)
+
+	var addResp AddResponseSuccess
+	c.mustDo("POST", "/add", AddRequest{
+		N:         1,
+		NewShards: []Shard{{StudIDLow: 300, ShardID: "sh4", ShardSize: 100}},
+		Servers:   map[string][]string{"Server4": {"sh4"}},
+	}, &addResp)
+	if addResp.N != 4 {
+		t.Errorf("got N=%d after add, want 4", addResp.N)
+	}
+
+	newStudent := student(350, "Ishaan", 58)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{newStudent}}, nil)
+	assertRows(t, c.read(300, 399).Data, []Row{newStudent})
+	assertRows(t, c.shardData(4, "sh4"), []Row{newStudent})
+
+	var rmResp RemoveResponseSuccess
+	c.mustDo("DELETE", "/rm", RemoveRequest{N: 1, Servers: []string{"Server3"}}, &rmResp)
+	if n := rmResp.Message["N"]; n != float64(3) {
+		t.Errorf("got N=%v after rm, want 3", n)
+	}
+	if _, err := c.orch.Address("Server3"); err == nil {
+		t.Error("Server3 is still running after rm")
+	}
+
+	// sh1 is still served by Server1
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 99).Data, testStudents[:2])
+	}
+
+	var failed apierror.Error
+	if status := c.do("DELETE", "/rm", RemoveRequest{N: 0, Servers: []string{"Server1"}}, &failed); status != http.StatusBadRequest {
+		t.Errorf("got status %d removing more servers than n, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestReplaceServerInstance(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	c.mustDo("PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 99}}, nil)
+	c.mustDo("DELETE", "/del", DeleteRequest{StudID: 150}, nil)
+	remaining := []Row{student(10, "Aarav", 99), testStudents[1], testStudents[3], testStudents[4]}
+
+	if err := c.orch.Stop("Server1"); err != nil {
+		t.Fatal(err)
+	}
+	replaceServerInstance(1)
+
+	replacementID := -1
+	for _, serverID := range getServerIDs() {
+		if serverID == 1 {
+			t.Fatal("Server1 is still listed after being replaced")
+		}
+		if serverID != 2 && serverID != 3 {
+			replacementID = serverID
+		}
+	}
+	if replacementID == -1 {
+		t.Fatalf("no replacement server in %v", getServerIDs())
+	}
+
+	// the replacement replayed the writes, the update and the delete
+	assertRows(t, c.shardData(replacementID, "sh1"), remaining[:2])
+	assertRows(t, c.shardData(replacementID, "sh2"), []Row{})
+	for _, shardID := range []string{"sh1", "sh2"} {
+		if idx, err := getServerIndex(context.Background(), replacementID, shardID); err != nil || idx != c.validIdx(shardID) {
+			t.Errorf("%s: replacement is at index %d (%v), want valid_idx %d", shardID, idx, err, c.validIdx(shardID))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 299).Data, remaining)
+	}
+
+	// the replacement keeps taking writes for its shards
+	newStudent := student(30, "Anika", 88)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{newStudent}}, nil)
+	assertRows(t, c.shardData(replacementID, "sh1"), []Row{remaining[0], remaining[1], newStudent})
+}
+
+func TestReplacementReplicaBehind(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	primaryServerID, _ := c.primary("sh3")
+	if primaryServerID != 2 {
+		t.Fatalf("sh3 has primary Server%d, want Server2", primaryServerID)
+	}
+
+	// Server3 goes down while the primary of sh3, the only replica left to
+	// copy it from, is cut off
+	if err := c.orch.Stop("Server3"); err != nil {
+		t.Fatal(err)
+	}
+	c.orch.setAvailable("Server2", false)
+	replaceServerInstance(3)
+	replacementID := -1
+	for _, serverID := range getServerIDs() {
+		if serverID != 1 && serverID != 2 {
+			replacementID = serverID
+		}
+	}
+	if replacementID == -1 {
+		t.Fatalf("no replacement server in %v", getServerIDs())
+	}
+
+	// sh1 was copied from Server1, sh3 is kept out of mapt and the selector
+	if !slices.Contains(c.replicas("sh1"), replacementID) {
+		t.Errorf("sh1 is on %v, want it on the replacement", c.replicas("sh1"))
+	}
+	if slices.Contains(c.replicas("sh3"), replacementID) {
+		t.Fatalf("sh3 is on %v, want the lagging replacement left out", c.replicas("sh3"))
+	}
+	config, _ := getShardTConfig("sh3")
+	for i := 0; i < 100; i++ {
+		if config.chm.GetServerForRequest(i) == replacementID {
+			t.Fatal("the selector of sh3 picks the lagging replacement")
+		}
+	}
+
+	// once the primary is back the copy catches up and takes Server3's place
+	c.orch.setAvailable("Server2", true)
+	if err := placeReplacementReplica("sh3", 2, 3, replacementID); err != nil {
+		t.Fatal(err)
+	}
+	if replicas := c.replicas("sh3"); !slices.Contains(replicas, replacementID) || slices.Contains(replicas, 3) {
+		t.Errorf("sh3 is on %v, want the replacement in place of Server3", replicas)
+	}
+	assertRows(t, c.shardData(replacementID, "sh3"), testStudents[3:])
+	if idx, err := getServerIndex(context.Background(), replacementID, "sh3"); err != nil || idx != c.validIdx("sh3") {
+		t.Errorf("replacement is at index %d (%v), want valid_idx %d", idx, err, c.validIdx("sh3"))
+	}
+}
+
+func TestGenericSchema(t *testing.T) {
+	c := newTestCluster(t)
+
+	req := testInitRequest()
+	req.Schema = SchemaConfig{
+		Columns: []string{"Emp_id", "Emp_name", "Salary", "Team"},
+		Dtypes:  []string{"INTEGER", "TEXT", "REAL", "String"},
+	}
+	c.mustDo("POST", "/init", req, nil)
+
+	employees := []Row{
+		{"Emp_id": 7, "Emp_name": "Nisha", "Salary": 5250.5, "Team": "storage"},
+		{"Emp_id": 120, "Emp_name": "Vikram", "Salary": 4100, "Team": nil},
+	}
+	c.mustDo("POST", "/write", WriteRequest{Data: employees}, nil)
+
+	var readReq ReadRequest
+	readReq.StudID.Low = 0
+	readReq.StudID.High = 299
+	var resp ReadResponse
+	c.mustDo("POST", "/read", readReq, &resp)
+	sortRows(t, resp.Data)
+	assertRows(t, resp.Data, employees)
+
+	c.mustDo("PUT", "/update", UpdateRequest{StudID: 120, Data: Row{"Team": "compute", "Salary": 4300}}, nil)
+	assertRows(t, c.shardData(2, "sh2"), []Row{{"Emp_id": 120, "Emp_name": "Vikram", "Salary": 4300, "Team": "compute"}})
+
+	// rows are checked against the schema on both tiers
+	if status := c.do("POST", "/write", WriteRequest{Data: []Row{{"Emp_name": "Zoya"}}}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d writing a row without shard key, want %d", status, http.StatusBadRequest)
+	}
+
+	payload, err := json.Marshal(ServerWritePayload{ServerChangePayload: ServerChangePayload{Shard: "sh1"}, Data: []Row{{"Emp_id": 8, "Salary": "lots"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverResp, err := http.Post(getServerURL(1, "/write"), "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverResp.Body.Close()
+	if serverResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d writing a mistyped row to a server, want %d", serverResp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRecoverAfterRestart(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	c.restartLoadBalancer()
+
+	if fmt.Sprint(schemaConfig) != fmt.Sprint(testSchema) {
+		t.Errorf("got schema %v after restart, want %v", schemaConfig, testSchema)
+	}
+	recovered := getServerIDs()
+	sort.Ints(recovered)
+	if fmt.Sprint(recovered) != "[1 2 3]" {
+		t.Errorf("got servers %v after restart, want [1 2 3]", recovered)
+	}
+	if validIDx := c.validIdx("sh3"); validIDx != 2 {
+		t.Errorf("got valid_idx %d for sh3 after restart, want 2", validIDx)
+	}
+
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 299).Data, testStudents)
+	}
+
+	// writes keep counting from the recovered valid_idx
+	newStudent := student(220, "Tara", 69)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{newStudent}}, nil)
+	if validIDx := c.validIdx("sh3"); validIDx != 3 {
+		t.Errorf("got valid_idx %d for sh3, want 3", validIDx)
+	}
+	assertRows(t, c.shardData(2, "sh3"), []Row{testStudents[3], newStudent, testStudents[4]})
+}
+
+func TestHashRingOptions(t *testing.T) {
+	c := newTestCluster(t)
+	req := testInitRequest()
+	req.HashRing = consistenthashmap.Options{Probing: "cubic"}
+	if status := c.do("POST", "/init", req, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown probing, want 400", status)
+	}
+
+	req.HashRing = consistenthashmap.Options{Slots: 2048, Probing: consistenthashmap.Double}
+	c.mustDo("POST", "/init", req, nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	want := consistenthashmap.Options{Slots: 2048, VirtualNodes: consistenthashmap.K, Probing: consistenthashmap.Double}
+	for _, restart := range []bool{false, true} {
+		if restart {
+			c.restartLoadBalancer()
+		}
+		config, _ := getShardTConfig("sh1")
+		if got := config.chm.(*consistenthashmap.ConsistentHashMap).Options(); got != want {
+			t.Errorf("got hash ring %+v (restart %v), want %+v", got, restart, want)
+		}
+		assertRows(t, c.read(0, 299).Data, testStudents)
+	}
+}
+
+func TestServerWeights(t *testing.T) {
+	c := newTestCluster(t)
+	req := testInitRequest()
+	req.Weights = map[string]int{"Server4": 2}
+	if status := c.do("POST", "/init", req, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for the weight of an unknown server, want 400", status)
+	}
+	req.Weights = map[string]int{"Server1": 3}
+	c.mustDo("POST", "/init", req, nil)
+
+	// sh2 is on Server1 and Server2
+	share := func() float64 {
+		t.Helper()
+		config, _ := getShardTConfig("sh2")
+		count := 0
+		for requestID := 0; requestID < 2000; requestID++ {
+			if config.chm.GetServerForRequest(requestID) == 2 {
+				count++
+			}
+		}
+		return float64(count) / 2000
+	}
+	if got := share(); got > 0.4 {
+		t.Errorf("Server2 of weight 1 gets %.2f of the reads of sh2 next to Server1 of weight 3", got)
+	}
+
+	if status := c.do("POST", "/weight", WeightRequest{Server: "Server2", Weight: 0}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for weight 0, want 400", status)
+	}
+	c.mustDo("POST", "/weight", WeightRequest{Server: "Server2", Weight: 9}, nil)
+	if got := share(); got < 0.6 {
+		t.Errorf("Server2 of weight 9 gets %.2f of the reads of sh2 next to Server1 of weight 3", got)
+	}
+
+	c.restartLoadBalancer()
+	var status struct {
+		Weights map[string]int `json:"weights"`
+	}
+	c.mustDo("GET", "/status", nil, &status)
+	if fmt.Sprint(status.Weights) != "map[Server1:3 Server2:9 Server3:1]" {
+		t.Errorf("got weights %v after restart", status.Weights)
+	}
+	if got := share(); got < 0.6 {
+		t.Errorf("Server2 gets %.2f of the reads of sh2 after restart", got)
+	}
+}
+
+func TestDatabaseErrors(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	// a failing metadata database fails the requests that need it, not the
+	// load balancer
+	if _, err := db.Exec("ALTER TABLE shardt RENAME TO shardt_moved; ALTER TABLE mapt RENAME TO mapt_moved;"); err != nil {
+		t.Fatal(err)
+	}
+	var read ReadRequest
+	read.StudID.High = 299
+	for _, req := range []struct {
+		method   string
+		endpoint string
+		body     interface{}
+	}{
+		{"GET", "/status", nil},
+		{"POST", "/read", read},
+		{"POST", "/write", WriteRequest{Data: []Row{student(30, "Anika", 88)}}},
+		{"PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 99}}},
+		{"DELETE", "/del", DeleteRequest{StudID: 10}},
+	} {
+		var failed apierror.Error
+		if status := c.do(req.method, req.endpoint, req.body, &failed); status != http.StatusInternalServerError || failed.Code != apierror.Internal {
+			t.Errorf("%s %s: got status %d and code %q, want 500 and internal", req.method, req.endpoint, status, failed.Code)
+		}
+	}
+
+	if _, err := db.Exec("ALTER TABLE shardt_moved RENAME TO shardt; ALTER TABLE mapt_moved RENAME TO mapt;"); err != nil {
+		t.Fatal(err)
+	}
+	c.mustDo("GET", "/status", nil, nil)
+	assertRows(t, c.read(0, 299).Data, testStudents)
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	for _, req := range []struct {
+		method   string
+		endpoint string
+		body     interface{}
+		code     apierror.Code
+		shard    string
+		server   string
+	}{
+		{"GET", "/write", nil, apierror.MethodNotAllowed, "", ""},
+		{"POST", "/status", nil, apierror.MethodNotAllowed, "", ""},
+		{"POST", "/write", WriteRequest{Data: []Row{{"Stud_name": "Anika"}}}, apierror.BadRequest, "", ""},
+		{"DELETE", "/rm", RemoveRequest{N: 4}, apierror.BadRequest, "", ""},
+		{"POST", "/split", SplitRequest{Shard: "sh9", At: 10, NewShard: "sh10"}, apierror.NotFound, "sh9", ""},
+		// turned down by the primary of sh1
+		{"PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_id": 11}}, apierror.BadRequest, "sh1", "Server1"},
+	} {
+		var failed apierror.Error
+		status := c.do(req.method, req.endpoint, req.body, &failed)
+		if status != req.code.Status() || failed.Status != "failure" || failed.Code != req.code || failed.Message == "" {
+			t.Errorf("%s %s: got status %d and %+v, want %d and code %q", req.method, req.endpoint, status, failed, req.code.Status(), req.code)
+		}
+		if failed.Shard != req.shard || failed.Server != req.server {
+			t.Errorf("%s %s: got shard %q and server %q, want %q and %q", req.method, req.endpoint, failed.Shard, failed.Server, req.shard, req.server)
+		}
+	}
+
+	// a primary that cannot be reached is worth retrying
+	c.orch.setAvailable("Server1", false)
+	var failed apierror.Error
+	if status := c.do("PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 99}}, &failed); status != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d updating with the primary down, want 503", status)
+	}
+	if !failed.Code.Retryable() || failed.Shard != "sh1" || failed.Server != "Server1" {
+		t.Errorf("got %+v updating with the primary down, want a retryable error for sh1 on Server1", failed)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+)
+
+// The load balancer keeps everything it needs to rebuild its in-memory state
+// in its database: the schema, hash ring options and read routing in configt,
+// the shards and their valid_idx in shardt, the replica placement in mapt, the
+// live servers in servert and the multi-shard writes still being committed in
+// txnt.
+
+func saveConfig(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO configt (key, value) VALUES (?, ?);", key, string(data))
+	return err
+}
+
+// loadConfig decodes the value saved under key into value, reporting whether
+// there was one.
+func loadConfig(key string, value interface{}) (bool, error) {
+	var data string
+	err := db.QueryRow("SELECT value FROM configt WHERE key = ?;", key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(data), value)
+}
+
+func addServerRecord(serverID int, weight int) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO servert (server_id, weight) VALUES (?, ?);", serverID, weight)
+	return err
+}
+
+func removeServerRecord(serverID int) error {
+	_, err := db.Exec("DELETE FROM servert WHERE server_id = ?;", serverID)
+	if err != nil {
+		return err
+	}
+	forgetServerLoad(serverID)
+	return nil
+}
+
+// getShards returns every shard in shardt.
+func getShards() ([]Shard, error) {
+	rows, err := db.Query("SELECT stud_id_low, shard_id, shard_size, COALESCE(replicas, 0), COALESCE(selector, '') FROM shardt;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shards := []Shard{}
+	for rows.Next() {
+		var shard Shard
+		err = rows.Scan(&shard.StudIDLow, &shard.ShardID, &shard.ShardSize, &shard.Replicas, &shard.Selector)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shards, rows.Err()
+}
+
+// shardTConfigs are read by every request and changed by /init, /add, splits,
+// merges and recovery, so they are only read and changed through the
+// functions below.
+var (
+	shardTConfigsMutex sync.RWMutex
+	shardTConfigs      = map[string]ShardTConfig{}
+)
+
+// getShardTConfig returns the config of a shard, or false if there is no such
+// shard or it was retired.
+func getShardTConfig(shardID string) (ShardTConfig, bool) {
+	shardTConfigsMutex.RLock()
+	defer shardTConfigsMutex.RUnlock()
+	config, ok := shardTConfigs[shardID]
+	return config, ok && !config.retired
+}
+
+// retireShardTConfig marks the config of a shard that no longer exists.
+func retireShardTConfig(shardID string) {
+	shardTConfigsMutex.Lock()
+	defer shardTConfigsMutex.Unlock()
+	if config, ok := shardTConfigs[shardID]; ok {
+		config.retired = true
+		shardTConfigs[shardID] = config
+	}
+}
+
+// resetShardTConfigs forgets the configs of all shards.
+func resetShardTConfigs() {
+	shardTConfigsMutex.Lock()
+	defer shardTConfigsMutex.Unlock()
+	shardTConfigs = map[string]ShardTConfig{}
+}
+
+func addToShardHashMap(shardID string, serverID int, weight int) {
+	if config, ok := getShardTConfig(shardID); ok {
+		config.chm.AddWeightedServer(serverID, weight)
+	}
+}
+
+func removeFromShardHashMap(shardID string, serverID int) {
+	if config, ok := getShardTConfig(shardID); ok {
+		config.chm.RemoveServer(serverID)
+	}
+}
+
+// lockShard locks the mutex of a shard and returns the function unlocking it,
+// or false if there is no such shard or it was retired while waiting for the
+// mutex.
+func lockShard(shardID string) (func(), bool) {
+	config, ok := getShardTConfig(shardID)
+	if !ok {
+		return nil, false
+	}
+	config.mutex.Lock()
+	if current, ok := getShardTConfig(shardID); !ok || current.mutex != config.mutex {
+		config.mutex.Unlock()
+		return nil, false
+	}
+	return config.mutex.Unlock, true
+}
+
+// addShardRecord adds a new shard to shardt and sets up its hash map.
+func addShardRecord(shard Shard, replicas int) error {
+	_, err := db.Exec("INSERT INTO shardt (stud_id_low, shard_id, shard_size, valid_idx, primary_server, term, replicas, selector) VALUES (?, ?, ?, ?, ?, ?, ?, ?);", shard.StudIDLow, shard.ShardID, shard.ShardSize, 0, -1, 0, replicas, shard.Selector)
+	if err != nil {
+		return err
+	}
+	return loadShardTConfig(shard.ShardID)
+}
+
+// loadShardTConfig sets up the mutex of a shard and builds its consistent
+// hash map.
+func loadShardTConfig(shardID string) error {
+	chm, err := buildShardHashMap(shardID)
+	if err != nil {
+		return err
+	}
+
+	shardTConfigsMutex.Lock()
+	defer shardTConfigsMutex.Unlock()
+	shardTConfigs[shardID] = ShardTConfig{chm: chm, mutex: &sync.Mutex{}}
+	return nil
+}
+
+// reloadShardHashMap rebuilds the replica selector of a shard. Callers must
+// hold the shard mutex.
+func reloadShardHashMap(shardID string) error {
+	chm, err := buildShardHashMap(shardID)
+	if err != nil {
+		return err
+	}
+
+	shardTConfigsMutex.Lock()
+	defer shardTConfigsMutex.Unlock()
+	config := shardTConfigs[shardID]
+	config.chm = chm
+	shardTConfigs[shardID] = config
+	return nil
+}
+
+// buildShardHashMap builds the replica selector of a shard from the servers
+// mapt places it on, weighted as in servert.
+func buildShardHashMap(shardID string) (consistenthashmap.ReplicaSelector, error) {
+	var selector string
+	err := db.QueryRow("SELECT COALESCE(selector, '') FROM shardt WHERE shard_id = ?;", shardID).Scan(&selector)
+	if err != nil {
+		return nil, err
+	}
+	chm, err := consistenthashmap.NewSelector(selector, hashRingOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT server_id FROM mapt WHERE shard_id = ?;", shardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	serverIDs := []int{}
+	for rows.Next() {
+		var serverID int
+		err = rows.Scan(&serverID)
+		if err != nil {
+			return nil, err
+		}
+		serverIDs = append(serverIDs, serverID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, serverID := range serverIDs {
+		weight, err := getServerWeight(serverID)
+		if err != nil {
+			return nil, err
+		}
+		chm.AddWeightedServer(serverID, weight)
+	}
+	return chm, nil
+}
+
+func isServerAlive(serverID int) bool {
+	address, err := orch.Address(fmt.Sprintf("Server%d", serverID))
+	if err != nil {
+		return false
+	}
+	resp, err := http.Get("http://" + address + "/heartbeat")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// recoverState rebuilds the in-memory cluster state from the database left by
+// a previous run and re-adopts its servers. Servers that do not answer their
+// heartbeat stay listed, so monitorServers replaces them once it starts.
+func recoverState() error {
+	ok, err := loadConfig("schema", &schemaConfig)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := loadConfig("hash_ring", &hashRingOptions); err != nil {
+		return err
+	}
+	if _, err := loadConfig("read_routing", &readRouting); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT shard_id FROM shardt;")
+	if err != nil {
+		return err
+	}
+	shardIDs := []string{}
+	for rows.Next() {
+		var shardID string
+		if err := rows.Scan(&shardID); err != nil {
+			rows.Close()
+			return err
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+	rows.Close()
+
+	for _, shardID := range shardIDs {
+		if err := loadShardTConfig(shardID); err != nil {
+			return err
+		}
+		if err := resumeTransactions(shardID); err != nil {
+			slog.Warn("error resuming transactions", "shard", shardID, logging.Error(err))
+		}
+	}
+
+	rows, err = db.Query("SELECT server_id FROM servert;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var serverID int
+		if err := rows.Scan(&serverID); err != nil {
+			return err
+		}
+		addServerID(serverID)
+
+		if isServerAlive(serverID) {
+			slog.Info("re-adopted server", "server", fmt.Sprintf("Server%d", serverID))
+		} else {
+			slog.Warn("server is not responding, it will be replaced", "server", fmt.Sprintf("Server%d", serverID))
+		}
+	}
+
+	slog.Info("recovered cluster state", "shards", len(shardIDs), "servers", len(getServerIDs()))
+	return rows.Err()
+}
+
+// resetState forgets the whole cluster, for when its servers are stopped.
+func resetState() error {
+	_, err := db.Exec("DELETE FROM shardt; DELETE FROM mapt; DELETE FROM servert; DELETE FROM txnt; DELETE FROM configt;")
+	return err
+}
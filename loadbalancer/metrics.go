@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/metrics"
+)
+
+// The load balancer serves its metrics at /metrics in the Prometheus text
+// format.
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+	httpMetrics     = metricsRegistry.NewHTTPMetrics("galaxydb_lb")
+
+	shardReads         = metricsRegistry.NewCounter("galaxydb_lb_shard_reads_total", "Reads, queries and aggregations of a shard, by shard.", "shard")
+	shardWrites        = metricsRegistry.NewCounter("galaxydb_lb_shard_writes_total", "Rows written, updated or deleted, by shard.", "shard")
+	serverErrors       = metricsRegistry.NewCounter("galaxydb_lb_server_errors_total", "Requests fanned out to a shard server that failed, by server and endpoint.", "server", "endpoint")
+	heartbeatFailures  = metricsRegistry.NewCounter("galaxydb_lb_heartbeat_failures_total", "Heartbeats a server missed, by server.", "server")
+	serverReplacements = metricsRegistry.NewCounter("galaxydb_lb_server_replacements_total", "Failed servers replaced by a new instance.")
+)
+
+// slotRing is a selector that places servers on a ring of slots.
+type slotRing interface {
+	Options() consistenthashmap.Options
+	SlotCounts() map[int]int
+}
+
+func init() {
+	metricsRegistry.NewGaugeFunc("galaxydb_lb_hash_ring_slots", "Slots of the ring of a shard, for shards whose selector uses one.", []string{"shard"}, func(set func(float64, ...string)) {
+		forEachRing(func(shardID string, ring slotRing) {
+			set(float64(ring.Options().Slots), shardID)
+		})
+	})
+	metricsRegistry.NewGaugeFunc("galaxydb_lb_hash_ring_occupied_slots", "Slots of the ring of a shard held by each server.", []string{"shard", "server"}, func(set func(float64, ...string)) {
+		forEachRing(func(shardID string, ring slotRing) {
+			for serverID, count := range ring.SlotCounts() {
+				set(float64(count), shardID, fmt.Sprintf("Server%d", serverID))
+			}
+		})
+	})
+}
+
+// forEachRing calls visit with the ring of every shard that has one. It does
+// not take the shard mutexes, which writes and copies hold for long, as the
+// selectors are safe for concurrent use: a scrape is never held up by them.
+func forEachRing(visit func(shardID string, ring slotRing)) {
+	if db == nil {
+		return
+	}
+	shardIDs, err := getShardIDs()
+	if err != nil {
+		slog.Warn("error listing shards for metrics", logging.Error(err))
+		return
+	}
+	for _, shardID := range shardIDs {
+		config, ok := getShardTConfig(shardID)
+		if !ok {
+			continue
+		}
+		if ring, ok := config.chm.(slotRing); ok {
+			visit(shardID, ring)
+		}
+	}
+}
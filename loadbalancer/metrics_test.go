@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the value of every series at /metrics.
+func (c *testCluster) scrape() map[string]float64 {
+	c.t.Helper()
+
+	resp, err := http.Get(c.lb.URL + "/metrics")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			c.t.Fatalf("bad metrics line %q", line)
+		}
+		values[line[:i]] = value
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	readRouting = ROUTING_HASH
+	before := c.scrape()
+
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	c.orch.setAvailable("Server1", false)
+	for i := 0; i < 20; i++ {
+		c.read(0, 99)
+	}
+
+	var after map[string]float64
+	for deadline := time.Now().Add(HEARTBEAT_INTERVAL / 2); ; {
+		after = c.scrape()
+		if after[`galaxydb_lb_heartbeat_failures_total{server="Server1"}`] > before[`galaxydb_lb_heartbeat_failures_total{server="Server1"}`] || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for series, want := range map[string]float64{
+		`galaxydb_lb_http_requests_total{endpoint="/write",method="POST",code="200"}`: 1,
+		`galaxydb_lb_http_request_duration_seconds_count{endpoint="/read"}`:           20,
+		`galaxydb_lb_shard_writes_total{shard="sh1"}`:                                 2,
+		`galaxydb_lb_shard_writes_total{shard="sh3"}`:                                 2,
+		`galaxydb_lb_shard_reads_total{shard="sh1"}`:                                  20,
+	} {
+		if got := after[series] - before[series]; got != want {
+			t.Errorf("%s went up by %v, want %v", series, got, want)
+		}
+	}
+	// heartbeat checks left over from other tests may count too
+	if after[`galaxydb_lb_heartbeat_failures_total{server="Server1"}`] == before[`galaxydb_lb_heartbeat_failures_total{server="Server1"}`] {
+		t.Error("no heartbeat failures counted for Server1")
+	}
+	if after[`galaxydb_lb_server_errors_total{server="Server1",endpoint="/read"}`] == 0 {
+		t.Error("no read errors counted for Server1")
+	}
+	if got := after[`galaxydb_lb_hash_ring_slots{shard="sh1"}`]; got != 512 {
+		t.Errorf("got %v slots on the ring of sh1, want 512", got)
+	}
+	if got := after[`galaxydb_lb_hash_ring_occupied_slots{shard="sh1",server="Server3"}`]; got != 9 {
+		t.Errorf("got %v slots for Server3 on the ring of sh1, want 9", got)
+	}
+}
+
+func TestMetricsWhileShardLocked(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	// a write or copy may hold a shard's mutex for long
+	unlock, _ := lockShard("sh1")
+	defer unlock()
+	scraped := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(c.lb.URL + "/metrics")
+		if err != nil {
+			scraped <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		scraped <- string(body)
+	}()
+	select {
+	case body := <-scraped:
+		if !strings.Contains(body, `galaxydb_lb_hash_ring_slots{shard="sh1"} 512`) {
+			t.Errorf("got %q, want the 512 slots of the ring of sh1", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("scrape waited for the mutex of sh1")
+	}
+}
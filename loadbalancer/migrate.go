@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+)
+
+// A replica of a shard is moved to another server, or a new one created, in
+// the same way a server that went down is replaced: the new replica is
+// configured and copied the bulk of the shard's log without holding up
+// writes, then, under the shard mutex, caught up from the primary and added
+// to mapt and to the shard's hash map. A moved replica's old copy is dropped
+// once nothing is routed to it anymore.
+
+// errShardGone is returned for replicas of a shard that was merged away or
+// discarded while they were being copied.
+var errShardGone = errors.New("shard no longer exists")
+
+// errReplicasChanged is returned for replicas whose placement was changed by
+// another move while they were being copied.
+var errReplicasChanged = errors.New("replicas of the shard changed")
+
+// copyReplica creates a replica of a shard on target, copying the bulk of it
+// from source, and calls place under the shard mutex once target has caught
+// up with the primary. The partial copy is dropped if this or place fails,
+// unless mapt places the shard on target by then, as another move may have.
+func copyReplica(shardID string, sourceServerID int, targetServerID int, place func(primaryServerID int) error) error {
+	if _, ok := getShardTConfig(shardID); !ok {
+		return fmt.Errorf("%s: %w", shardID, errShardGone)
+	}
+	if err := configNewServerInstance(context.Background(), targetServerID, []string{shardID}, schemaConfig); err != nil {
+		return err
+	}
+
+	// if source is behind or down the primary makes up for it below
+	if _, err := catchUpReplica(context.Background(), shardID, sourceServerID, targetServerID); err != nil {
+		slog.Warn("error copying replica", "shard", shardID, "server", fmt.Sprintf("Server%d", sourceServerID), logging.Error(err))
+	}
+
+	// the shard may have been merged away while the bulk was copied
+	unlock, ok := lockShard(shardID)
+	if !ok {
+		dropShard(context.Background(), shardID, []int{targetServerID})
+		return fmt.Errorf("%s: %w", shardID, errShardGone)
+	}
+	defer unlock()
+	dropCopy := func() {
+		if holds, err := serverHoldsShard(shardID, targetServerID); err == nil && !holds {
+			dropShard(context.Background(), shardID, []int{targetServerID})
+		}
+	}
+
+	primaryServerID, _, err := getShardPrimary(db, shardID)
+	if err == nil && primaryServerID == -1 {
+		err = fmt.Errorf("%s has no primary to copy from", shardID)
+	}
+	if err != nil {
+		dropCopy()
+		return err
+	}
+	currentIndex, err := catchUpReplica(context.Background(), shardID, primaryServerID, targetServerID)
+	if err == nil {
+		var validIdx int
+		validIdx, err = getValidIDx(db, shardID)
+		if err == nil && currentIndex != validIdx {
+			err = fmt.Errorf("Server%d is at index %d, expected %d", targetServerID, currentIndex, validIdx)
+		}
+	}
+	if err == nil {
+		err = place(primaryServerID)
+	}
+	if err != nil {
+		dropCopy()
+		return fmt.Errorf("copying %s to Server%d: %w", shardID, targetServerID, err)
+	}
+	return nil
+}
+
+// migrateReplica moves the replica of a shard on one server to another.
+// Callers must hold placementMutex.
+func migrateReplica(shardID string, fromServerID int, toServerID int) error {
+	// copy from the replica being moved, sparing the primary
+	err := copyReplica(shardID, fromServerID, toServerID, func(primaryServerID int) error {
+		// the handler checked these before the copy, but another move may
+		// have been placed since
+		holdsFrom, err := serverHoldsShard(shardID, fromServerID)
+		if err != nil {
+			return err
+		}
+		holdsTo, err := serverHoldsShard(shardID, toServerID)
+		if err != nil {
+			return err
+		}
+		if !holdsFrom || holdsTo {
+			return fmt.Errorf("Server%d no longer holds %s or Server%d already does: %w", fromServerID, shardID, toServerID, errReplicasChanged)
+		}
+		if err := replaceShardReplica(shardID, fromServerID, toServerID); err != nil {
+			return err
+		}
+		removeFromShardHashMap(shardID, fromServerID)
+		if primaryServerID == fromServerID {
+			if err := electPrimary(context.Background(), shardID); err != nil {
+				slog.Warn("error electing primary", "shard", shardID, logging.Error(err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dropShard(context.Background(), shardID, []int{fromServerID})
+	slog.Info("replica moved", "shard", shardID, "from", fmt.Sprintf("Server%d", fromServerID), "to", fmt.Sprintf("Server%d", toServerID))
+	return nil
+}
+
+// addReplica creates a new replica of a shard on a server. Callers must hold
+// placementMutex.
+func addReplica(shardID string, serverID int) error {
+	primaryServerID, _, err := getShardPrimary(db, shardID)
+	if err != nil {
+		return err
+	}
+	if primaryServerID == -1 {
+		return fmt.Errorf("%s has no primary to copy from", shardID)
+	}
+	err = copyReplica(shardID, primaryServerID, serverID, func(int) error {
+		holds, err := serverHoldsShard(shardID, serverID)
+		if err != nil {
+			return err
+		}
+		if holds {
+			return fmt.Errorf("Server%d already holds %s: %w", serverID, shardID, errReplicasChanged)
+		}
+		weight, err := getServerWeight(serverID)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", shardID, serverID)
+		if err != nil {
+			return err
+		}
+		addToShardHashMap(shardID, serverID, weight)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("replica added", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID))
+	return nil
+}
+
+func migrateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req MigrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	if _, ok := getShardTConfig(req.Shard); !ok {
+		writeError(w, r, apierror.Errorf(apierror.NotFound, "No shard %s", req.Shard).WithShard(req.Shard))
+		return
+	}
+	fromServerID, err := getExistingServerID(req.From)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+	toServerID, err := getExistingServerID(req.To)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+	shardServerIDs, err := getServerIDsForShard(db, req.Shard)
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding replicas").WithShard(req.Shard))
+		return
+	}
+	holdsShard := map[int]bool{}
+	for _, serverID := range shardServerIDs {
+		holdsShard[serverID] = true
+	}
+	if !holdsShard[fromServerID] {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%s does not hold %s", req.From, req.Shard).WithShard(req.Shard))
+		return
+	}
+	if holdsShard[toServerID] {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%s already holds %s", req.To, req.Shard).WithShard(req.Shard))
+		return
+	}
+
+	if err := migrateReplica(req.Shard, fromServerID, toServerID); err != nil {
+		writeError(w, r, errorFrom(err, "error moving replica").WithShard(req.Shard).WithServer(req.From))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Moved %s from %s to %s", req.Shard, req.From, req.To),
+		"status":  "success",
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMigrateReplica(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	c.mustDo("POST", "/add", AddRequest{N: 1, Servers: map[string][]string{"Server4": {}}}, nil)
+
+	for _, req := range []MigrateRequest{
+		{Shard: "sh2", From: "Server3", To: "Server4"},
+		{Shard: "sh2", From: "Server1", To: "Server2"},
+		{Shard: "sh2", From: "Server1", To: "Server9"},
+	} {
+		if status := c.do("POST", "/migrate", req, nil); status != http.StatusBadRequest {
+			t.Errorf("migrate %+v: got status %d, want 400", req, status)
+		}
+	}
+
+	// Server1 is the primary of sh2, so it gets a new one
+	if primaryServerID, _ := c.primary("sh2"); primaryServerID != 1 {
+		t.Fatalf("got primary Server%d for sh2, want Server1", primaryServerID)
+	}
+	c.mustDo("POST", "/migrate", MigrateRequest{Shard: "sh2", From: "Server1", To: "Server4"}, nil)
+
+	var status struct {
+		Servers map[string][]string `json:"servers"`
+	}
+	c.mustDo("GET", "/status", nil, &status)
+	for _, shardID := range status.Servers["Server1"] {
+		if shardID == "sh2" {
+			t.Error("Server1 still holds sh2")
+		}
+	}
+	if got := status.Servers["Server4"]; len(got) != 1 || got[0] != "sh2" {
+		t.Errorf("got shards %v on Server4, want [sh2]", got)
+	}
+	if primaryServerID, _ := c.primary("sh2"); primaryServerID == 1 {
+		t.Error("Server1 is still the primary of sh2")
+	}
+	assertRows(t, c.shardData(4, "sh2"), testStudents[2:3])
+
+	newStudent := student(160, "Tara", 58)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{newStudent}}, nil)
+	want := []Row{testStudents[2], newStudent}
+	assertRows(t, c.shardData(2, "sh2"), want)
+	assertRows(t, c.shardData(4, "sh2"), want)
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(100, 199).Data, want)
+	}
+
+	// a replica whose shard is merged away while it is copied is dropped
+	unlock, ok := lockShard("sh2")
+	if !ok {
+		t.Fatal("sh2 has no config")
+	}
+	done := make(chan int)
+	go func() {
+		done <- c.do("POST", "/migrate", MigrateRequest{Shard: "sh2", From: "Server2", To: "Server1"}, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	retireShardTConfig("sh2")
+	unlock()
+	if status := <-done; status != http.StatusConflict {
+		t.Errorf("got status %d moving a replica of a merged shard, want 409", status)
+	}
+	assertRows(t, c.shardData(2, "sh2"), want)
+	c.mustDo("GET", "/status", nil, &status)
+	for _, shardID := range status.Servers["Server1"] {
+		if shardID == "sh2" {
+			t.Error("Server1 holds sh2 after it was merged away")
+		}
+	}
+}
+
+func TestConcurrentMigrations(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	c.mustDo("POST", "/add", AddRequest{N: 2, Servers: map[string][]string{"Server4": {}, "Server5": {}}}, nil)
+
+	// sh2 is on Server1 and Server2; both moves are sent at once, and only
+	// one of them is placed
+	for _, move := range []struct {
+		from    string
+		targets []string
+	}{
+		{"Server2", []string{"Server4", "Server5"}},
+		{"Server1", []string{"Server2", "Server2"}},
+	} {
+		from, targets := move.from, move.targets
+		unlock, ok := lockShard("sh2")
+		if !ok {
+			t.Fatal("sh2 has no config")
+		}
+		statuses := make(chan int, len(targets))
+		for _, to := range targets {
+			go func(to string) {
+				statuses <- c.do("POST", "/migrate", MigrateRequest{Shard: "sh2", From: from, To: to}, nil)
+			}(to)
+		}
+		time.Sleep(50 * time.Millisecond)
+		unlock()
+
+		got := map[int]int{}
+		for range targets {
+			got[<-statuses]++
+		}
+		if got[http.StatusOK] != 1 || got[http.StatusBadRequest]+got[http.StatusConflict] != 1 {
+			t.Errorf("moving sh2 from %s to %v: got statuses %v, want one 200 and one 400 or 409", from, targets, got)
+		}
+
+		replicas := c.replicas("sh2")
+		if len(replicas) != 2 {
+			t.Fatalf("got sh2 on %v, want 2 servers", replicas)
+		}
+		config, _ := getShardTConfig("sh2")
+		for requestID := 0; requestID < 1000; requestID++ {
+			if serverID := config.chm.GetServerForRequest(requestID); !slices.Contains(replicas, serverID) {
+				t.Fatalf("reads of sh2 go to Server%d, which mapt does not place it on", serverID)
+			}
+		}
+		for _, serverID := range replicas {
+			assertRows(t, c.shardData(serverID, "sh2"), testStudents[2:3])
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Where shards live can be left to the load balancer. The planner places each
+// shard on as many servers as the cluster keeps replicas of, always picking
+// the servers holding the fewest replicas so far, so replicas end up spread
+// evenly and no server holds two copies of the same shard.
+
+// placementMutex is held by whatever moves or adds replicas, /migrate, /rm,
+// rebalancing and the reconciler, so none of them plans a move against a
+// placement another is about to change. It is taken before any shard mutex.
+var placementMutex sync.Mutex
+
+// getServerLoad returns how many shard replicas each live server holds.
+func getServerLoad() (map[int]int, error) {
+	load := map[int]int{}
+	for _, serverID := range getServerIDs() {
+		load[serverID] = 0
+	}
+
+	rows, err := db.Query("SELECT server_id, COUNT(*) FROM mapt GROUP BY server_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var serverID, count int
+		if err := rows.Scan(&serverID, &count); err != nil {
+			return nil, err
+		}
+		if _, ok := load[serverID]; ok {
+			load[serverID] = count
+		}
+	}
+	return load, rows.Err()
+}
+
+// getReplicationFactor returns how many replicas new shards get.
+func getReplicationFactor() (int, error) {
+	replicas := DEFAULT_REPLICAS
+	if _, err := loadConfig("replicas", &replicas); err != nil {
+		return 0, err
+	}
+	return replicas, nil
+}
+
+// nextServerIDs returns n ids for new servers, following the highest one in
+// use.
+func nextServerIDs(n int) []int {
+	highest := 0
+	for _, serverID := range getServerIDs() {
+		highest = max(highest, serverID)
+	}
+
+	ids := []int{}
+	for i := 1; i <= n; i++ {
+		ids = append(ids, highest+i)
+	}
+	return ids
+}
+
+// getShardReplicas returns the replication factor of each shard: the one it
+// asks for, else the number of servers assignments places it on, else
+// replicas.
+func getShardReplicas(shards []Shard, assignments map[string][]string, replicas int) map[string]int {
+	placed := map[string]int{}
+	for _, shardIDs := range assignments {
+		for _, shardID := range shardIDs {
+			placed[shardID]++
+		}
+	}
+
+	shardReplicas := map[string]int{}
+	for _, shard := range shards {
+		switch {
+		case shard.Replicas > 0:
+			shardReplicas[shard.ShardID] = shard.Replicas
+		case placed[shard.ShardID] > 0:
+			shardReplicas[shard.ShardID] = placed[shard.ShardID]
+		default:
+			shardReplicas[shard.ShardID] = replicas
+		}
+	}
+	return shardReplicas
+}
+
+// getShardReplicationFactor returns how many replicas a shard is kept at.
+func getShardReplicationFactor(shardID string) (int, error) {
+	var replicas int
+	err := db.QueryRow("SELECT COALESCE(replicas, 0) FROM shardt WHERE shard_id = ?;", shardID).Scan(&replicas)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if replicas <= 0 {
+		return getReplicationFactor()
+	}
+	return replicas, nil
+}
+
+// planPlacement places each shard on as many of the servers in load as
+// replicas asks for. load holds how many replicas each server has already.
+// Servers holding fewer replicas go first, ties going to the lowest id.
+func planPlacement(shardIDs []string, load map[int]int, replicas map[string]int) map[int][]string {
+	candidates := []int{}
+	counts := map[int]int{}
+	for serverID, count := range load {
+		candidates = append(candidates, serverID)
+		counts[serverID] = count
+	}
+
+	placement := map[int][]string{}
+	for _, shardID := range shardIDs {
+		sort.Slice(candidates, func(i, j int) bool {
+			if counts[candidates[i]] != counts[candidates[j]] {
+				return counts[candidates[i]] < counts[candidates[j]]
+			}
+			return candidates[i] < candidates[j]
+		})
+		for _, serverID := range candidates[:min(replicas[shardID], len(candidates))] {
+			placement[serverID] = append(placement[serverID], shardID)
+			counts[serverID]++
+		}
+	}
+	return placement
+}
+
+// placeShards works out which shards each server gets for /init and /add. The
+// new servers are the ones named in assignments, or n new ones if there are
+// none. Shards in shardIDs that assignments do not place anywhere are spread
+// over the new and the existing servers with as many copies as replicas asks
+// for. It returns the shards every server gets, new or existing, and the new
+// servers.
+func placeShards(n int, assignments map[string][]string, shardIDs []string, replicas map[string]int) (map[int][]string, []int, error) {
+	placement := map[int][]string{}
+	placed := map[string]bool{}
+	newServerIDs := []int{}
+
+	if len(assignments) == 0 {
+		newServerIDs = nextServerIDs(n)
+	}
+	for serverName, assigned := range assignments {
+		serverID, err := getServerID(serverName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isServer(serverID) {
+			return nil, nil, fmt.Errorf("%s already exists", serverName)
+		}
+		newServerIDs = append(newServerIDs, serverID)
+		placement[serverID] = append([]string{}, assigned...)
+		for _, shardID := range assigned {
+			placed[shardID] = true
+		}
+	}
+	sort.Ints(newServerIDs)
+
+	unplaced := []string{}
+	for _, shardID := range shardIDs {
+		if !placed[shardID] {
+			unplaced = append(unplaced, shardID)
+		}
+	}
+	if len(unplaced) == 0 {
+		return placement, newServerIDs, nil
+	}
+
+	load, err := getServerLoad()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, serverID := range newServerIDs {
+		load[serverID] = len(placement[serverID])
+	}
+	for _, shardID := range unplaced {
+		if replicas[shardID] > len(load) {
+			return nil, nil, fmt.Errorf("cannot place %d replicas of %s on %d servers", replicas[shardID], shardID, len(load))
+		}
+	}
+	for serverID, shardIDs := range planPlacement(unplaced, load, replicas) {
+		placement[serverID] = append(placement[serverID], shardIDs...)
+	}
+	return placement, newServerIDs, nil
+}
+
+// rebalanceShards moves replicas from the servers holding the most to the
+// ones holding the fewest until no two servers differ by more than one, and
+// returns how many it moved. Replicas that are not their shard's primary are
+// moved first, so fewer elections are needed.
+func rebalanceShards() (int, error) {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+
+	moved := 0
+	for {
+		load, err := getServerLoad()
+		if err != nil {
+			return moved, err
+		}
+		candidates := []int{}
+		for serverID := range load {
+			candidates = append(candidates, serverID)
+		}
+		if len(candidates) < 2 {
+			return moved, nil
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if load[candidates[i]] != load[candidates[j]] {
+				return load[candidates[i]] < load[candidates[j]]
+			}
+			return candidates[i] < candidates[j]
+		})
+		leastServerID, mostServerID := candidates[0], candidates[len(candidates)-1]
+		if load[mostServerID]-load[leastServerID] <= 1 {
+			return moved, nil
+		}
+
+		leastShardIDs, err := getShardIDsForServer(leastServerID)
+		if err != nil {
+			return moved, err
+		}
+		mostShardIDs, err := getShardIDsForServer(mostServerID)
+		if err != nil {
+			return moved, err
+		}
+		held := map[string]bool{}
+		for _, shardID := range leastShardIDs {
+			held[shardID] = true
+		}
+		shardIDToMove := ""
+		for _, shardID := range mostShardIDs {
+			if held[shardID] {
+				continue
+			}
+			primaryServerID, _, err := getShardPrimary(db, shardID)
+			if err != nil {
+				return moved, err
+			}
+			if shardIDToMove == "" || primaryServerID != mostServerID {
+				shardIDToMove = shardID
+			}
+			if primaryServerID != mostServerID {
+				break
+			}
+		}
+		if shardIDToMove == "" {
+			return moved, nil
+		}
+
+		if err := migrateReplica(shardIDToMove, mostServerID, leastServerID); err != nil {
+			return moved, err
+		}
+		slog.Info("replica moved to rebalance shards", "shard", shardIDToMove, "from", fmt.Sprintf("Server%d", mostServerID), "to", fmt.Sprintf("Server%d", leastServerID))
+		moved++
+	}
+}
+
+func getShardIDsForServer(serverID int) ([]string, error) {
+	rows, err := db.Query("SELECT shard_id FROM mapt WHERE server_id = ? ORDER BY shard_id;", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shardIDs := []string{}
+	for rows.Next() {
+		var shardID string
+		if err := rows.Scan(&shardID); err != nil {
+			return nil, err
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+	return shardIDs, rows.Err()
+}
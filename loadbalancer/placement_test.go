@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPlanPlacement(t *testing.T) {
+	replicas := map[string]int{"sh1": 2, "sh2": 2, "sh3": 2, "sh4": 2}
+	placement := planPlacement([]string{"sh1", "sh2", "sh3", "sh4"}, map[int]int{1: 0, 2: 0, 3: 1}, replicas)
+
+	counts := map[string]int{}
+	for serverID, shardIDs := range placement {
+		held := map[string]bool{}
+		for _, shardID := range shardIDs {
+			if held[shardID] {
+				t.Errorf("Server%d holds %s twice", serverID, shardID)
+			}
+			held[shardID] = true
+			counts[shardID]++
+		}
+	}
+	for _, shardID := range []string{"sh1", "sh2", "sh3", "sh4"} {
+		if counts[shardID] != 2 {
+			t.Errorf("got %d replicas of %s, want 2", counts[shardID], shardID)
+		}
+	}
+	// with the replica Server3 already holds, every server ends up with three
+	for serverID, want := range map[int]int{1: 3, 2: 3, 3: 2} {
+		if got := len(placement[serverID]); got != want {
+			t.Errorf("Server%d got %d shards, want %d", serverID, got, want)
+		}
+	}
+}
+
+func assertBalanced(t *testing.T, c *testCluster, wantServers int, wantReplicas int) {
+	t.Helper()
+
+	var status struct {
+		Shards  []Shard             `json:"shards"`
+		Servers map[string][]string `json:"servers"`
+	}
+	c.mustDo("GET", "/status", nil, &status)
+	if len(status.Servers) != wantServers {
+		t.Fatalf("got %d servers, want %d", len(status.Servers), wantServers)
+	}
+
+	counts := map[string]int{}
+	least, most := -1, -1
+	for serverName, shardIDs := range status.Servers {
+		if least == -1 || len(shardIDs) < least {
+			least = len(shardIDs)
+		}
+		most = max(most, len(shardIDs))
+		held := map[string]bool{}
+		for _, shardID := range shardIDs {
+			if held[shardID] {
+				t.Errorf("%s holds %s twice", serverName, shardID)
+			}
+			held[shardID] = true
+			counts[shardID]++
+		}
+	}
+	if most-least > 1 {
+		t.Errorf("servers hold between %d and %d shards: %v", least, most, status.Servers)
+	}
+	for _, shard := range status.Shards {
+		if counts[shard.ShardID] != wantReplicas {
+			t.Errorf("got %d replicas of %s, want %d", counts[shard.ShardID], shard.ShardID, wantReplicas)
+		}
+	}
+}
+
+func TestPlannedInitAndAdd(t *testing.T) {
+	c := newTestCluster(t)
+	req := testInitRequest()
+	req.Servers = nil
+	req.Replicas = 2
+	c.mustDo("POST", "/init", req, nil)
+	assertBalanced(t, c, 3, 2)
+	for i := 1; i <= 3; i++ {
+		if _, err := c.orch.Address(fmt.Sprintf("Server%d", i)); err != nil {
+			t.Errorf("Server%d is not running: %v", i, err)
+		}
+	}
+
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	if status := c.do("POST", "/add", AddRequest{N: 1, Servers: map[string][]string{"Server[5]": {}}}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d adding Server[5], want 400", status)
+	}
+	if status := c.do("POST", "/add", AddRequest{N: 1, Servers: map[string][]string{"Server2": {}}}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d adding Server2 again, want 400", status)
+	}
+
+	// three empty servers and a new shard; the existing shards are spread
+	// onto them
+	c.mustDo("POST", "/add", AddRequest{
+		N:         3,
+		NewShards: []Shard{{StudIDLow: 300, ShardID: "sh4", ShardSize: 100}},
+		Rebalance: true,
+	}, nil)
+	assertBalanced(t, c, 6, 2)
+
+	newStudent := student(350, "Ishaan", 58)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{newStudent}}, nil)
+	want := append(append([]Row{}, testStudents...), newStudent)
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 399).Data, want)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/querylang"
+)
+
+// A query is pushed down to every shard that can hold matching rows, each
+// answering with at most a page of rows in order, and the pages are merged.
+// Cursors are the ones the servers use: the values of the sort columns, the
+// shard key last, of the last row of a page. The load balancer passes them
+// on to every shard as they are and builds the next one from the last row of
+// the merged page.
+
+func isColumn(column string) bool {
+	for _, c := range schemaConfig.Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// validateQuery checks the columns and operators of a query against the
+// schema. The servers check the values.
+func validateQuery(req QueryRequest) error {
+	if len(schemaConfig.Columns) == 0 {
+		return errors.New("database is not configured")
+	}
+	for _, column := range req.Columns {
+		if !isColumn(column) {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+	for _, p := range req.Where {
+		if !isColumn(p.Column) {
+			return fmt.Errorf("unknown column %q", p.Column)
+		}
+		if !querylang.IsOp(p.Op) {
+			return fmt.Errorf("unknown operator %q", p.Op)
+		}
+	}
+	for _, order := range req.OrderBy {
+		if !isColumn(order.Column) {
+			return fmt.Errorf("unknown column %q", order.Column)
+		}
+	}
+	if req.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+// sortColumns returns the columns rows are sorted by: the ones asked for and
+// the shard key, unless already among them.
+func sortColumns(orderBy []OrderBy) []OrderBy {
+	keyColumn := schemaConfig.Columns[0]
+	columns := []OrderBy{}
+	for _, order := range orderBy {
+		columns = append(columns, order)
+		if order.Column == keyColumn {
+			return columns
+		}
+	}
+	return append(columns, OrderBy{Column: keyColumn})
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// keyRange narrows the range of shard keys a query can match by its
+// predicates on the shard key, so only the shards in it are asked.
+func keyRange(where []Predicate) (int, int) {
+	keyColumn := schemaConfig.Columns[0]
+	low, high := math.MinInt, math.MaxInt
+	for _, p := range where {
+		if p.Column != keyColumn {
+			continue
+		}
+		if strings.ToUpper(p.Op) == "IN" {
+			values, ok := p.Value.([]interface{})
+			if !ok {
+				continue
+			}
+			inLow, inHigh := math.Inf(1), math.Inf(-1)
+			for _, value := range values {
+				f, ok := toFloat(value)
+				if !ok {
+					inLow, inHigh = math.Inf(-1), math.Inf(1)
+					break
+				}
+				inLow, inHigh = math.Min(inLow, f), math.Max(inHigh, f)
+			}
+			if !math.IsInf(inLow, -1) {
+				low = max(low, int(math.Ceil(inLow)))
+			}
+			if !math.IsInf(inHigh, 1) {
+				high = min(high, int(math.Floor(inHigh)))
+			}
+			continue
+		}
+
+		f, ok := toFloat(p.Value)
+		if !ok {
+			continue
+		}
+		switch p.Op {
+		case "=":
+			low, high = max(low, int(math.Ceil(f))), min(high, int(math.Floor(f)))
+		case ">":
+			low = max(low, int(math.Floor(f))+1)
+		case ">=":
+			low = max(low, int(math.Ceil(f)))
+		case "<":
+			high = min(high, int(math.Ceil(f))-1)
+		case "<=":
+			high = min(high, int(math.Floor(f)))
+		}
+	}
+	return low, high
+}
+
+// compareValues orders values as SQLite does: nulls first, then numbers,
+// then text.
+func compareValues(a interface{}, b interface{}) int {
+	rank := func(v interface{}) int {
+		if v == nil {
+			return 0
+		}
+		if _, ok := toFloat(v); ok {
+			return 1
+		}
+		return 2
+	}
+	if rankA, rankB := rank(a), rank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch rank(a) {
+	case 0:
+		return 0
+	case 2:
+		x, _ := a.(string)
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	}
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			i, errI := x.Int64()
+			j, errJ := y.Int64()
+			if errI == nil && errJ == nil {
+				return compareInts(i, j)
+			}
+		}
+	}
+	x, _ := toFloat(a)
+	y, _ := toFloat(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// mergePages merges the pages of the shards into one of at most limit rows,
+// 0 meaning all, and returns the cursor of the next page if there are rows
+// after it.
+func mergePages(responses []*ServerReadResponse, columns []OrderBy, limit int) ([]Row, string, error) {
+	rows := []Row{}
+	more := false
+	for _, respData := range responses {
+		if respData == nil {
+			continue
+		}
+		rows = append(rows, respData.Data...)
+		more = more || respData.NextCursor != ""
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, order := range columns {
+			c := compareValues(rows[i][order.Column], rows[j][order.Column])
+			if order.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	if limit == 0 || (len(rows) <= limit && !more) {
+		return rows, "", nil
+	}
+	rows = rows[:min(limit, len(rows))]
+	last := rows[len(rows)-1]
+	values := []interface{}{}
+	for _, order := range columns {
+		values = append(values, last[order.Column])
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, "", err
+	}
+	return rows, base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req QueryRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	settings, err := parseReadOptions(req.ReadOptions)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+	if err := validateQuery(req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid query: %v", err))
+		return
+	}
+
+	columns := sortColumns(req.OrderBy)
+	// the shards also return the sort columns, which the merge needs
+	shardColumns := []string{}
+	if len(req.Columns) > 0 {
+		shardColumns = append(shardColumns, req.Columns...)
+		for _, order := range columns {
+			shardColumns = append(shardColumns, order.Column)
+		}
+	}
+
+	low, high := keyRange(req.Where)
+	shardsQueried := []Shard{}
+	if low <= high {
+		shardsQueried, err = getShardsInRange(low, high)
+		if err != nil {
+			writeError(w, r, errorFrom(err, "error finding shards in range"))
+			return
+		}
+	}
+	shardIDsQueried := []string{}
+	for _, shard := range shardsQueried {
+		shardIDsQueried = append(shardIDsQueried, shard.ShardID)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), settings.timeout)
+	defer cancel()
+	responses, errs := scatter(ctx, shardsQueried, settings.parallelism, func(ctx context.Context, shard Shard) (*ServerReadResponse, error) {
+		payload := ServerQueryPayload{
+			Shard:   shard.ShardID,
+			Columns: shardColumns,
+			Where:   shardRangeWhere(shard, req.Where),
+			OrderBy: req.OrderBy,
+			Limit:   req.Limit,
+			Cursor:  req.Cursor,
+		}
+		return readShard(ctx, shard.ShardID, "/query", payload, settings.level, settings.hedgeAfter)
+	})
+	for shardID, err := range errs {
+		if isBadRequest(err) {
+			writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid query: %v", err).WithShard(shardID))
+			return
+		}
+	}
+	if len(errs) > 0 {
+		for shardID, err := range errs {
+			slog.WarnContext(r.Context(), "error querying shard", "shard", shardID, logging.Error(err))
+		}
+		writeError(w, r, shardsError(errs))
+		return
+	}
+
+	data, nextCursor, err := mergePages(responses, columns, req.Limit)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.Internal, "Error merging pages: %v", err))
+		return
+	}
+	if len(req.Columns) > 0 {
+		wanted := map[string]bool{}
+		for _, column := range req.Columns {
+			wanted[column] = true
+		}
+		for _, row := range data {
+			for column := range row {
+				if !wanted[column] {
+					delete(row, column)
+				}
+			}
+		}
+	}
+
+	response := QueryResponse{
+		ShardsQueried: shardIDsQueried,
+		Data:          data,
+		NextCursor:    nextCursor,
+		Status:        "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
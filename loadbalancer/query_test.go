@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	// testStudents by marks: Aarav 81, Diya 64, Kabir 92, Meera 47, Rohan 75
+	var resp QueryResponse
+	c.mustDo("POST", "/query", QueryRequest{
+		Columns: []string{"Stud_name"},
+		Where:   []Predicate{{Column: "Stud_marks", Op: ">", Value: 70}},
+		OrderBy: []OrderBy{{Column: "Stud_marks", Desc: true}},
+	}, &resp)
+	assertRows(t, resp.Data, []Row{{"Stud_name": "Kabir"}, {"Stud_name": "Aarav"}, {"Stud_name": "Rohan"}})
+	if len(resp.ShardsQueried) != 3 || resp.NextCursor != "" {
+		t.Errorf("got shards %v and cursor %q, want all 3 shards and no cursor", resp.ShardsQueried, resp.NextCursor)
+	}
+
+	// predicates on the shard key only reach the shards that can match
+	c.mustDo("POST", "/query", QueryRequest{
+		Where: []Predicate{{Column: "Stud_id", Op: ">=", Value: 100}, {Column: "Stud_id", Op: "<", Value: 200}},
+	}, &resp)
+	assertRows(t, resp.Data, testStudents[2:3])
+	if len(resp.ShardsQueried) != 1 || resp.ShardsQueried[0] != "sh2" {
+		t.Errorf("got shards %v, want [sh2]", resp.ShardsQueried)
+	}
+
+	// pages of two by marks going up, across shards
+	want := []Row{testStudents[3], testStudents[1], testStudents[4], testStudents[0], testStudents[2]}
+	req := QueryRequest{OrderBy: []OrderBy{{Column: "Stud_marks"}}, Limit: 2}
+	got := []Row{}
+	for page := 0; ; page++ {
+		if page == 3 {
+			t.Fatal("more than 3 pages of 2 rows for 5 rows")
+		}
+		resp = QueryResponse{}
+		c.mustDo("POST", "/query", req, &resp)
+		got = append(got, resp.Data...)
+		if resp.NextCursor == "" {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	assertRows(t, got, want)
+
+	for _, bad := range []QueryRequest{
+		{Columns: []string{"age"}},
+		{Where: []Predicate{{Column: "Stud_marks", Op: "~", Value: 1}}},
+		{Where: []Predicate{{Column: "Stud_marks", Op: ">", Value: "many"}}},
+		{Cursor: "not a cursor"},
+		{Limit: -1},
+	} {
+		if status := c.do("POST", "/query", bad, nil); status != http.StatusBadRequest {
+			t.Errorf("query %+v: got status %d, want 400", bad, status)
+		}
+	}
+}
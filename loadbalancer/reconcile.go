@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+)
+
+// Every shard has a replication factor in shardt. A background reconciler
+// gives shards that have fewer replicas new ones, and /rm first moves the
+// replicas on the servers it removes to the remaining ones where a shard
+// would otherwise fall below its replication factor.
+
+func getShardIDs() ([]string, error) {
+	rows, err := db.Query("SELECT shard_id FROM shardt ORDER BY shard_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shardIDs := []string{}
+	for rows.Next() {
+		var shardID string
+		if err := rows.Scan(&shardID); err != nil {
+			return nil, err
+		}
+		shardIDs = append(shardIDs, shardID)
+	}
+	return shardIDs, rows.Err()
+}
+
+// chooseServerForReplica returns the live server holding the fewest replicas
+// that does not hold the shard and is not excluded, ties going to the lowest
+// id, or -1 if there is none.
+func chooseServerForReplica(shardID string, excluded map[int]bool) (int, error) {
+	shardServerIDs, err := getServerIDsForShard(db, shardID)
+	if err != nil {
+		return -1, err
+	}
+	holders := map[int]bool{}
+	for _, serverID := range shardServerIDs {
+		holders[serverID] = true
+	}
+
+	load, err := getServerLoad()
+	if err != nil {
+		return -1, err
+	}
+	chosen := -1
+	for serverID, count := range load {
+		if holders[serverID] || excluded[serverID] {
+			continue
+		}
+		if chosen == -1 || count < load[chosen] || (count == load[chosen] && serverID < chosen) {
+			chosen = serverID
+		}
+	}
+	return chosen, nil
+}
+
+// reconcileReplicas gives every shard with fewer replicas than its
+// replication factor new ones, and returns how many it created. A shard it
+// fails on is logged and left for the next round.
+func reconcileReplicas() int {
+	shardIDs, err := getShardIDs()
+	if err != nil {
+		slog.Error("error listing shards to reconcile", logging.Error(err))
+		return 0
+	}
+
+	created := 0
+	for _, shardID := range shardIDs {
+		if _, ok := getShardTConfig(shardID); !ok {
+			continue
+		}
+		n, err := reconcileShard(shardID)
+		created += n
+		if err != nil {
+			slog.Warn("error adding replica", "shard", shardID, logging.Error(err))
+		}
+	}
+	return created
+}
+
+// reconcileShard adds replicas of a shard until it has as many as its
+// replication factor or no server is left for another, and returns how many
+// it added.
+func reconcileShard(shardID string) (int, error) {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+
+	replicas, err := getShardReplicationFactor(shardID)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for {
+		shardServerIDs, err := getServerIDsForShard(db, shardID)
+		if err != nil || len(shardServerIDs) >= replicas {
+			return created, err
+		}
+		serverID, err := chooseServerForReplica(shardID, nil)
+		if err != nil {
+			return created, err
+		}
+		if serverID == -1 {
+			slog.Warn("no server left for another replica", "shard", shardID)
+			return created, nil
+		}
+		if err := addReplica(shardID, serverID); err != nil {
+			return created, err
+		}
+		created++
+	}
+}
+
+// monitorReplication reconciles the replicas of every shard each
+// RECONCILE_INTERVAL until stop is closed.
+func monitorReplication(stop <-chan struct{}) {
+	ticker := time.NewTicker(RECONCILE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if created := reconcileReplicas(); created > 0 {
+			slog.Info("created replicas of under-replicated shards", "replicas", created)
+		}
+	}
+}
+
+// rehomeReplicas moves replicas off the servers about to be removed wherever
+// removing them would leave a shard with fewer replicas than its replication
+// factor. If the remaining servers cannot make up for them it moves nothing
+// and returns the status to refuse the removal with.
+func rehomeReplicas(serverIDsRemoved []int) (int, error) {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+
+	removed := map[int]bool{}
+	for _, serverID := range serverIDsRemoved {
+		removed[serverID] = true
+	}
+
+	type move struct {
+		shardID      string
+		fromServerID int
+	}
+	moves := []move{}
+	shardIDs, err := getShardIDs()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, shardID := range shardIDs {
+		shardServerIDs, err := getServerIDsForShard(db, shardID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		holders := []int{}
+		kept := 0
+		for _, serverID := range shardServerIDs {
+			if removed[serverID] {
+				holders = append(holders, serverID)
+			} else {
+				kept++
+			}
+		}
+		if len(holders) == 0 {
+			continue
+		}
+		sort.Ints(holders)
+
+		runningServerIDs := getServerIDs()
+		free := len(runningServerIDs) - kept
+		for _, serverID := range runningServerIDs {
+			if removed[serverID] {
+				free--
+			}
+		}
+		replicas, err := getShardReplicationFactor(shardID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		needed := min(replicas-kept, len(holders))
+		if needed > free {
+			return http.StatusBadRequest, fmt.Errorf("removing them would leave %s with %d of its %d replicas", shardID, kept+free, replicas)
+		}
+		for _, serverID := range holders[:max(needed, 0)] {
+			moves = append(moves, move{shardID: shardID, fromServerID: serverID})
+		}
+	}
+
+	for _, m := range moves {
+		serverID, err := chooseServerForReplica(m.shardID, removed)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if serverID == -1 {
+			return http.StatusInternalServerError, fmt.Errorf("no server left for %s", m.shardID)
+		}
+		if err := migrateReplica(m.shardID, m.fromServerID, serverID); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+func TestReconcileReplicas(t *testing.T) {
+	c := newTestCluster(t)
+	req := testInitRequest()
+	req.Servers = nil
+	req.Replicas = 2
+	req.Shards[0].Replicas = 3
+	req.N = 4
+	c.mustDo("POST", "/init", req, nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	if created := reconcileReplicas(); created != 0 {
+		t.Errorf("created %d replicas of shards at their replication factor", created)
+	}
+	held := c.replicas("sh2")[0]
+	if _, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES ('sh2', ?);", held); err == nil {
+		t.Error("mapt took a second row for a replica")
+	}
+
+	// sh2 loses a replica behind the load balancer's back
+	lost := c.replicas("sh2")[0]
+	if _, err := db.Exec("DELETE FROM mapt WHERE shard_id = 'sh2' AND server_id = ?;", lost); err != nil {
+		t.Fatal(err)
+	}
+	removeFromShardHashMap("sh2", lost)
+
+	if created := reconcileReplicas(); created != 1 {
+		t.Fatalf("created %d replicas, want 1", created)
+	}
+	for shardID, want := range map[string]int{"sh1": 3, "sh2": 2, "sh3": 2} {
+		if got := len(c.replicas(shardID)); got != want {
+			t.Errorf("%s has %d replicas, want %d", shardID, got, want)
+		}
+	}
+	for _, serverID := range c.replicas("sh2") {
+		assertRows(t, c.shardData(serverID, "sh2"), testStudents[2:3])
+	}
+	assertRows(t, c.read(0, 299).Data, testStudents)
+}
+
+func TestRemoveKeepsReplicationFactor(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	// sh1 and sh3 move their replicas on Server3 to the other servers
+	c.mustDo("DELETE", "/rm", RemoveRequest{N: 1, Servers: []string{"Server3"}}, nil)
+	for _, shardID := range []string{"sh1", "sh2", "sh3"} {
+		if got := c.replicas(shardID); len(got) != 2 {
+			t.Errorf("%s is on servers %v, want two", shardID, got)
+		}
+	}
+	assertRows(t, c.shardData(2, "sh1"), testStudents[:2])
+	assertRows(t, c.shardData(1, "sh3"), testStudents[3:])
+
+	// with two servers left, losing either would leave every shard one short
+	var failed apierror.Error
+	if status := c.do("DELETE", "/rm", RemoveRequest{N: 1, Servers: []string{"Server1"}}, &failed); status != http.StatusBadRequest || failed.Code != apierror.BadRequest {
+		t.Errorf("got status %d and code %q removing a server every shard needs, want 400 and bad_request", status, failed.Code)
+	}
+	if _, err := c.orch.Address("Server1"); err != nil {
+		t.Error("Server1 was stopped by a refused removal")
+	}
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 299).Data, testStudents)
+	}
+}
+
+func TestMonitorReplicationStops(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		monitorReplication(stop)
+		close(done)
+	}()
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("monitorReplication still running after stop was closed")
+	}
+}
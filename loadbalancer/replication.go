@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+const LOG_BATCH_SIZE = 500
+
+// REPLICATION_TIMEOUT is how long a server may take to answer a request for
+// its log, a replay or a promotion. These are sent under the shard mutex, so
+// a server that hangs must not hold up the shard for longer.
+const REPLICATION_TIMEOUT = 10 * time.Second
+
+// getServerIndex returns the index a server's copy of a shard is at, the
+// sequence number of the last entry in its log.
+func getServerIndex(ctx context.Context, serverID int, shardID string) (int, error) {
+	respData, err := fetchLog(ctx, serverID, shardID, math.MaxInt32, 1)
+	if err != nil {
+		return 0, err
+	}
+	return respData.CurrentIndex, nil
+}
+
+func fetchLog(ctx context.Context, serverID int, shardID string, after int, limit int) (*ServerLogResponse, error) {
+	payloadData, err := json.Marshal(ServerLogPayload{
+		Shard: shardID,
+		After: after,
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, REPLICATION_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", getServerURL(serverID, "/log"), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading log of %s from Server%d: status %d", shardID, serverID, resp.StatusCode)
+	}
+
+	var respData ServerLogResponse
+	if err := decodeJSON(resp.Body, &respData); err != nil {
+		return nil, err
+	}
+	return &respData, nil
+}
+
+func replayLog(ctx context.Context, serverID int, shardID string, term int, entries []LogEntry) (int, error) {
+	payloadData, err := json.Marshal(ServerReplayPayload{
+		Shard:   shardID,
+		Term:    term,
+		Entries: entries,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, REPLICATION_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", getServerURL(serverID, "/replay"), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("replaying log of %s on Server%d: status %d", shardID, serverID, resp.StatusCode)
+	}
+
+	var respData ServerWriteResponse
+	if err := decodeJSON(resp.Body, &respData); err != nil {
+		return 0, err
+	}
+	return respData.CurrentIndex, nil
+}
+
+// catchUpReplica replays on target the entries of source's log for a shard
+// that target does not have yet, LOG_BATCH_SIZE at a time, and returns the
+// index target ends up at. It only copies what source had when it started, so
+// callers that need target fully caught up must hold the shard mutex.
+func catchUpReplica(ctx context.Context, shardID string, sourceServerID int, targetServerID int) (int, error) {
+	idx, err := getServerIndex(ctx, targetServerID, shardID)
+	if err != nil {
+		return 0, err
+	}
+	sourceIdx, err := getServerIndex(ctx, sourceServerID, shardID)
+	if err != nil {
+		return 0, err
+	}
+	_, term, err := getShardPrimary(db, shardID)
+	if err != nil {
+		return idx, err
+	}
+
+	for idx < sourceIdx {
+		respData, err := fetchLog(ctx, sourceServerID, shardID, idx, LOG_BATCH_SIZE)
+		if err != nil {
+			return idx, err
+		}
+		if len(respData.Entries) == 0 {
+			return idx, fmt.Errorf("log of %s on Server%d ends at %d, expected %d", shardID, sourceServerID, idx, sourceIdx)
+		}
+
+		idx, err = replayLog(ctx, targetServerID, shardID, term, respData.Entries)
+		if err != nil {
+			return idx, err
+		}
+	}
+
+	return idx, nil
+}
+
+// getReplicaAddresses returns the addresses of the replicas of a shard other
+// than its primary, the ones the primary passes changes on to.
+func getReplicaAddresses(shardID string, primaryServerID int) ([]string, error) {
+	shardServerIDs, err := getServerIDsForShard(db, shardID)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for _, serverID := range shardServerIDs {
+		if serverID == primaryServerID {
+			continue
+		}
+		if address := getServerAddress(fmt.Sprintf("Server%d", serverID)); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// electPrimary makes the most up to date live replica of a shard its primary
+// for a new term, ties going to the lowest server id, and moves valid_idx to
+// the index the new primary is at. Servers in excluded are not considered.
+// Callers must hold the shard mutex.
+func electPrimary(ctx context.Context, shardID string, excluded ...int) error {
+	_, term, err := getShardPrimary(db, shardID)
+	if err != nil {
+		return err
+	}
+	shardServerIDs, err := getServerIDsForShard(db, shardID)
+	if err != nil {
+		return err
+	}
+
+	candidateID, candidateIdx := -1, -1
+	for _, serverID := range shardServerIDs {
+		isExcluded := false
+		for _, excludedID := range excluded {
+			if excludedID == serverID {
+				isExcluded = true
+				break
+			}
+		}
+		if isExcluded {
+			continue
+		}
+
+		idx, err := getServerIndex(ctx, serverID, shardID)
+		if err != nil {
+			slog.Warn("skipping server in election", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID), logging.Error(err))
+			continue
+		}
+		if idx > candidateIdx || (idx == candidateIdx && serverID < candidateID) {
+			candidateID, candidateIdx = serverID, idx
+		}
+	}
+	if candidateID == -1 {
+		return fmt.Errorf("no live replica of %s to elect", shardID)
+	}
+
+	replicas, err := getReplicaAddresses(shardID, candidateID)
+	if err != nil {
+		return err
+	}
+	payloadData, err := json.Marshal(ServerPromotePayload{
+		Shard:    shardID,
+		Term:     term + 1,
+		Replicas: replicas,
+	})
+	if err != nil {
+		return err
+	}
+	promoteCtx, cancel := context.WithTimeout(ctx, REPLICATION_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(promoteCtx, "POST", getServerURL(candidateID, "/promote"), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("promoting Server%d for %s: status %d", candidateID, shardID, resp.StatusCode)
+	}
+
+	var respData ServerWriteResponse
+	if err := decodeJSON(resp.Body, &respData); err != nil {
+		return err
+	}
+	for _, address := range respData.FailedReplicas {
+		slog.Warn("replica could not be brought up to date", "shard", shardID, "replica", address)
+	}
+
+	validIdx, err := getValidIDx(db, shardID)
+	if err != nil {
+		return err
+	}
+	if respData.CurrentIndex < validIdx {
+		slog.Error("entries were lost with the primary", "shard", shardID, "from", respData.CurrentIndex+1, "to", validIdx)
+	}
+	_, err = db.Exec("UPDATE shardt SET primary_server = ?, term = ?, valid_idx = ? WHERE shard_id = ?;", candidateID, term+1, respData.CurrentIndex, shardID)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("primary elected", "shard", shardID, "server", fmt.Sprintf("Server%d", candidateID), "term", term+1)
+	if err := resumeTransactions(shardID); err != nil {
+		slog.Warn("error resuming transactions", "shard", shardID, logging.Error(err))
+	}
+	return nil
+}
+
+// errNoPrimary is returned for changes to a shard that has no primary.
+var errNoPrimary = errors.New("shard has no primary")
+
+// serverError is a request a server answered with a status other than 200.
+type serverError struct {
+	serverID int
+	status   int
+	apiErr   *apierror.Error
+}
+
+// readServerError returns the error a server answered a request with.
+func readServerError(serverID int, resp *http.Response) *serverError {
+	return &serverError{serverID: serverID, status: resp.StatusCode, apiErr: apierror.Read(resp)}
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprintf("Server%d: %s: %s", e.serverID, e.apiErr.Code, e.apiErr.Message)
+}
+
+// sendToPrimary sends a change to the primary of a shard, which applies it
+// and replicates it to the other replicas.
+func sendToPrimary(ctx context.Context, method string, primaryServerID int, endpoint string, payload interface{}) (result *ServerWriteResponse, err error) {
+	if primaryServerID == -1 {
+		return nil, errNoPrimary
+	}
+	defer func() {
+		if err != nil {
+			serverErrors.Inc(fmt.Sprintf("Server%d", primaryServerID), endpoint)
+		}
+	}()
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, getServerURL(primaryServerID, endpoint), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readServerError(primaryServerID, resp)
+	}
+
+	var respData ServerWriteResponse
+	if err := decodeJSON(resp.Body, &respData); err != nil {
+		return nil, err
+	}
+	for _, address := range respData.FailedReplicas {
+		slog.WarnContext(ctx, "replica missed a change, the primary will resend it", "server", fmt.Sprintf("Server%d", primaryServerID), "replica", address)
+	}
+	return &respData, nil
+}
+
+// shardChange is the payload of a change to a shard, entries being how many
+// log entries the primary adds for it.
+type shardChange interface {
+	change() *ServerChangePayload
+	entries() int
+}
+
+// changeShard sends a change to the primary of a shard at the shard's
+// valid_idx and term, waiting for minAcks replicas, or all of them if it is 0,
+// and moves valid_idx on. Transactions still pending on the shard are
+// committed first, and the change is refused if they cannot be. Callers must
+// hold the shard mutex.
+func changeShard(ctx context.Context, shardID string, method string, endpoint string, payload shardChange, minAcks int) (_ *ServerWriteResponse, err error) {
+	ctx, span := tracing.Start(ctx, strings.TrimPrefix(endpoint, "/")+" shard", tracing.SpanKindInternal)
+	span.SetAttribute("galaxydb.shard", shardID)
+	span.SetAttribute("galaxydb.entries", payload.entries())
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	if err := resumeTransactions(shardID); err != nil {
+		return nil, fmt.Errorf("%s has transactions still to commit: %w", shardID, err)
+	}
+	currentIndex, err := getValidIDx(db, shardID)
+	if err != nil {
+		return nil, err
+	}
+	primaryServerID, term, err := getShardPrimary(db, shardID)
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := getReplicaAddresses(shardID, primaryServerID)
+	if err != nil {
+		return nil, err
+	}
+	*payload.change() = ServerChangePayload{
+		Shard:        shardID,
+		CurrentIndex: currentIndex,
+		Term:         term,
+		Replicas:     replicas,
+		MinAcks:      minAcks,
+	}
+
+	respData, err := sendToPrimary(ctx, method, primaryServerID, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	if respData.CurrentIndex != currentIndex+payload.entries() {
+		return nil, fmt.Errorf("invalid index %d", respData.CurrentIndex)
+	}
+
+	_, err = db.Exec("UPDATE shardt SET valid_idx = ? WHERE shard_id = ?;", respData.CurrentIndex, shardID)
+	if err != nil {
+		return nil, err
+	}
+	return respData, nil
+}
+
+// writeToShard writes rows to a shard through its primary. Callers must hold
+// the shard mutex.
+func writeToShard(ctx context.Context, shardID string, rows []Row, minAcks int) (*ServerWriteResponse, error) {
+	return changeShard(ctx, shardID, "POST", "/write", &ServerWritePayload{Data: rows}, minAcks)
+}
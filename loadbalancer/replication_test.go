@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrimaryFailover(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	// with all replicas empty the lowest server id wins
+	for shardID, want := range map[string]int{"sh1": 1, "sh2": 1, "sh3": 2} {
+		if primaryServerID, term := c.primary(shardID); primaryServerID != want || term != 1 {
+			t.Errorf("%s: got primary Server%d for term %d, want Server%d for term 1", shardID, primaryServerID, term, want)
+		}
+	}
+
+	// writes go through the primary, which replicates them
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	assertRows(t, c.shardData(3, "sh1"), testStudents[:2])
+	assertRows(t, c.shardData(2, "sh2"), testStudents[2:3])
+
+	if err := c.orch.Stop("Server1"); err != nil {
+		t.Fatal(err)
+	}
+	replaceServerInstance(1)
+
+	for shardID, want := range map[string]int{"sh1": 3, "sh2": 2} {
+		if primaryServerID, term := c.primary(shardID); primaryServerID != want || term != 2 {
+			t.Errorf("%s: got primary Server%d for term %d after failover, want Server%d for term 2", shardID, primaryServerID, term, want)
+		}
+	}
+	if primaryServerID, term := c.primary("sh3"); primaryServerID != 2 || term != 1 {
+		t.Errorf("sh3: got primary Server%d for term %d, want it unchanged", primaryServerID, term)
+	}
+
+	// the new primary takes writes and passes them on to the replacement
+	c.mustDo("PUT", "/update", UpdateRequest{StudID: 10, Data: Row{"Stud_marks": 99}}, nil)
+	updated := []Row{student(10, "Aarav", 99), testStudents[1]}
+	for _, serverID := range c.replicas("sh1") {
+		assertRows(t, c.shardData(serverID, "sh1"), updated)
+	}
+
+	// a change tagged with the term of the old primary is refused
+	_, err := sendToPrimary(context.Background(), "DELETE", 3, "/delete", ServerDeletePayload{
+		ServerChangePayload: ServerChangePayload{
+			Shard:        "sh1",
+			CurrentIndex: c.validIdx("sh1"),
+			Term:         1,
+		},
+		StudID: 10,
+	})
+	if err == nil {
+		t.Fatal("a change with a stale term was accepted")
+	}
+	assertRows(t, c.shardData(3, "sh1"), updated)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+)
+
+// Shards can be split at a key and adjacent shards merged while the cluster
+// keeps serving. Rows are moved by reading them from the primary of the shard
+// that owns them and writing them through the primary of the shard that will,
+// which keeps every replica's log in step. Until the ranges in shardt are
+// switched over the moved rows sit outside the range of their new shard, and
+// reads only ask each shard for the part of the range it owns, so nothing is
+// read twice. Writes to the shards involved wait until the move is done.
+
+func getShard(shardID string) (Shard, bool, error) {
+	var shard Shard
+	err := db.QueryRow("SELECT stud_id_low, shard_id, shard_size FROM shardt WHERE shard_id = ?;", shardID).Scan(&shard.StudIDLow, &shard.ShardID, &shard.ShardSize)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shard, false, nil
+	}
+	return shard, err == nil, err
+}
+
+// readShardRange reads the rows of a shard with keys from low up to, but not
+// including, high from its primary. Callers must hold the shard mutex.
+func readShardRange(ctx context.Context, shardID string, low int, high int) ([]Row, error) {
+	primaryServerID, _, err := getShardPrimary(db, shardID)
+	if err != nil {
+		return nil, err
+	}
+	if primaryServerID == -1 {
+		return nil, fmt.Errorf("%s has no primary", shardID)
+	}
+
+	payload := ServerReadPayload{Shard: shardID}
+	payload.StudID.Low = low
+	payload.StudID.High = high - 1
+	respData, err := readFromServer(ctx, primaryServerID, "/read", payload)
+	if err != nil {
+		return nil, err
+	}
+	return respData.Data, nil
+}
+
+// deleteShardRange deletes the rows of a shard with keys from low up to, but
+// not including, high on all of its replicas. Callers must hold the shard
+// mutex.
+func deleteShardRange(ctx context.Context, shardID string, low int, high int) error {
+	_, err := changeShard(ctx, shardID, "DELETE", "/delete", &ServerDeletePayload{StudID: low, High: &high}, 0)
+	return err
+}
+
+// dropShard removes a shard from servers that no longer hold it. A server
+// that cannot be reached keeps its copy, which only wastes space.
+func dropShard(ctx context.Context, shardID string, serverIDs []int) {
+	payloadData, err := json.Marshal(ServerDropPayload{Shards: []string{shardID}})
+	if err != nil {
+		slog.Error("error dropping shard", "shard", shardID, logging.Error(err))
+		return
+	}
+
+	for _, serverID := range serverIDs {
+		if err := dropFromServer(ctx, serverID, payloadData); err != nil {
+			slog.WarnContext(ctx, "error dropping shard", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID), logging.Error(err))
+		}
+	}
+}
+
+func dropFromServer(ctx context.Context, serverID int, payloadData []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, SERVER_CONFIG_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", getServerURL(serverID, "/drop"), bytes.NewBuffer(payloadData))
+	if err != nil {
+		return err
+	}
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readServerError(serverID, resp)
+	}
+	return nil
+}
+
+// discardShard forgets a shard that was being created and removes it from
+// its servers.
+func discardShard(ctx context.Context, shardID string, serverIDs []int) {
+	_, err := db.Exec("DELETE FROM shardt WHERE shard_id = ?;", shardID)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM mapt WHERE shard_id = ?;", shardID)
+	}
+	if err != nil {
+		slog.Error("error discarding shard", "shard", shardID, logging.Error(err))
+	}
+	retireShardTConfig(shardID)
+	dropShard(ctx, shardID, serverIDs)
+}
+
+// splitShard moves the rows of a shard from key at on into a new shard placed
+// on serverIDs.
+func splitShard(ctx context.Context, shardID string, at int, newShardID string, serverIDs []int) (int, error) {
+	// the range is checked under the shard mutex so no other split or merge
+	// changes it in between
+	unlock, ok := lockShard(shardID)
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("no shard %s", shardID)
+	}
+	defer unlock()
+
+	shard, ok, err := getShard(shardID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("no shard %s", shardID)
+	}
+	_, ok, err = getShard(newShardID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if ok || newShardID == "" {
+		return http.StatusBadRequest, fmt.Errorf("invalid new shard id %q", newShardID)
+	}
+	high := shard.StudIDLow + shard.ShardSize
+	if at <= shard.StudIDLow || at >= high {
+		return http.StatusBadRequest, fmt.Errorf("%s holds Stud_id %d to %d, cannot split it at %d", shardID, shard.StudIDLow, high-1, at)
+	}
+
+	if err := resumeTransactions(shardID); err != nil {
+		return http.StatusConflict, fmt.Errorf("%s has transactions still to commit: %v", shardID, err)
+	}
+
+	// the new shard starts out empty, so no request is routed to it
+	if err := createEmptyShard(ctx, shardID, at, newShardID, serverIDs); err != nil {
+		discardShard(ctx, newShardID, serverIDs)
+		return http.StatusInternalServerError, fmt.Errorf("creating %s: %v", newShardID, err)
+	}
+
+	unlockNew, ok := lockShard(newShardID)
+	if !ok {
+		discardShard(ctx, newShardID, serverIDs)
+		return http.StatusConflict, fmt.Errorf("%s was removed while being created", newShardID)
+	}
+	defer unlockNew()
+
+	if err := electPrimary(ctx, newShardID); err != nil {
+		discardShard(ctx, newShardID, serverIDs)
+		return http.StatusInternalServerError, err
+	}
+	rows, err := readShardRange(ctx, shardID, at, high)
+	if err == nil && len(rows) > 0 {
+		_, err = writeToShard(ctx, newShardID, rows, 0)
+	}
+	if err != nil {
+		discardShard(ctx, newShardID, serverIDs)
+		return http.StatusInternalServerError, fmt.Errorf("moving rows to %s: %v", newShardID, err)
+	}
+
+	tx, err := db.Begin()
+	if err == nil {
+		_, err = tx.Exec("UPDATE shardt SET shard_size = ? WHERE shard_id = ?;", at-shard.StudIDLow, shardID)
+		if err == nil {
+			_, err = tx.Exec("UPDATE shardt SET shard_size = ? WHERE shard_id = ?;", high-at, newShardID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		// the old shard still owns the rows
+		discardShard(ctx, newShardID, serverIDs)
+		return http.StatusInternalServerError, fmt.Errorf("switching ranges to %s: %v", newShardID, err)
+	}
+
+	// the moved rows are no longer read from the old shard, so failing to
+	// delete them only wastes space
+	if err := deleteShardRange(ctx, shardID, at, high); err != nil {
+		slog.Warn("error deleting moved rows", "shard", shardID, logging.Error(err))
+	}
+
+	slog.Info("shard split", "shard", shardID, "at", at, "new_shard", newShardID, "rows", len(rows))
+	return http.StatusOK, nil
+}
+
+// createEmptyShard adds a shard with no keys to serverIDs, starting at key at
+// and with the selector of shardID.
+func createEmptyShard(ctx context.Context, shardID string, at int, newShardID string, serverIDs []int) error {
+	for _, serverID := range serverIDs {
+		if err := configNewServerInstance(ctx, serverID, []string{newShardID}, schemaConfig); err != nil {
+			return err
+		}
+		_, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", newShardID, serverID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec("INSERT INTO shardt (stud_id_low, shard_id, shard_size, valid_idx, primary_server, term, replicas, selector) SELECT ?, ?, ?, ?, ?, ?, ?, selector FROM shardt WHERE shard_id = ?;", at, newShardID, 0, 0, -1, 0, len(serverIDs), shardID)
+	if err != nil {
+		return err
+	}
+	return loadShardTConfig(newShardID)
+}
+
+// mergeShards moves the rows of a shard into the shard right before it and
+// removes it.
+func mergeShards(ctx context.Context, lowerShardID string, upperShardID string) (int, error) {
+	// the ranges are checked under the shard mutexes so no other split or
+	// merge changes them in between
+	shardIDs := []string{lowerShardID, upperShardID}
+	sort.Strings(shardIDs)
+	if shardIDs[0] == shardIDs[1] {
+		return http.StatusBadRequest, fmt.Errorf("cannot merge %s with itself", lowerShardID)
+	}
+	for _, shardID := range shardIDs {
+		unlock, ok := lockShard(shardID)
+		if !ok {
+			return http.StatusNotFound, fmt.Errorf("no shard %s", shardID)
+		}
+		defer unlock()
+	}
+
+	lower, ok, err := getShard(lowerShardID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("no shard %s", lowerShardID)
+	}
+	upper, ok, err := getShard(upperShardID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("no shard %s", upperShardID)
+	}
+	if lower.StudIDLow > upper.StudIDLow {
+		lower, upper = upper, lower
+	}
+	if lower.StudIDLow+lower.ShardSize != upper.StudIDLow {
+		return http.StatusBadRequest, fmt.Errorf("%s and %s are not adjacent", lower.ShardID, upper.ShardID)
+	}
+
+	for _, shardID := range shardIDs {
+		if err := resumeTransactions(shardID); err != nil {
+			return http.StatusConflict, fmt.Errorf("%s has transactions still to commit: %v", shardID, err)
+		}
+	}
+
+	high := upper.StudIDLow + upper.ShardSize
+	rows, err := readShardRange(ctx, upper.ShardID, upper.StudIDLow, high)
+	if err == nil && len(rows) > 0 {
+		_, err = writeToShard(ctx, lower.ShardID, rows, 0)
+	}
+	var upperServerIDs []int
+	if err == nil {
+		upperServerIDs, err = getServerIDsForShard(db, upper.ShardID)
+	}
+	if err == nil {
+		err = switchMergedRange(lower, upper)
+	}
+	if err != nil {
+		// rows already written to the lower shard lie outside its range, and
+		// are deleted so a later merge can write them again
+		if deleteErr := deleteShardRange(ctx, lower.ShardID, upper.StudIDLow, high); deleteErr != nil {
+			slog.Warn("error deleting moved rows", "shard", lower.ShardID, logging.Error(deleteErr))
+		}
+		return http.StatusInternalServerError, fmt.Errorf("moving rows to %s: %v", lower.ShardID, err)
+	}
+
+	dropShard(ctx, upper.ShardID, upperServerIDs)
+	retireShardTConfig(upper.ShardID)
+
+	slog.Info("shards merged", "shard", lower.ShardID, "merged_shard", upper.ShardID, "rows", len(rows))
+	return http.StatusOK, nil
+}
+
+// switchMergedRange hands the range of upper over to lower and forgets
+// upper.
+func switchMergedRange(lower Shard, upper Shard) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE shardt SET shard_size = ? WHERE shard_id = ?;", lower.ShardSize+upper.ShardSize, lower.ShardID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM shardt WHERE shard_id = ?;", upper.ShardID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM mapt WHERE shard_id = ?;", upper.ShardID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func splitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	if _, ok := getShardTConfig(req.Shard); !ok {
+		writeError(w, r, apierror.Errorf(apierror.NotFound, "No shard %s", req.Shard).WithShard(req.Shard))
+		return
+	}
+
+	// the new shard goes on the servers of the old one unless told otherwise
+	serverIDsForShard, err := getServerIDsForShard(db, req.Shard)
+	if err != nil {
+		writeError(w, r, errorFrom(err, "error finding replicas").WithShard(req.Shard))
+		return
+	}
+	if len(req.Servers) > 0 {
+		serverIDsForShard = []int{}
+		for _, serverName := range req.Servers {
+			serverID, err := getExistingServerID(serverName)
+			if err != nil {
+				writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+				return
+			}
+			serverIDsForShard = append(serverIDsForShard, serverID)
+		}
+	}
+
+	if status, err := splitShard(context.WithoutCancel(r.Context()), req.Shard, req.At, req.NewShard, serverIDsForShard); err != nil {
+		slog.WarnContext(r.Context(), "error splitting shard", "shard", req.Shard, logging.Error(err))
+		writeError(w, r, statusError(status, err, "error splitting "+req.Shard).WithShard(req.Shard))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Split %s at Stud_id %d into %s", req.Shard, req.At, req.NewShard),
+		"status":  "success",
+	})
+}
+
+func mergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	if len(req.Shards) != 2 || req.Shards[0] == req.Shards[1] {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Exactly two different shards can be merged"))
+		return
+	}
+	for _, shardID := range req.Shards {
+		if _, ok := getShardTConfig(shardID); !ok {
+			writeError(w, r, apierror.Errorf(apierror.NotFound, "No shard %s", shardID).WithShard(shardID))
+			return
+		}
+	}
+
+	if status, err := mergeShards(context.WithoutCancel(r.Context()), req.Shards[0], req.Shards[1]); err != nil {
+		slog.WarnContext(r.Context(), "error merging shards", "shard", req.Shards[0], "merged_shard", req.Shards[1], logging.Error(err))
+		writeError(w, r, statusError(status, err, fmt.Sprintf("error merging %s and %s", req.Shards[0], req.Shards[1])))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Merged %s and %s", req.Shards[0], req.Shards[1]),
+		"status":  "success",
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSplitAndMerge(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	for _, req := range []SplitRequest{
+		{Shard: "sh1", At: 0, NewShard: "sh4"},
+		{Shard: "sh1", At: 100, NewShard: "sh4"},
+		{Shard: "sh1", At: 50, NewShard: "sh2"},
+		{Shard: "sh1", At: 50, NewShard: "sh4", Servers: []string{"Server9"}},
+	} {
+		if status := c.do("POST", "/split", req, nil); status != http.StatusBadRequest {
+			t.Errorf("split %+v: got status %d, want 400", req, status)
+		}
+	}
+
+	c.mustDo("POST", "/split", SplitRequest{Shard: "sh1", At: 15, NewShard: "sh4", Servers: []string{"Server1", "Server2"}}, nil)
+
+	var status struct {
+		Shards  []Shard             `json:"shards"`
+		Servers map[string][]string `json:"servers"`
+	}
+	c.mustDo("GET", "/status", nil, &status)
+	shardSizes := map[string]Shard{}
+	for _, shard := range status.Shards {
+		shardSizes[shard.ShardID] = shard
+	}
+	if got := shardSizes["sh1"]; got.StudIDLow != 0 || got.ShardSize != 15 {
+		t.Errorf("got sh1 %+v after split, want Stud_id 0 to 14", got)
+	}
+	if got := shardSizes["sh4"]; got.StudIDLow != 15 || got.ShardSize != 85 {
+		t.Errorf("got sh4 %+v after split, want Stud_id 15 to 99", got)
+	}
+
+	assertRows(t, c.shardData(1, "sh1"), testStudents[:1])
+	assertRows(t, c.shardData(3, "sh1"), testStudents[:1])
+	assertRows(t, c.shardData(1, "sh4"), testStudents[1:2])
+	assertRows(t, c.shardData(2, "sh4"), testStudents[1:2])
+	assertRows(t, c.read(0, 299).Data, testStudents)
+
+	// both halves take writes
+	newStudents := []Row{student(5, "Ishaan", 70), student(60, "Tara", 58)}
+	c.mustDo("POST", "/write", WriteRequest{Data: newStudents}, nil)
+	assertRows(t, c.shardData(1, "sh4"), []Row{testStudents[1], newStudents[1]})
+	want := []Row{newStudents[0], testStudents[0], testStudents[1], newStudents[1], testStudents[2], testStudents[3], testStudents[4]}
+	assertRows(t, c.read(0, 299).Data, want)
+
+	if status := c.do("POST", "/merge", MergeRequest{Shards: []string{"sh1", "sh3"}}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d merging shards that are not adjacent, want 400", status)
+	}
+
+	c.mustDo("POST", "/merge", MergeRequest{Shards: []string{"sh4", "sh1"}}, nil)
+	status.Shards = nil
+	status.Servers = nil
+	c.mustDo("GET", "/status", nil, &status)
+	for _, shard := range status.Shards {
+		if shard.ShardID == "sh4" {
+			t.Errorf("sh4 still listed after merge")
+		}
+		if shard.ShardID == "sh1" && (shard.StudIDLow != 0 || shard.ShardSize != 100) {
+			t.Errorf("got sh1 %+v after merge, want Stud_id 0 to 99", shard)
+		}
+	}
+	for serverName, shardIDs := range status.Servers {
+		for _, shardID := range shardIDs {
+			if shardID == "sh4" {
+				t.Errorf("%s still holds sh4 after merge", serverName)
+			}
+		}
+	}
+	if _, ok := getShardTConfig("sh4"); ok {
+		t.Error("sh4 still has a hash map after merge")
+	}
+
+	assertRows(t, c.shardData(3, "sh1"), want[:4])
+	assertRows(t, c.read(0, 299).Data, want)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{student(40, "Neel", 66)}}, nil)
+	assertRows(t, c.read(40, 40).Data, []Row{student(40, "Neel", 66)})
+}
+
+func TestWriteWaitingForMovedKey(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	// a write waits for sh1 while its upper half is handed over to sh2, as a
+	// split or merge would
+	unlock, ok := lockShard("sh1")
+	if !ok {
+		t.Fatal("sh1 has no config")
+	}
+	done := make(chan int)
+	go func() {
+		done <- c.do("POST", "/write", WriteRequest{Data: []Row{student(60, "Tara", 58)}}, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err := db.Exec("UPDATE shardt SET shard_size = 50 WHERE shard_id = 'sh1'; UPDATE shardt SET stud_id_low = 50, shard_size = 150 WHERE shard_id = 'sh2';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("got status %d writing, want 200", status)
+	}
+	for _, serverID := range c.replicas("sh2") {
+		assertRows(t, c.shardData(serverID, "sh2"), []Row{student(60, "Tara", 58)})
+	}
+	for _, serverID := range c.replicas("sh1") {
+		assertRows(t, c.shardData(serverID, "sh1"), []Row{})
+	}
+}
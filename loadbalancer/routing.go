@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The load balancer keeps, per server, how many reads it has in flight and a
+// moving average of how long they take. A read goes to the replica expected
+// to answer first, with the average latency times one more than the reads in
+// flight as the cost of each: the cheaper of two random replicas with
+// power-of-two-choices, the cheapest of all with least-loaded. Until every
+// replica of a shard has answered a read in the last LOAD_STATS_TTL the
+// shard's replica selector decides, as it always does with hash routing, so
+// a replica that was slow gets tried again once its average is stale.
+
+const (
+	ROUTING_HASH         = "hash"
+	ROUTING_P2C          = "p2c"
+	ROUTING_LEAST_LOADED = "least_loaded"
+
+	DEFAULT_READ_ROUTING = ROUTING_P2C
+)
+
+// LATENCY_EWMA_WEIGHT is the weight of the newest latency in the average.
+const LATENCY_EWMA_WEIGHT = 0.3
+
+// READ_ERROR_LATENCY is the latency a failed read counts as, so replicas that
+// fail fast do not attract reads.
+const READ_ERROR_LATENCY = time.Second
+
+// RETRY_PICKS is how many picks per replica the selector gets to find one not
+// tried yet before the rest are tried in order.
+const RETRY_PICKS = 4
+
+// LOAD_STATS_TTL is how long the averages of a server are trusted after its
+// last read.
+const LOAD_STATS_TTL = 10 * time.Second
+
+type serverLoad struct {
+	inFlight int
+	latency  float64 // EWMA in seconds, 0 until the first read finishes
+	updated  time.Time
+}
+
+var (
+	serverLoadsMutex sync.Mutex
+	serverLoads      = map[int]*serverLoad{}
+	readRouting      = DEFAULT_READ_ROUTING
+)
+
+func validateReadRouting(routing string) error {
+	switch routing {
+	case "", ROUTING_HASH, ROUTING_P2C, ROUTING_LEAST_LOADED:
+		return nil
+	default:
+		return fmt.Errorf("unknown read routing %q, expected %s, %s or %s", routing, ROUTING_HASH, ROUTING_P2C, ROUTING_LEAST_LOADED)
+	}
+}
+
+// trackRead counts a read to a server as in flight until the returned
+// function is called with its outcome.
+func trackRead(serverID int) func(err error) {
+	start := time.Now()
+	serverLoadsMutex.Lock()
+	load, ok := serverLoads[serverID]
+	if !ok {
+		load = &serverLoad{}
+		serverLoads[serverID] = load
+	}
+	load.inFlight++
+	serverLoadsMutex.Unlock()
+
+	return func(err error) {
+		latency := time.Since(start)
+		if err != nil {
+			latency = max(latency, READ_ERROR_LATENCY)
+		}
+
+		serverLoadsMutex.Lock()
+		defer serverLoadsMutex.Unlock()
+		load.inFlight--
+		// a read cancelled because another replica answered first says
+		// nothing about this one
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		load.updated = time.Now()
+		if load.latency == 0 {
+			load.latency = latency.Seconds()
+		} else {
+			load.latency = LATENCY_EWMA_WEIGHT*latency.Seconds() + (1-LATENCY_EWMA_WEIGHT)*load.latency
+		}
+	}
+}
+
+// readCosts returns the expected cost of a read on each server, or false if
+// one of them has not answered a read lately.
+func readCosts(serverIDs []int) (map[int]float64, bool) {
+	serverLoadsMutex.Lock()
+	defer serverLoadsMutex.Unlock()
+
+	costs := map[int]float64{}
+	for _, serverID := range serverIDs {
+		load, ok := serverLoads[serverID]
+		if !ok || load.latency == 0 || time.Since(load.updated) > LOAD_STATS_TTL {
+			return nil, false
+		}
+		costs[serverID] = load.latency * float64(load.inFlight+1)
+	}
+	return costs, true
+}
+
+func forgetServerLoad(serverID int) {
+	serverLoadsMutex.Lock()
+	defer serverLoadsMutex.Unlock()
+	delete(serverLoads, serverID)
+}
+
+// chooseReplica picks the replica of a shard a read goes to first, and
+// returns a function to call once the read is done.
+func chooseReplica(shardID string, serverIDs []int) (int, func()) {
+	costs, ok := readCosts(serverIDs)
+	if readRouting == ROUTING_HASH || !ok || len(serverIDs) < 2 {
+		config, ok := getShardTConfig(shardID)
+		if !ok {
+			return -1, func() {}
+		}
+		selector := config.chm
+		serverID := selector.GetServerForRequest(getRandomID())
+		return serverID, func() { selector.Done(serverID) }
+	}
+
+	candidates := serverIDs
+	if readRouting != ROUTING_LEAST_LOADED {
+		i := rand.Intn(len(serverIDs))
+		j := (i + 1 + rand.Intn(len(serverIDs)-1)) % len(serverIDs)
+		candidates = []int{serverIDs[i], serverIDs[j]}
+	}
+	chosen := candidates[0]
+	for _, serverID := range candidates[1:] {
+		if costs[serverID] < costs[chosen] {
+			chosen = serverID
+		}
+	}
+	return chosen, func() {}
+}
+
+// nextReplica picks another replica of a shard for a read, out of those not
+// tried yet. The shard's selector decides among them, and the replicas it
+// keeps picking that were tried are skipped.
+func nextReplica(shardID string, serverIDs []int, tried map[int]bool) (int, func()) {
+	config, ok := getShardTConfig(shardID)
+	if !ok {
+		return -1, func() {}
+	}
+	selector := config.chm
+	for i := 0; i < len(serverIDs)*RETRY_PICKS; i++ {
+		serverID := selector.GetServerForRequest(getRandomID())
+		if serverID != -1 && !tried[serverID] {
+			return serverID, func() { selector.Done(serverID) }
+		}
+		selector.Done(serverID)
+	}
+	for _, serverID := range serverIDs {
+		if !tried[serverID] {
+			return serverID, func() {}
+		}
+	}
+	return -1, func() {}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChooseReplica(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	// no stats yet, so the selector of sh1 decides
+	if serverID, done := chooseReplica("sh1", []int{1, 3}); serverID != 1 && serverID != 3 {
+		t.Fatalf("got Server%d for sh1, want Server1 or Server3", serverID)
+	} else {
+		done()
+	}
+
+	serverLoads[1] = &serverLoad{latency: 0.050, updated: time.Now()}
+	serverLoads[3] = &serverLoad{latency: 0.010, updated: time.Now()}
+	for _, routing := range []string{ROUTING_P2C, ROUTING_LEAST_LOADED} {
+		readRouting = routing
+		for i := 0; i < 20; i++ {
+			if serverID, _ := chooseReplica("sh1", []int{1, 3}); serverID != 3 {
+				t.Fatalf("%s: got Server%d, want the faster Server3", routing, serverID)
+			}
+		}
+	}
+
+	// enough reads in flight make the faster server the costlier one
+	serverLoads[3].inFlight = 9
+	if serverID, _ := chooseReplica("sh1", []int{1, 3}); serverID != 1 {
+		t.Errorf("got Server%d, want Server1 with Server3 busy", serverID)
+	}
+
+	serverLoads[1].updated = time.Now().Add(-2 * LOAD_STATS_TTL)
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		serverID, done := chooseReplica("sh1", []int{1, 3})
+		done()
+		seen[serverID] = true
+	}
+	if !seen[1] || !seen[3] {
+		t.Errorf("got servers %v with stale stats, want both from the selector", seen)
+	}
+}
+
+func TestReadsAvoidSlowReplica(t *testing.T) {
+	c := newTestCluster(t)
+	req := testInitRequest()
+	req.ReadRouting = "fastest"
+	if status := c.do("POST", "/init", req, nil); status != http.StatusBadRequest {
+		t.Fatalf("init with unknown read routing: got status %d, want 400", status)
+	}
+	req.ReadRouting = ROUTING_LEAST_LOADED
+	c.mustDo("POST", "/init", req, nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+
+	c.orch.setDelay("Server1", 20*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		assertRows(t, c.read(0, 99).Data, testStudents[:2])
+	}
+	c.orch.reads("Server1")
+	c.orch.reads("Server3")
+	for i := 0; i < 20; i++ {
+		assertRows(t, c.read(0, 99).Data, testStudents[:2])
+	}
+	if slow, fast := c.orch.reads("Server1"), c.orch.reads("Server3"); slow > 2 || fast < 18 {
+		t.Errorf("got %d reads on the slow Server1 and %d on Server3, want nearly all on Server3", slow, fast)
+	}
+
+	c.restartLoadBalancer()
+	if readRouting != ROUTING_LEAST_LOADED {
+		t.Errorf("got read routing %q after restart, want %q", readRouting, ROUTING_LEAST_LOADED)
+	}
+}
+
+func TestReadRetriesOtherReplica(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	readRouting = ROUTING_HASH
+
+	// sh1 is on Server1 and Server3
+	c.orch.setAvailable("Server1", false)
+	for i := 0; i < 10; i++ {
+		assertRows(t, c.read(0, 99).Data, testStudents[:2])
+	}
+	if c.orch.reads("Server3") != 10 {
+		t.Error("not every read reached Server3")
+	}
+
+	// the failed reads wake up the heartbeat check of Server1
+	select {
+	case serverID := <-serverDown:
+		if serverID != 1 {
+			t.Errorf("got Server%d reported down, want Server1", serverID)
+		}
+	case <-time.After(HEARTBEAT_INTERVAL / 2):
+		t.Error("Server1 was not reported down")
+	}
+}
+
+func TestHedgedRead(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	readRouting = ROUTING_HASH
+
+	c.orch.setDelay("Server1", 300*time.Millisecond)
+	var req ReadRequest
+	req.StudID.Low = 0
+	req.StudID.High = 99
+	req.HedgeAfterMs = 20
+	for i := 0; i < 10; i++ {
+		var resp ReadResponse
+		start := time.Now()
+		c.mustDo("POST", "/read", req, &resp)
+		if elapsed := time.Since(start); elapsed >= 250*time.Millisecond {
+			t.Errorf("hedged read took %v, want Server3 to answer first", elapsed)
+		}
+		assertRows(t, resp.Data, testStudents[:2])
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// A read over a range of keys is scattered to every shard the range touches
+// at once, at most parallelism shards at a time, and the rows gathered back
+// in key order. Shards that fail or do not answer before the deadline are
+// reported by shard id next to the rows of the others.
+
+const (
+	DEFAULT_READ_PARALLELISM = 8
+	DEFAULT_READ_TIMEOUT     = 5 * time.Second
+)
+
+// readSettings are the ReadOptions of a read with the defaults filled in.
+type readSettings struct {
+	level       string
+	parallelism int
+	timeout     time.Duration
+	hedgeAfter  time.Duration
+}
+
+// shardRangeWhere returns where restricted to the keys a shard owns, as a
+// shard being split or merged may still hold rows it no longer owns.
+func shardRangeWhere(shard Shard, where []Predicate) []Predicate {
+	keyColumn := schemaConfig.Columns[0]
+	return append([]Predicate{
+		{Column: keyColumn, Op: ">=", Value: shard.StudIDLow},
+		{Column: keyColumn, Op: "<=", Value: shard.StudIDLow + shard.ShardSize - 1},
+	}, where...)
+}
+
+func parseReadOptions(options ReadOptions) (readSettings, error) {
+	level, err := parseConsistency(options.Consistency, DEFAULT_READ_CONSISTENCY)
+	if err != nil {
+		return readSettings{}, err
+	}
+	if options.Parallelism < 0 || options.TimeoutMs < 0 || options.HedgeAfterMs < 0 {
+		return readSettings{}, errors.New("parallelism, timeout_ms and hedge_after_ms must not be negative")
+	}
+
+	settings := readSettings{
+		level:       level,
+		parallelism: DEFAULT_READ_PARALLELISM,
+		timeout:     DEFAULT_READ_TIMEOUT,
+		hedgeAfter:  time.Duration(options.HedgeAfterMs) * time.Millisecond,
+	}
+	if options.Parallelism > 0 {
+		settings.parallelism = options.Parallelism
+	}
+	if options.TimeoutMs > 0 {
+		settings.timeout = time.Duration(options.TimeoutMs) * time.Millisecond
+	}
+	return settings, nil
+}
+
+// getShardsInRange returns the shards holding keys from low to high, in key
+// order.
+func getShardsInRange(low int, high int) ([]Shard, error) {
+	rows, err := db.Query("SELECT stud_id_low, shard_id, shard_size FROM shardt WHERE stud_id_low <= ? AND ? < stud_id_low+shard_size ORDER BY stud_id_low;", high, low)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shards := []Shard{}
+	for rows.Next() {
+		var shard Shard
+		if err := rows.Scan(&shard.StudIDLow, &shard.ShardID, &shard.ShardSize); err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shards, rows.Err()
+}
+
+// scatter runs read on every shard, at most parallelism shards at a time. It
+// returns the answers in the order of the shards, nil for those that failed,
+// and the error of each that did.
+func scatter(ctx context.Context, shards []Shard, parallelism int, read func(ctx context.Context, shard Shard) (*ServerReadResponse, error)) ([]*ServerReadResponse, map[string]error) {
+	responses := make([]*ServerReadResponse, len(shards))
+	errs := make([]error, len(shards))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard Shard) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			responses[i], errs[i] = read(ctx, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	shardErrs := map[string]error{}
+	for i, err := range errs {
+		if err != nil {
+			responses[i] = nil
+			shardErrs[shards[i].ShardID] = err
+		}
+	}
+	return responses, shardErrs
+}
+
+// shardsError is the error a read that failed on the shards in errs answers
+// with, giving the error of each.
+func shardsError(errs map[string]error) *apierror.Error {
+	shardIDs := []string{}
+	for shardID := range errs {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Strings(shardIDs)
+
+	e := apierror.Errorf(apierror.Unavailable, "Error reading %s", strings.Join(shardIDs, ", "))
+	if len(shardIDs) == 1 {
+		e.Shard = shardIDs[0]
+	}
+	e.Errors = map[string]string{}
+	for shardID, err := range errs {
+		e.Errors[shardID] = err.Error()
+	}
+	return e
+}
+
+// scatterRead reads the part of the range from low to high that each shard
+// owns, as settings say. It returns the rows of the shards that answered
+// sorted by key, and the error of each that did not.
+func scatterRead(ctx context.Context, shards []Shard, low int, high int, settings readSettings) ([]Row, map[string]error) {
+	responses, errs := scatter(ctx, shards, settings.parallelism, func(ctx context.Context, shard Shard) (*ServerReadResponse, error) {
+		// only ask for the part of the range the shard owns, a shard
+		// being split or merged may still hold rows it no longer owns
+		payload := ServerReadPayload{Shard: shard.ShardID}
+		payload.StudID.Low = max(low, shard.StudIDLow)
+		payload.StudID.High = min(high, shard.StudIDLow+shard.ShardSize-1)
+		return readShard(ctx, shard.ShardID, "/read", payload, settings.level, settings.hedgeAfter)
+	})
+
+	rows := []Row{}
+	for _, respData := range responses {
+		if respData != nil {
+			rows = append(rows, respData.Data...)
+		}
+	}
+	sortRowsByKey(rows)
+	return rows, errs
+}
+
+// sortRowsByKey sorts rows by their shard key. Rows read back from the servers
+// always have one.
+func sortRowsByKey(rows []Row) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, _ := getRowKey(rows[i])
+		b, _ := getRowKey(rows[j])
+		return a < b
+	})
+}
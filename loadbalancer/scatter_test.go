@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+func TestScatterGatherRead(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	// written out of order, read back in key order
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{testStudents[4], testStudents[2], testStudents[0], testStudents[3], testStudents[1]}}, nil)
+
+	resp := c.read(0, 299)
+	if len(resp.ShardsQueried) != 3 || resp.Errors != nil {
+		t.Fatalf("got shards %v and errors %v, want 3 shards without errors", resp.ShardsQueried, resp.Errors)
+	}
+	assertRows(t, resp.Data, testStudents)
+
+	var req ReadRequest
+	req.StudID.Low = 0
+	req.StudID.High = 299
+	for _, hostname := range []string{"Server1", "Server2", "Server3"} {
+		c.orch.setDelay(hostname, 200*time.Millisecond)
+	}
+	start := time.Now()
+	c.mustDo("POST", "/read", req, &resp)
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("reading 3 shards took %v, want them read concurrently", elapsed)
+	}
+	req.Parallelism = 1
+	start = time.Now()
+	c.mustDo("POST", "/read", req, &resp)
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf("reading 3 shards one at a time took %v, want at least 600ms", elapsed)
+	}
+	assertRows(t, resp.Data, testStudents)
+
+	req.Parallelism = 0
+	req.TimeoutMs = 50
+	var failed apierror.Error
+	if status := c.do("POST", "/read", req, &failed); status != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d for a read past its deadline, want 503", status)
+	}
+	if len(failed.Errors) != 3 || failed.Code != apierror.Unavailable {
+		t.Errorf("got code %q and errors %v, want all 3 shards to time out", failed.Code, failed.Errors)
+	}
+	for _, hostname := range []string{"Server1", "Server2", "Server3"} {
+		c.orch.setDelay(hostname, 0)
+	}
+
+	// sh3 is on Server2 and Server3 only
+	c.orch.setAvailable("Server2", false)
+	c.orch.setAvailable("Server3", false)
+	req.TimeoutMs = 0
+	failed = apierror.Error{}
+	if status := c.do("POST", "/read", req, &failed); status != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d with sh3 down, want 503", status)
+	}
+	if _, ok := failed.Errors["sh3"]; len(failed.Errors) != 1 || !ok || failed.Shard != "sh3" || failed.Status != "failure" {
+		t.Errorf("got status %q, shard %q and errors %v, want a failure for sh3 only", failed.Status, failed.Shard, failed.Errors)
+	}
+
+	req.AllowPartial = true
+	resp = ReadResponse{}
+	c.mustDo("POST", "/read", req, &resp)
+	if _, ok := resp.Errors["sh3"]; len(resp.Errors) != 1 || !ok || resp.Status != "partial" {
+		t.Errorf("got status %q and errors %v, want a partial read without sh3", resp.Status, resp.Errors)
+	}
+	assertRows(t, resp.Data, testStudents[:3])
+
+	req.Parallelism = -1
+	if status := c.do("POST", "/read", req, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for a negative parallelism, want 400", status)
+	}
+}
+
+func TestShardRangeWhere(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	where := []Predicate{{Column: "Stud_marks", Op: ">", Value: 70}}
+	got := shardRangeWhere(Shard{StudIDLow: 100, ShardID: "sh2", ShardSize: 100}, where)
+	want := []Predicate{
+		{Column: "Stud_id", Op: ">=", Value: 100},
+		{Column: "Stud_id", Op: "<=", Value: 199},
+		{Column: "Stud_marks", Op: ">", Value: 70},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(where) != 1 {
+		t.Errorf("the predicates of the request changed to %v", where)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// The load balancer traces each client request and passes the trace on to
+// the shard servers it sends requests to, so a request can be followed
+// through every server it touched. main sets up the exporters.
+
+var (
+	tracer = tracing.NewTracer("galaxydb-loadbalancer", "")
+
+	// serverClient sends the requests of a client request to the shard
+	// servers, as children of its span.
+	serverClient = &http.Client{Transport: tracing.NewTransport(nil)}
+)
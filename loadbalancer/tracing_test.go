@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// exportedSpan is a span read back from a traces file, with the process that
+// recorded it.
+type exportedSpan struct {
+	tracing.SpanData
+	process string
+}
+
+func (s exportedSpan) attribute(key string) interface{} {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value.Value()
+		}
+	}
+	return nil
+}
+
+// readSpans returns the spans in a traces file recorded for a request id.
+func readSpans(t *testing.T, path string, requestID string) []exportedSpan {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	spans := []exportedSpan{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var request tracing.ExportRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Fatalf("bad traces line %q: %v", scanner.Text(), err)
+		}
+		for _, rs := range request.ResourceSpans {
+			process := ""
+			for _, kv := range rs.Resource.Attributes {
+				if kv.Key == "service.instance.id" || (kv.Key == "service.name" && process == "") {
+					process = kv.Value.Value().(string)
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, data := range ss.Spans {
+					span := exportedSpan{data, process}
+					if span.attribute("request.id") == requestID {
+						spans = append(spans, span)
+					}
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return spans
+}
+
+func findSpan(spans []exportedSpan, process string, name string, kind tracing.SpanKind) (exportedSpan, bool) {
+	for _, span := range spans {
+		if span.process == process && span.Name == name && span.Kind == kind {
+			return span, true
+		}
+	}
+	return exportedSpan{}, false
+}
+
+func TestWriteTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("GALAXYDB_TRACES_FILE", path)
+	untraced := tracer
+	tracer = tracing.NewTracer("galaxydb-loadbalancer", "", tracing.ExportersFromEnv()...)
+	t.Cleanup(func() { tracer = untraced })
+
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	payload, err := json.Marshal(WriteRequest{Data: []Row{student(150, "Kabir", 92)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", c.lb.URL+"/write", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(tracing.RequestIDHeader, "write-150")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(tracing.RequestIDHeader); got != "write-150" {
+		t.Errorf("answered with request id %q, want write-150", got)
+	}
+	tracer.Flush()
+
+	// sh2 is on Server1, its primary, and Server2, which export their spans
+	// to the file on their own time
+	var spans []exportedSpan
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		spans = readSpans(t, path, "write-150")
+		if _, ok := findSpan(spans, "Server2", "POST /replay", tracing.SpanKindServer); ok || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	// each span is the parent of the next
+	chain := []struct {
+		process string
+		name    string
+		kind    tracing.SpanKind
+	}{
+		{"galaxydb-loadbalancer", "POST /write", tracing.SpanKindServer},
+		{"galaxydb-loadbalancer", "write shard", tracing.SpanKindInternal},
+		{"galaxydb-loadbalancer", "POST /write", tracing.SpanKindClient},
+		{"Server1", "POST /write", tracing.SpanKindServer},
+		{"Server1", "replicate", tracing.SpanKindInternal},
+		{"Server1", "POST /replay", tracing.SpanKindClient},
+		{"Server2", "POST /replay", tracing.SpanKindServer},
+	}
+	parent := exportedSpan{}
+	for i, want := range chain {
+		span, ok := findSpan(spans, want.process, want.name, want.kind)
+		if !ok {
+			t.Fatalf("no span %q of kind %d on %s among %d spans", want.name, want.kind, want.process, len(spans))
+		}
+		if i > 0 && (span.ParentSpanID != parent.SpanID || span.TraceID != parent.TraceID) {
+			t.Errorf("%s %q: got parent %s/%s, want %s/%s", want.process, want.name, span.TraceID, span.ParentSpanID, parent.TraceID, parent.SpanID)
+		}
+		parent = span
+	}
+
+	if span, _ := findSpan(spans, "galaxydb-loadbalancer", "write shard", tracing.SpanKindInternal); span.attribute("galaxydb.shard") != "sh2" {
+		t.Errorf("write shard span has shard %v, want sh2", span.attribute("galaxydb.shard"))
+	}
+	if span, _ := findSpan(spans, "Server1", "apply", tracing.SpanKindInternal); span.attribute("galaxydb.entries") != int64(1) {
+		t.Errorf("apply span has %v entries, want 1", span.attribute("galaxydb.entries"))
+	}
+}
+
+func TestMergeTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("GALAXYDB_TRACES_FILE", path)
+	untraced := tracer
+	tracer = tracing.NewTracer("galaxydb-loadbalancer", "", tracing.ExportersFromEnv()...)
+	t.Cleanup(func() { tracer = untraced })
+
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	payload, err := json.Marshal(MergeRequest{Shards: []string{"sh1", "sh2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", c.lb.URL+"/merge", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(tracing.RequestIDHeader, "merge-sh2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	tracer.Flush()
+
+	// the replicas of the merged shard are dropped within the trace of the
+	// merge
+	var spans []exportedSpan
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		spans = readSpans(t, path, "merge-sh2")
+		if _, ok := findSpan(spans, "Server2", "DELETE /drop", tracing.SpanKindServer); ok || time.Now().After(deadline) {
+			break
+		}
+	}
+	merge, ok := findSpan(spans, "galaxydb-loadbalancer", "POST /merge", tracing.SpanKindServer)
+	if !ok {
+		t.Fatalf("no merge span among %d spans", len(spans))
+	}
+	drop, ok := findSpan(spans, "galaxydb-loadbalancer", "DELETE /drop", tracing.SpanKindClient)
+	if !ok || drop.TraceID != merge.TraceID {
+		t.Fatalf("no drop span in the trace of the merge among %d spans", len(spans))
+	}
+	if served, ok := findSpan(spans, "Server2", "DELETE /drop", tracing.SpanKindServer); !ok || served.TraceID != merge.TraceID {
+		t.Error("Server2 did not serve the drop in the trace of the merge")
+	}
+}
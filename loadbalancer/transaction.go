@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// A write that spans several shards runs as a two-phase commit with the load
+// balancer as coordinator. The primary of every shard first prepares its part
+// of the batch. If any of them refuses, all parts are aborted and nothing is
+// applied. Otherwise the parts are saved in txnt, which is the commit point,
+// and each is committed and removed from txnt in turn. A part whose primary
+// fails before committing it stays in txnt and is applied on the shard's next
+// primary once it is elected, when the load balancer restarts, or before the
+// next change to the shard.
+
+type transactionPart struct {
+	TxID         string
+	Shard        string
+	CurrentIndex int
+	Data         []Row
+}
+
+func newTransactionID() string {
+	return fmt.Sprintf("%x-%x", time.Now().UnixNano(), rand.Int63())
+}
+
+func saveTransaction(parts []transactionPart) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, part := range parts {
+		data, err := json.Marshal(part.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO txnt (tx_id, shard_id, curr_idx, data) VALUES (?, ?, ?, ?);", part.TxID, part.Shard, part.CurrentIndex, string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadPendingTransactions returns the committed parts not yet applied to a
+// shard, in the order they were prepared in.
+func loadPendingTransactions(shardID string) ([]transactionPart, error) {
+	rows, err := db.Query("SELECT tx_id, curr_idx, data FROM txnt WHERE shard_id = ? ORDER BY curr_idx;", shardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []transactionPart{}
+	for rows.Next() {
+		var data string
+		part := transactionPart{Shard: shardID}
+		if err := rows.Scan(&part.TxID, &part.CurrentIndex, &data); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(strings.NewReader(data), &part.Data); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, rows.Err()
+}
+
+func abortTransaction(ctx context.Context, txID string, shardIDs []string) {
+	for _, shardID := range shardIDs {
+		primaryServerID, _, err := getShardPrimary(db, shardID)
+		if err == nil {
+			_, err = sendToPrimary(ctx, "POST", primaryServerID, "/abort", ServerTxPayload{TxID: txID, Shard: shardID})
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "error aborting transaction", "tx_id", txID, "shard", shardID, logging.Error(err))
+		}
+	}
+}
+
+// commitTransactionPart applies a committed part of a transaction to its shard
+// and removes it from txnt. If the primary no longer has the part prepared,
+// because it took over from the one that did, the part is written to it
+// directly, unless the shard shows it was already applied. Callers must hold
+// the shard mutex.
+func commitTransactionPart(ctx context.Context, part transactionPart, minAcks int) (*ServerWriteResponse, error) {
+	primaryServerID, term, err := getShardPrimary(db, part.Shard)
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := getReplicaAddresses(part.Shard, primaryServerID)
+	if err != nil {
+		return nil, err
+	}
+
+	respData, err := sendToPrimary(ctx, "POST", primaryServerID, "/commit", ServerTxPayload{
+		TxID:     part.TxID,
+		Shard:    part.Shard,
+		Replicas: replicas,
+		MinAcks:  minAcks,
+	})
+	if err != nil {
+		idx, idxErr := getServerIndex(ctx, primaryServerID, part.Shard)
+		switch {
+		case idxErr != nil:
+			return nil, err
+		case idx == part.CurrentIndex+len(part.Data):
+			// only the primary is known to have it
+			respData = &ServerWriteResponse{CurrentIndex: idx, Acks: 1}
+		case idx == part.CurrentIndex:
+			respData, err = sendToPrimary(ctx, "POST", primaryServerID, "/write", ServerWritePayload{
+				ServerChangePayload: ServerChangePayload{
+					Shard:        part.Shard,
+					CurrentIndex: part.CurrentIndex,
+					Term:         term,
+					Replicas:     replicas,
+					MinAcks:      minAcks,
+				},
+				Data: part.Data,
+			})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s is at index %d, transaction %s expected %d: %v", part.Shard, idx, part.TxID, part.CurrentIndex, err)
+		}
+	}
+	if respData.CurrentIndex != part.CurrentIndex+len(part.Data) {
+		return nil, fmt.Errorf("invalid index %d", respData.CurrentIndex)
+	}
+
+	_, err = db.Exec("UPDATE shardt SET valid_idx = ? WHERE shard_id = ?;", respData.CurrentIndex, part.Shard)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("DELETE FROM txnt WHERE tx_id = ? AND shard_id = ?;", part.TxID, part.Shard)
+	if err != nil {
+		return nil, err
+	}
+	return respData, nil
+}
+
+// resumeTransactions applies the committed parts of transactions still
+// pending on a shard, stopping at the first that fails. Every change to a
+// shard resumes them first, as the parts expect the shard at the index they
+// were prepared at. Callers must hold the shard mutex.
+func resumeTransactions(shardID string) error {
+	parts, err := loadPendingTransactions(shardID)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if _, err := commitTransactionPart(context.Background(), part, 0); err != nil {
+			return fmt.Errorf("committing transaction %s: %w", part.TxID, err)
+		}
+		slog.Info("committed pending transaction", "tx_id", part.TxID, "shard", shardID)
+	}
+	return nil
+}
+
+// writeTransaction writes rows grouped by shard with a two-phase commit. It
+// returns the status to answer the client with if the write failed. Callers
+// must hold the mutexes of the shards.
+func writeTransaction(ctx context.Context, studDataToWrite map[string][]Row, level string) (status int, err error) {
+	shardIDs := []string{}
+	for shardID := range studDataToWrite {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Strings(shardIDs)
+
+	txID := newTransactionID()
+	ctx, span := tracing.Start(ctx, "transaction", tracing.SpanKindInternal)
+	span.SetAttribute("galaxydb.tx_id", txID)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	parts := []transactionPart{}
+	for _, shardID := range shardIDs {
+		if err := resumeTransactions(shardID); err != nil {
+			abortTransaction(ctx, txID, shardIDs)
+			return http.StatusServiceUnavailable, fmt.Errorf("transaction aborted, %s has transactions still to commit: %w", shardID, err)
+		}
+		primaryServerID, term, err := getShardPrimary(db, shardID)
+		if err != nil {
+			abortTransaction(ctx, txID, shardIDs)
+			return http.StatusInternalServerError, fmt.Errorf("transaction aborted: %v", err)
+		}
+		currentIndex, err := getValidIDx(db, shardID)
+		if err != nil {
+			abortTransaction(ctx, txID, shardIDs)
+			return http.StatusInternalServerError, fmt.Errorf("transaction aborted: %v", err)
+		}
+		part := transactionPart{
+			TxID:         txID,
+			Shard:        shardID,
+			CurrentIndex: currentIndex,
+			Data:         studDataToWrite[shardID],
+		}
+
+		_, err = sendToPrimary(ctx, "POST", primaryServerID, "/prepare", ServerPreparePayload{
+			TxID:         txID,
+			Shard:        shardID,
+			CurrentIndex: part.CurrentIndex,
+			Term:         term,
+			Data:         part.Data,
+		})
+		if err != nil {
+			abortTransaction(ctx, txID, shardIDs)
+			status := http.StatusInternalServerError
+			var respErr *serverError
+			if errors.As(err, &respErr) && respErr.status == http.StatusBadRequest {
+				status = http.StatusBadRequest
+			}
+			return status, fmt.Errorf("transaction aborted, %s refused it: %w", shardID, err)
+		}
+		parts = append(parts, part)
+	}
+
+	if err := saveTransaction(parts); err != nil {
+		abortTransaction(ctx, txID, shardIDs)
+		return http.StatusInternalServerError, fmt.Errorf("transaction aborted: %v", err)
+	}
+
+	pending := []string{}
+	errs := []string{}
+	for _, part := range parts {
+		shardServerIDs, err := getServerIDsForShard(db, part.Shard)
+		needed := requiredReplicas(level, len(shardServerIDs))
+		var respData *ServerWriteResponse
+		if err == nil {
+			respData, err = commitTransactionPart(ctx, part, needed)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "error committing transaction", "tx_id", txID, "shard", part.Shard, logging.Error(err))
+			pending = append(pending, part.Shard)
+			continue
+		}
+		if err := checkAcks(part.Shard, respData, needed); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(pending) > 0 {
+		return http.StatusServiceUnavailable, fmt.Errorf("transaction %s committed, but not yet applied to %s; it will be once their primaries are back", txID, strings.Join(pending, ", "))
+	}
+	if len(errs) > 0 {
+		return http.StatusServiceUnavailable, errors.New(strings.Join(errs, "; "))
+	}
+	return http.StatusOK, nil
+}
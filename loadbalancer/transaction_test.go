@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMultiShardWriteIsAtomic(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+
+	assertNothingWritten := func() {
+		t.Helper()
+		assertRows(t, c.read(0, 299).Data, []Row{})
+		for _, shardID := range []string{"sh1", "sh2", "sh3"} {
+			if idx := c.validIdx(shardID); idx != 0 {
+				t.Errorf("%s: got valid_idx %d, want 0", shardID, idx)
+			}
+		}
+	}
+
+	// one bad row in sh3 keeps the rows for sh1 and sh2 out too
+	bad := append([]Row{}, testStudents...)
+	bad[4] = Row{"Stud_id": 299, "Stud_name": "Rohan", "Stud_marks": "high"}
+	if status := c.do("POST", "/write", WriteRequest{Data: bad}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for a batch with a bad row, want 400", status)
+	}
+	assertNothingWritten()
+
+	// so does a shard whose primary cannot be reached
+	c.orch.setAvailable("Server2", false)
+	if status := c.do("POST", "/write", WriteRequest{Data: testStudents}, nil); status == http.StatusOK {
+		t.Error("a batch was written with the primary of sh3 down")
+	}
+	c.orch.setAvailable("Server2", true)
+	assertNothingWritten()
+
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents}, nil)
+	assertRows(t, c.read(0, 299).Data, testStudents)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM txnt;").Scan(&count); err != nil || count != 0 {
+		t.Errorf("got %d transactions (%v) left in txnt, want none", count, err)
+	}
+}
+
+func TestResumeTransaction(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents[:1]}, nil)
+
+	// a transaction committed by the load balancer whose primary failed
+	// before it was applied; the shard's primary has never seen it
+	newStudent := student(30, "Anika", 88)
+	err := saveTransaction([]transactionPart{{TxID: "t1", Shard: "sh1", CurrentIndex: 1, Data: []Row{newStudent}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, _ := lockShard("sh1")
+	err = resumeTransactions("sh1")
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Row{testStudents[0], newStudent}
+	for _, serverID := range c.replicas("sh1") {
+		assertRows(t, c.shardData(serverID, "sh1"), want)
+	}
+	if idx := c.validIdx("sh1"); idx != 2 {
+		t.Errorf("got valid_idx %d, want 2", idx)
+	}
+	parts, err := loadPendingTransactions("sh1")
+	if err != nil || len(parts) != 0 {
+		t.Errorf("got %d pending parts (%v), want none", len(parts), err)
+	}
+
+	// resuming again changes nothing
+	unlock, _ = lockShard("sh1")
+	err = resumeTransactions("sh1")
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRows(t, c.read(0, 99).Data, want)
+}
+
+func TestWriteAfterPendingTransaction(t *testing.T) {
+	c := newTestCluster(t)
+	c.mustDo("POST", "/init", testInitRequest(), nil)
+	c.mustDo("POST", "/write", WriteRequest{Data: testStudents[:1]}, nil)
+
+	// a transaction prepared and committed whose commit never reached the
+	// primary of sh1, which stays up
+	prepared := student(30, "Anika", 88)
+	primaryServerID, term := c.primary("sh1")
+	_, err := sendToPrimary(context.Background(), "POST", primaryServerID, "/prepare", ServerPreparePayload{
+		TxID:         "t1",
+		Shard:        "sh1",
+		CurrentIndex: 1,
+		Term:         term,
+		Data:         []Row{prepared},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveTransaction([]transactionPart{{TxID: "t1", Shard: "sh1", CurrentIndex: 1, Data: []Row{prepared}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next write to sh1 commits it first
+	written := student(40, "Neel", 66)
+	c.mustDo("POST", "/write", WriteRequest{Data: []Row{written}}, nil)
+
+	want := []Row{testStudents[0], prepared, written}
+	for _, serverID := range c.replicas("sh1") {
+		assertRows(t, c.shardData(serverID, "sh1"), want)
+	}
+	if idx := c.validIdx("sh1"); idx != 3 {
+		t.Errorf("got valid_idx %d, want 3", idx)
+	}
+	parts, err := loadPendingTransactions("sh1")
+	if err != nil || len(parts) != 0 {
+		t.Errorf("got %d pending parts (%v), want none", len(parts), err)
+	}
+}
@@ -6,15 +6,25 @@ import (
 	"github.com/Sarita-Singh/galaxyDB/loadbalancer/internal/consistenthashmap"
 )
 
+// ShardTConfig is the in-memory state of a shard: the selector reads use to
+// pick a replica and the mutex changes to the shard hold. A shard merged away
+// or discarded is retired rather than forgotten, as writers may still be
+// waiting on its mutex.
 type ShardTConfig struct {
-	chm   *consistenthashmap.ConsistentHashMap
-	mutex *sync.Mutex
+	chm     consistenthashmap.ReplicaSelector
+	mutex   *sync.Mutex
+	retired bool
 }
 
+// Shard is a range of the shard key. Replicas is how many copies of it the
+// cluster keeps, see InitRequest, and Selector how reads pick one of them:
+// "ring" (the default), "rendezvous", "jump" or "bounded".
 type Shard struct {
 	StudIDLow int    `json:"Stud_id_low"`
 	ShardID   string `json:"Shard_id"`
 	ShardSize int    `json:"Shard_size"`
+	Replicas  int    `json:"replicas,omitempty"`
+	Selector  string `json:"selector,omitempty"`
 }
 
 type SchemaConfig struct {
@@ -22,17 +32,34 @@ type SchemaConfig struct {
 	Dtypes  []string `json:"dtypes"`
 }
 
+// InitRequest configures the cluster. Servers maps server names to the
+// shards they hold. Shards it does not place anywhere, or all of them if it is
+// left out, are spread over the servers, N new servers being started if
+// Servers is left out. A shard is kept at the number of replicas it asks for,
+// else at the number of servers Servers places it on, else at Replicas.
+// HashRing configures the hash map of every shard. Weights gives servers a
+// capacity weight, see WeightRequest. ReadRouting is how reads pick a
+// replica: "p2c" (the default), "least_loaded" or "hash".
 type InitRequest struct {
-	N       int                 `json:"N"`
-	Schema  SchemaConfig        `json:"schema"`
-	Shards  []Shard             `json:"shards"`
-	Servers map[string][]string `json:"servers"`
+	N           int                       `json:"N"`
+	Schema      SchemaConfig              `json:"schema"`
+	Shards      []Shard                   `json:"shards"`
+	Servers     map[string][]string       `json:"servers"`
+	Replicas    int                       `json:"replicas"`
+	HashRing    consistenthashmap.Options `json:"hash_ring"`
+	Weights     map[string]int            `json:"weights"`
+	ReadRouting string                    `json:"read_routing"`
 }
 
+// AddRequest starts new servers and adds new shards, placed as in
+// InitRequest. With Rebalance set, replicas of existing shards are then moved
+// onto the new servers until every server holds about as many.
 type AddRequest struct {
 	N         int                 `json:"n"`
 	NewShards []Shard             `json:"new_shards"`
 	Servers   map[string][]string `json:"servers"`
+	Rebalance bool                `json:"rebalance"`
+	Weights   map[string]int      `json:"weights"`
 }
 
 type AddResponseSuccess struct {
@@ -41,11 +68,6 @@ type AddResponseSuccess struct {
 	Status  string `json:"status"`
 }
 
-type AddResponseFailed struct {
-	Message string `json:"message"`
-	Status  string `json:"status"`
-}
-
 type RemoveRequest struct {
 	N       int      `json:"n"`
 	Servers []string `json:"servers"`
@@ -56,9 +78,29 @@ type RemoveResponseSuccess struct {
 	Status  string                 `json:"status"`
 }
 
-type RemoveResponseFailed struct {
-	Message string `json:"message"`
-	Status  string `json:"status"`
+type SplitRequest struct {
+	Shard    string   `json:"shard"`
+	At       int      `json:"at"`
+	NewShard string   `json:"new_shard"`
+	Servers  []string `json:"servers"`
+}
+
+type MergeRequest struct {
+	Shards []string `json:"shards"`
+}
+
+// WeightRequest sets the capacity weight of a server. A server of weight w
+// gets w times as many virtual servers in the hash map of each of its shards,
+// and so about w times the reads, of a server of weight 1.
+type WeightRequest struct {
+	Server string `json:"server"`
+	Weight int    `json:"weight"`
+}
+
+type MigrateRequest struct {
+	Shard string `json:"shard"`
+	From  string `json:"from"`
+	To    string `json:"to"`
 }
 
 type ServerConfigPayload struct {
@@ -66,25 +108,121 @@ type ServerConfigPayload struct {
 	Shards []string     `json:"shards"`
 }
 
-type StudT struct {
-	StudID    int    `json:"Stud_id"`
-	StudName  string `json:"Stud_name"`
-	StudMarks int    `json:"Stud_marks"`
+// Row is one entry of the table, keyed by column name. Rows are routed to
+// shards by their shard key, the first column of the schema.
+type Row map[string]interface{}
+
+// ReadOptions are how /read and /query read the shards. Parallelism caps how
+// many shards are read at once and TimeoutMs how long the whole read may
+// take, 0 meaning the defaults. HedgeAfterMs, if set, is how long a shard's
+// replica may take before the read is also sent to another.
+type ReadOptions struct {
+	Consistency  string `json:"consistency"`
+	Parallelism  int    `json:"parallelism"`
+	TimeoutMs    int    `json:"timeout_ms"`
+	HedgeAfterMs int    `json:"hedge_after_ms"`
 }
 
+// ReadRequest selects rows by a range of the shard key. The field keeps its
+// historical Stud_id name whatever the key column is called. With
+// AllowPartial shards that fail are reported in the response instead of
+// failing the read.
 type ReadRequest struct {
 	StudID struct {
 		Low  int `json:"low"`
 		High int `json:"high"`
 	} `json:"Stud_id"`
+	ReadOptions
+	AllowPartial bool `json:"allow_partial"`
 }
 
+// ReadResponse has the rows in key order. Errors holds, by shard id, why the
+// shards that could not be read failed, for reads that allow partial results.
 type ReadResponse struct {
+	ShardsQueried []string          `json:"shards_queried"`
+	Data          []Row             `json:"data"`
+	Errors        map[string]string `json:"errors,omitempty"`
+	Status        string            `json:"status"`
+}
+
+// Predicate compares a column with a value: Op is one of =, !=, <, <=, >, >=,
+// LIKE or IN, which takes a list. = and != with a null value test for null.
+type Predicate struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+}
+
+// OrderBy sorts by a column, ascending unless Desc is set.
+type OrderBy struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// QueryRequest selects the Columns, all if empty, of the rows that match every
+// predicate in Where, sorted by OrderBy and then by the shard key. With Limit
+// set at most that many rows are returned, and Cursor, the NextCursor of the
+// previous page, continues after its last row.
+type QueryRequest struct {
+	Columns []string    `json:"columns"`
+	Where   []Predicate `json:"where"`
+	OrderBy []OrderBy   `json:"order_by"`
+	Limit   int         `json:"limit"`
+	Cursor  string      `json:"cursor"`
+	ReadOptions
+}
+
+// QueryResponse has a NextCursor when there are rows after the last one.
+type QueryResponse struct {
+	ShardsQueried []string `json:"shards_queried"`
+	Data          []Row    `json:"data"`
+	NextCursor    string   `json:"next_cursor,omitempty"`
+	Status        string   `json:"status"`
+}
+
+type ServerQueryPayload struct {
+	Shard   string      `json:"shard"`
+	Columns []string    `json:"columns"`
+	Where   []Predicate `json:"where"`
+	OrderBy []OrderBy   `json:"order_by"`
+	Limit   int         `json:"limit"`
+	Cursor  string      `json:"cursor"`
+}
+
+// Aggregate applies Func, one of COUNT, SUM, AVG, MIN or MAX, to a column.
+// COUNT without a column counts rows. As names the result, by default the
+// function and column in lower case, as in avg_Stud_marks, or count.
+type Aggregate struct {
+	Func   string `json:"func"`
+	Column string `json:"column"`
+	As     string `json:"as"`
+}
+
+// AggregateRequest computes aggregates over the rows that match every
+// predicate in Where, for each group of rows with the same values in the
+// GroupBy columns.
+type AggregateRequest struct {
+	Aggregates []Aggregate `json:"aggregates"`
+	Where      []Predicate `json:"where"`
+	GroupBy    []string    `json:"group_by"`
+	ReadOptions
+}
+
+// AggregateResponse has one row per group, with the group columns and the
+// aggregates, sorted by the group columns.
+type AggregateResponse struct {
 	ShardsQueried []string `json:"shards_queried"`
-	Data          []StudT  `json:"data"`
+	Data          []Row    `json:"data"`
 	Status        string   `json:"status"`
 }
 
+type ServerAggregatePayload struct {
+	Shard      string      `json:"shard"`
+	Aggregates []Aggregate `json:"aggregates"`
+	Where      []Predicate `json:"where"`
+	GroupBy    []string    `json:"group_by"`
+}
+
 type ServerReadPayload struct {
 	Shard  string `json:"shard"`
 	StudID struct {
@@ -93,13 +231,18 @@ type ServerReadPayload struct {
 	} `json:"Stud_id"`
 }
 
+// ServerReadResponse is what a server answers to a /read or a /query. Only
+// queries have a NextCursor.
 type ServerReadResponse struct {
-	Status string  `json:"status"`
-	Data   []StudT `json:"data"`
+	Status       string `json:"status"`
+	Data         []Row  `json:"data"`
+	NextCursor   string `json:"next_cursor"`
+	CurrentIndex int    `json:"current_idx"`
 }
 
 type WriteRequest struct {
-	Data []StudT `json:"data"`
+	Data        []Row  `json:"data"`
+	Consistency string `json:"consistency"`
 }
 
 type WriteResponse struct {
@@ -107,21 +250,37 @@ type WriteResponse struct {
 	Status  string `json:"status"`
 }
 
+// ServerChangePayload is what every change sent to the primary of a shard
+// carries: the index and term it applies to and the replicas to pass it on to.
+type ServerChangePayload struct {
+	Shard        string   `json:"shard"`
+	CurrentIndex int      `json:"curr_idx"`
+	Term         int      `json:"term"`
+	Replicas     []string `json:"replicas"`
+	MinAcks      int      `json:"min_acks"`
+}
+
+func (p *ServerChangePayload) change() *ServerChangePayload { return p }
+
 type ServerWritePayload struct {
-	Shard        string  `json:"shard"`
-	CurrentIndex int     `json:"curr_idx"`
-	Data         []StudT `json:"data"`
+	ServerChangePayload
+	Data []Row `json:"data"`
 }
 
+func (p *ServerWritePayload) entries() int { return len(p.Data) }
+
 type ServerWriteResponse struct {
-	Status       string `json:"status"`
-	Message      string `json:"message"`
-	CurrentIndex int    `json:"current_idx"`
+	Status         string   `json:"status"`
+	Message        string   `json:"message"`
+	CurrentIndex   int      `json:"current_idx"`
+	Acks           int      `json:"acks"`
+	FailedReplicas []string `json:"failed_replicas"`
 }
 
 type UpdateRequest struct {
-	StudID int   `json:"Stud_id"`
-	Data   StudT `json:"data"`
+	StudID      int    `json:"Stud_id"`
+	Data        Row    `json:"data"`
+	Consistency string `json:"consistency"`
 }
 
 type UpdateResponse struct {
@@ -130,13 +289,16 @@ type UpdateResponse struct {
 }
 
 type ServerUpdatePayload struct {
-	Shard  string `json:"shard"`
-	StudID int    `json:"Stud_id"`
-	Data   StudT  `json:"data"`
+	ServerChangePayload
+	StudID int `json:"Stud_id"`
+	Data   Row `json:"data"`
 }
 
+func (p *ServerUpdatePayload) entries() int { return 1 }
+
 type DeleteRequest struct {
-	StudID int `json:"Stud_id"`
+	StudID      int    `json:"Stud_id"`
+	Consistency string `json:"consistency"`
 }
 
 type DeleteResponse struct {
@@ -145,12 +307,65 @@ type DeleteResponse struct {
 }
 
 type ServerDeletePayload struct {
-	Shard  string `json:"shard"`
-	StudID int    `json:"Stud_id"`
+	ServerChangePayload
+	StudID int  `json:"Stud_id"`
+	High   *int `json:"high,omitempty"`
+}
+
+func (p *ServerDeletePayload) entries() int { return 1 }
+
+type ServerDropPayload struct {
+	Shards []string `json:"shards"`
 }
 
 type ServerCopyPayload struct {
 	Shards []string `json:"shards"`
 }
 
-type ServerCopyResponse map[string][]StudT
+type ServerCopyResponse map[string][]Row
+
+type LogEntry struct {
+	Seq  int    `json:"seq"`
+	Op   string `json:"op"`
+	Key  int    `json:"key"`
+	Data Row    `json:"data,omitempty"`
+}
+
+type ServerLogPayload struct {
+	Shard string `json:"shard"`
+	After int    `json:"after"`
+	Limit int    `json:"limit"`
+}
+
+type ServerLogResponse struct {
+	Entries      []LogEntry `json:"entries"`
+	CurrentIndex int        `json:"current_idx"`
+	Status       string     `json:"status"`
+}
+
+type ServerReplayPayload struct {
+	Shard   string     `json:"shard"`
+	Term    int        `json:"term"`
+	Entries []LogEntry `json:"entries"`
+}
+
+type ServerPromotePayload struct {
+	Shard    string   `json:"shard"`
+	Term     int      `json:"term"`
+	Replicas []string `json:"replicas"`
+}
+
+type ServerPreparePayload struct {
+	TxID         string `json:"tx_id"`
+	Shard        string `json:"shard"`
+	CurrentIndex int    `json:"curr_idx"`
+	Term         int    `json:"term"`
+	Data         []Row  `json:"data"`
+}
+
+type ServerTxPayload struct {
+	TxID     string   `json:"tx_id"`
+	Shard    string   `json:"shard"`
+	Replicas []string `json:"replicas"`
+	MinAcks  int      `json:"min_acks"`
+}
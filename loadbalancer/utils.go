@@ -2,34 +2,44 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
 )
 
 func getRandomID() int {
 	return rand.Intn(900000) + 100000
 }
 
-func getServerID(rawServerName string) int {
-	rawServerID := rawServerName[len("Server"):]
+// getServerID parses a server name of the form Server<id>.
+func getServerID(rawServerName string) (int, error) {
+	rawServerID, ok := strings.CutPrefix(rawServerName, "Server")
 	serverID, err := strconv.Atoi(rawServerID)
-	if err != nil {
-		return getRandomID()
+	if !ok || err != nil || serverID <= 0 {
+		return 0, fmt.Errorf("invalid server name %q, expected Server<id>", rawServerName)
 	}
-	return serverID
+	return serverID, nil
 }
 
-func buildServerInstance() {
+func buildServerInstance() error {
 	env := os.Getenv("GO_ENV")
 	var serverPath string
 	if env == "production" {
@@ -38,72 +48,135 @@ func buildServerInstance() {
 		serverPath = "../server"
 	}
 
-	cmd := exec.Command("sudo", "docker", "build", "--tag", SERVER_DOCKER_IMAGE_NAME, serverPath)
-	err := cmd.Run()
-	if err != nil {
-		log.Fatalln("Failed to build server image: ", err)
-	} else {
-		log.Println("Server image built successfully")
+	if err := orch.Build(serverPath); err != nil {
+		return fmt.Errorf("failed to build server instance: %w", err)
 	}
+	slog.Info("server instance built")
+	return nil
 }
 
-func spawnNewServerInstance(hostname string, id int) {
-	cmd := exec.Command("sudo", "docker", "run", "--rm", "-d", "--name", hostname, "--network", DOCKER_NETWORK_NAME, "-e", fmt.Sprintf("id=%d", id), fmt.Sprintf("%s:latest", SERVER_DOCKER_IMAGE_NAME))
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err != nil {
-		log.Fatalf("Failed to start new server instance: %v, stderr: %s", err, stderr.String())
+func spawnNewServerInstance(hostname string, id int) error {
+	if err := orch.Spawn(hostname, id); err != nil {
+		return fmt.Errorf("failed to start new server instance: %w", err)
 	}
+	slog.Info("server instance started", "server", hostname)
+	return nil
 }
 
-func getServerIP(hostname string) string {
-	cmd := exec.Command("sudo", "docker", "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", hostname)
-	output, err := cmd.Output()
+func getServerAddress(hostname string) string {
+	address, err := orch.Address(hostname)
 	if err != nil {
-		log.Println("Error running docker inspect: ", err)
+		slog.Warn("error resolving server address", "server", hostname, logging.Error(err))
 		return ""
 	}
 
-	return strings.TrimSpace(string(output))
+	return address
+}
+
+func getServerURL(serverID int, endpoint string) string {
+	return "http://" + getServerAddress(fmt.Sprintf("Server%d", serverID)) + endpoint
 }
 
-func configNewServerInstance(serverID int, shards []string, schema SchemaConfig) {
+// SERVER_CONFIG_TIMEOUT is how long a server may take to create or drop the
+// tables of shards. Copies drop them under the shard mutex, so a server that
+// hangs must not hold up the shard for longer.
+const SERVER_CONFIG_TIMEOUT = 10 * time.Second
+
+// configNewServerInstance creates the tables of shards on a server.
+func configNewServerInstance(ctx context.Context, serverID int, shards []string, schema SchemaConfig) error {
 	payload := ServerConfigPayload{
 		Schema: schema,
 		Shards: shards,
 	}
 	payloadData, err := json.Marshal(payload)
 	if err != nil {
-		log.Fatalln("Error marshaling JSON: ", err)
-		return
+		return err
 	}
 
-	resp, err := http.Post("http://"+getServerIP(fmt.Sprintf("Server%d", serverID))+":"+fmt.Sprint(SERVER_PORT)+"/config", "application/json", bytes.NewBuffer(payloadData))
+	ctx, cancel := context.WithTimeout(ctx, SERVER_CONFIG_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", getServerURL(serverID, "/config"), bytes.NewBuffer(payloadData))
 	if err != nil {
-		log.Println("Error configuring Server:", err)
-		return
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := serverClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("configuring Server%d: %w", serverID, err)
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readServerError(serverID, resp)
+	}
+	return nil
 }
 
-func removeServerInstance(hostname string) {
-	cmd := exec.Command("sudo", "docker", "stop", hostname)
-	err := cmd.Run()
+// addServerInstance starts a new server holding shardIDs and records it. Once
+// recorded the server is watched by the failure detector, which replaces it
+// if it fails to start.
+func addServerInstance(ctx context.Context, serverID int, shardIDs []string, weight int, schema SchemaConfig) error {
+	for _, shardID := range shardIDs {
+		_, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", shardID, serverID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := addServerRecord(serverID, weight); err != nil {
+		return err
+	}
+
+	addServerID(serverID)
+	defer watchServer(serverID)
+
+	if err := spawnNewServerInstance(fmt.Sprintf("Server%d", serverID), serverID); err != nil {
+		return err
+	}
+	return configNewServerInstance(ctx, serverID, shardIDs, schema)
+}
+
+// placeOnServer adds replicas of shardIDs to a running server.
+func placeOnServer(ctx context.Context, serverID int, shardIDs []string) error {
+	for _, shardID := range shardIDs {
+		_, err := db.Exec("INSERT INTO mapt (shard_id, server_id) VALUES (?, ?);", shardID, serverID)
+		if err != nil {
+			return err
+		}
+	}
+	return configNewServerInstance(ctx, serverID, shardIDs, schemaConfig)
+}
+
+// forgetServer removes a server from mapt, servert and the hash maps of the
+// shards it held.
+func forgetServer(serverID int) error {
+	shardIDs, err := getShardIDsForServer(serverID)
 	if err != nil {
-		log.Fatalf("Failed to stop server instance '%s': %v\n", hostname, err)
-		return
+		return err
+	}
+	for _, shardID := range shardIDs {
+		removeFromShardHashMap(shardID, serverID)
+	}
+
+	_, err = db.Exec("DELETE FROM mapt WHERE server_id = ?;", serverID)
+	if err != nil {
+		return err
+	}
+	return removeServerRecord(serverID)
+}
+
+func removeServerInstance(hostname string) error {
+	if err := orch.Stop(hostname); err != nil {
+		return fmt.Errorf("failed to stop server instance '%s': %w", hostname, err)
 	}
+	return nil
 }
 
 func cleanupServers(serverIDs []int) {
-	log.Println("Cleaning up server instances...")
+	slog.Info("cleaning up server instances")
 
 	for _, server := range serverIDs {
-		stopCmd := exec.Command("sudo", "docker", "stop", fmt.Sprintf("Server%d", server))
-		if err := stopCmd.Run(); err != nil {
-			log.Printf("Failed to stop server '%d': %v\n", server, err)
+		if err := orch.Stop(fmt.Sprintf("Server%d", server)); err != nil {
+			slog.Warn("failed to stop server", "server", fmt.Sprintf("Server%d", server), logging.Error(err))
 		}
 	}
 }
@@ -131,204 +204,607 @@ func chooseRandomServerForRemoval(serverIDs []int, serverIDsRemoved []int) int {
 	return serverIDsAvailable[index]
 }
 
-func getShardIDFromStudID(db *sql.DB, studID int) string {
-	row, err := db.Query("SELECT shard_id FROM shardt WHERE ? BETWEEN stud_id_low AND stud_id_low+shard_size", studID)
-	if err != nil {
-		log.Fatal(err)
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// errorFrom turns an error met while serving a request into the error it is
+// answered with, message saying what failed. A request a server turned down
+// keeps the server's code, and one it failed is a server_error. Servers that
+// cannot be reached and shards without a primary make the request
+// unavailable, and a shard that went away or whose replicas moved while it
+// was being served makes it a conflict.
+func errorFrom(err error, message string) *apierror.Error {
+	code := apierror.Internal
+	server := ""
+	var respErr *serverError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &respErr):
+		code = respErr.apiErr.Code
+		if code == apierror.Internal {
+			code = apierror.ServerError
+		}
+		server = fmt.Sprintf("Server%d", respErr.serverID)
+	case errors.Is(err, context.DeadlineExceeded):
+		code = apierror.Timeout
+	case errors.As(err, &netErr), errors.Is(err, errNoPrimary), errors.Is(err, errShardsMoving):
+		code = apierror.Unavailable
+	case errors.Is(err, errShardGone), errors.Is(err, errReplicasChanged):
+		code = apierror.Conflict
 	}
-	defer row.Close()
+	return apierror.Errorf(code, "%s%s: %v", strings.ToUpper(message[:1]), message[1:], err).WithServer(server)
+}
 
-	var shardID string
-	for row.Next() {
-		err := row.Scan(&shardID)
+// statusError is errorFrom for the errors that come with the status they are
+// answered with. An internal error may still turn out to be a server's.
+func statusError(status int, err error, message string) *apierror.Error {
+	e := errorFrom(err, message)
+	if status != http.StatusInternalServerError {
+		e.Code = apierror.CodeForStatus(status)
+	}
+	return e
+}
+
+// writeError answers r with e, logging failures that are not the client's.
+func writeError(w http.ResponseWriter, r *http.Request, e *apierror.Error) {
+	if e.Code.Status() >= http.StatusInternalServerError {
+		attrs := []any{"code", e.Code}
+		if e.Shard != "" {
+			attrs = append(attrs, "shard", e.Shard)
+		}
+		if e.Server != "" {
+			attrs = append(attrs, "server", e.Server)
+		}
+		attrs = append(attrs, "error", e.Message)
+		if e.Code.Retryable() {
+			slog.WarnContext(r.Context(), "request failed", attrs...)
+		} else {
+			slog.ErrorContext(r.Context(), "request failed", attrs...)
+		}
+	}
+	apierror.Write(w, e)
+}
+
+func validateSchema(schema SchemaConfig) error {
+	if len(schema.Columns) == 0 {
+		return errors.New("schema has no columns")
+	}
+	if len(schema.Columns) != len(schema.Dtypes) {
+		return fmt.Errorf("schema has %d columns but %d dtypes", len(schema.Columns), len(schema.Dtypes))
+	}
+	return nil
+}
+
+// getRowKey returns the shard key of a row, the value of the first schema
+// column.
+func getRowKey(row Row) (int, error) {
+	if len(schemaConfig.Columns) == 0 {
+		return 0, errors.New("database is not configured")
+	}
+	keyColumn := schemaConfig.Columns[0]
+
+	switch value := row[keyColumn].(type) {
+	case json.Number:
+		key, err := value.Int64()
 		if err != nil {
-			log.Fatal(err)
+			return 0, fmt.Errorf("%s must be an integer, got %v", keyColumn, value)
+		}
+		return int(key), nil
+	case float64:
+		if value != math.Trunc(value) {
+			return 0, fmt.Errorf("%s must be an integer, got %v", keyColumn, value)
 		}
+		return int(value), nil
+	case nil:
+		return 0, fmt.Errorf("missing %s", keyColumn)
+	default:
+		return 0, fmt.Errorf("%s must be an integer, got %v", keyColumn, value)
 	}
+}
 
-	return shardID
+// getShardIDFromStudID returns the shard a key falls in, or "" if it falls in
+// none.
+func getShardIDFromStudID(db *sql.DB, studID int) (string, error) {
+	var shardID string
+	err := db.QueryRow("SELECT shard_id FROM shardt WHERE stud_id_low <= ? AND ? < stud_id_low+shard_size", studID, studID).Scan(&shardID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return shardID, err
 }
 
-func getValidIDx(db *sql.DB, shardID string) int {
-	row, err := db.Query("SELECT valid_idx FROM shardt WHERE shard_id=?", shardID)
-	if err != nil {
-		log.Fatal(err)
+// LOCK_SHARDS_ATTEMPTS is how many times lockShardsForKeys looks the keys up
+// again before giving up on shards that keep being split or merged.
+const LOCK_SHARDS_ATTEMPTS = 10
+
+// errShardsMoving is returned for writes to shards that kept being split or
+// merged while the write waited for them.
+var errShardsMoving = errors.New("shards kept moving while waiting for them")
+
+// lockShardsForKeys locks the shards keys fall in, in the order of their ids
+// so that writers cannot deadlock, and returns the shard of each key, "" for
+// keys that fall in none, and the function unlocking them. A shard may be
+// split or merged while a write waits for its mutex, so once they are all
+// locked the keys are looked up again, and the shards locked anew until they
+// still own every key.
+func lockShardsForKeys(keys []int) ([]string, func(), error) {
+	for attempt := 0; attempt < LOCK_SHARDS_ATTEMPTS; attempt++ {
+		owners, err := getShardIDsFromStudIDs(keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		shardIDs := []string{}
+		for _, shardID := range owners {
+			if shardID != "" && !slices.Contains(shardIDs, shardID) {
+				shardIDs = append(shardIDs, shardID)
+			}
+		}
+		sort.Strings(shardIDs)
+
+		unlocks := []func(){}
+		unlock := func() {
+			for i := len(unlocks) - 1; i >= 0; i-- {
+				unlocks[i]()
+			}
+		}
+		for _, shardID := range shardIDs {
+			unlockShard, ok := lockShard(shardID)
+			if !ok {
+				break
+			}
+			unlocks = append(unlocks, unlockShard)
+		}
+		if len(unlocks) == len(shardIDs) {
+			current, err := getShardIDsFromStudIDs(keys)
+			if err != nil {
+				unlock()
+				return nil, nil, err
+			}
+			if slices.Equal(current, owners) {
+				return owners, unlock, nil
+			}
+		}
+		unlock()
 	}
-	defer row.Close()
+	return nil, nil, errShardsMoving
+}
 
-	var validIDx int
-	for row.Next() {
-		err := row.Scan(&validIDx)
+// getShardIDsFromStudIDs returns the shard of each key, "" for keys that fall
+// in none.
+func getShardIDsFromStudIDs(studIDs []int) ([]string, error) {
+	shardIDs := []string{}
+	for _, studID := range studIDs {
+		shardID, err := getShardIDFromStudID(db, studID)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		shardIDs = append(shardIDs, shardID)
 	}
+	return shardIDs, nil
+}
 
-	return validIDx
+func getValidIDx(db *sql.DB, shardID string) (int, error) {
+	var validIDx int
+	err := db.QueryRow("SELECT valid_idx FROM shardt WHERE shard_id=?", shardID).Scan(&validIDx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("no shard %s", shardID)
+	}
+	return validIDx, err
 }
 
-func getServerIDsForShard(db *sql.DB, shardID string) []int {
-	row, err := db.Query("SELECT server_id FROM mapt WHERE shard_id=?", shardID)
+// getShardPrimary returns the primary of a shard and the term it was elected
+// for, or -1 if the shard has none.
+func getShardPrimary(db *sql.DB, shardID string) (int, int, error) {
+	var primaryServerID, term int
+	err := db.QueryRow("SELECT COALESCE(primary_server, -1), COALESCE(term, 0) FROM shardt WHERE shard_id=?", shardID).Scan(&primaryServerID, &term)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, 0, nil
+	}
 	if err != nil {
-		log.Fatal(err)
+		return -1, 0, err
 	}
-	defer row.Close()
+	return primaryServerID, term, nil
+}
+
+func getServerIDsForShard(db *sql.DB, shardID string) ([]int, error) {
+	rows, err := db.Query("SELECT server_id FROM mapt WHERE shard_id=?", shardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	var serverID int
 	serverIDs := []int{}
-	for row.Next() {
-		err := row.Scan(&serverID)
-		if err != nil {
-			log.Fatal(err)
+	for rows.Next() {
+		var serverID int
+		if err := rows.Scan(&serverID); err != nil {
+			return nil, err
 		}
 		serverIDs = append(serverIDs, serverID)
 	}
+	return serverIDs, rows.Err()
+}
 
-	return serverIDs
+// serverHoldsShard reports whether mapt places a shard on a server.
+func serverHoldsShard(shardID string, serverID int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM mapt WHERE shard_id = ? AND server_id = ?;", shardID, serverID).Scan(&count)
+	return count > 0, err
 }
 
-func checkHeartbeat(serverID int, serverDown chan<- int) {
-	for {
-		isPresent := false
-		for _, server := range serverIDs {
-			if server == serverID {
-				isPresent = true
-				break
-			}
+// serverIDs are the servers in the cluster. Handlers, the failure detector
+// and the reconciler all use them, so they are only read and changed through
+// the functions below.
+var (
+	serverIDsMutex sync.RWMutex
+	serverIDs      []int
+)
+
+// getServerIDs returns a copy of the servers in the cluster.
+func getServerIDs() []int {
+	serverIDsMutex.RLock()
+	defer serverIDsMutex.RUnlock()
+	return append([]int{}, serverIDs...)
+}
+
+// setServerIDs replaces the servers in the cluster.
+func setServerIDs(ids []int) {
+	serverIDsMutex.Lock()
+	defer serverIDsMutex.Unlock()
+	serverIDs = append([]int{}, ids...)
+}
+
+func addServerID(serverID int) {
+	serverIDsMutex.Lock()
+	defer serverIDsMutex.Unlock()
+	serverIDs = append(serverIDs, serverID)
+}
+
+func removeServerIDs(removed ...int) {
+	serverIDsMutex.Lock()
+	defer serverIDsMutex.Unlock()
+
+	kept := []int{}
+	for _, serverID := range serverIDs {
+		if !slices.Contains(removed, serverID) {
+			kept = append(kept, serverID)
 		}
-		if !isPresent {
+	}
+	serverIDs = kept
+}
+
+func isServer(serverID int) bool {
+	serverIDsMutex.RLock()
+	defer serverIDsMutex.RUnlock()
+	return slices.Contains(serverIDs, serverID)
+}
+
+// getExistingServerID parses the name of a server in the cluster.
+func getExistingServerID(serverName string) (int, error) {
+	serverID, err := getServerID(serverName)
+	if err != nil {
+		return 0, err
+	}
+	if !isServer(serverID) {
+		return 0, fmt.Errorf("no server %s", serverName)
+	}
+	return serverID, nil
+}
+
+// The load balancer's background work, heartbeat checks among it, runs in
+// goroutines started with goBackground, so that stopBackground can stop it
+// and wait until it has returned.
+var (
+	background     sync.WaitGroup
+	backgroundStop = make(chan struct{})
+)
+
+// goBackground runs work in a goroutine, passing it a channel that is closed
+// once it should stop.
+func goBackground(work func(stop <-chan struct{})) {
+	stop := backgroundStop
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		work(stop)
+	}()
+}
+
+// stopBackground stops the background work and waits for it to return.
+func stopBackground() {
+	close(backgroundStop)
+	background.Wait()
+}
+
+// HEARTBEAT_INTERVAL is how often a server's heartbeat is checked, unless a
+// request to it fails first.
+const HEARTBEAT_INTERVAL = 5 * time.Second
+
+var (
+	heartbeatChecksMutex sync.Mutex
+	heartbeatChecks      = map[int]chan struct{}{}
+)
+
+// heartbeatCheck returns the channel that wakes up the heartbeat check of a
+// server.
+func heartbeatCheck(serverID int) chan struct{} {
+	heartbeatChecksMutex.Lock()
+	defer heartbeatChecksMutex.Unlock()
+
+	check, ok := heartbeatChecks[serverID]
+	if !ok {
+		check = make(chan struct{}, 1)
+		heartbeatChecks[serverID] = check
+	}
+	return check
+}
+
+// suspectServer checks the heartbeat of a server right away, for when a
+// request to it failed. Only checkHeartbeat reports servers down, so a server
+// is never replaced twice.
+func suspectServer(serverID int) {
+	select {
+	case heartbeatCheck(serverID) <- struct{}{}:
+	default:
+	}
+}
+
+// watchServer checks the heartbeat of a server in the background until it
+// leaves the cluster or is reported down.
+func watchServer(serverID int) {
+	down := serverDown
+	goBackground(func(stop <-chan struct{}) {
+		checkHeartbeat(serverID, down, stop)
+	})
+}
+
+func checkHeartbeat(serverID int, serverDown chan<- int, stop <-chan struct{}) {
+	check := heartbeatCheck(serverID)
+	reportDown := func() {
+		select {
+		case serverDown <- serverID:
+		case <-stop:
+		}
+	}
+	for {
+		if !isServer(serverID) {
 			return
 		}
-		serverIP := getServerIP(fmt.Sprintf("Server%d", serverID))
-		if len(serverIP) == 0 {
-			fmt.Printf("Server%d is down!\n", serverID)
-			serverDown <- serverID
+		serverName := fmt.Sprintf("Server%d", serverID)
+		serverAddress := getServerAddress(serverName)
+		if len(serverAddress) == 0 {
+			heartbeatFailures.Inc(serverName)
+			slog.Warn("server is down", "server", serverName)
+			reportDown()
 			return
 		}
-		resp, err := http.Get("http://" + serverIP + ":" + fmt.Sprint(SERVER_PORT) + "/heartbeat")
+		resp, err := http.Get("http://" + serverAddress + "/heartbeat")
 		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Printf("Server%d is down!\n", serverID)
-			serverDown <- serverID
+			heartbeatFailures.Inc(serverName)
+			if err == nil {
+				resp.Body.Close()
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+			slog.Warn("server is down", "server", serverName, logging.Error(err))
+			reportDown()
+			return
+		}
+		resp.Body.Close()
+		select {
+		case <-time.After(HEARTBEAT_INTERVAL):
+		case <-check:
+		case <-stop:
 			return
 		}
-		time.Sleep(5 * time.Second)
 	}
 }
 
+// replaceServerInstance starts a new server in place of one that went down
+// and moves the down server's replicas to it. If the new server cannot be
+// started it is stopped again and the replacement retried a heartbeat
+// interval later.
 func replaceServerInstance(downServerID int) {
+	downServerName := fmt.Sprintf("Server%d", downServerID)
 	newServerID := getRandomID()
+	newServerName := fmt.Sprintf("Server%d", newServerID)
 
-	fmt.Printf("Restarting Server%d as Server%d\n", downServerID, newServerID)
-	spawnNewServerInstance(fmt.Sprintf("Server%d", newServerID), newServerID)
-
-	rows, err := db.Query("SELECT shard_id FROM mapt WHERE server_id=?", downServerID)
+	slog.Info("replacing server", "server", downServerName, "replacement", newServerName)
+	shardIDs, err := startReplacement(downServerID, newServerID)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("error starting replacement server", "server", downServerName, "replacement", newServerName, logging.Error(err))
+		if err := removeServerInstance(newServerName); err != nil {
+			slog.Warn("failed to stop server", "server", newServerName, logging.Error(err))
+		}
+		down := serverDown
+		goBackground(func(stop <-chan struct{}) {
+			select {
+			case <-time.After(HEARTBEAT_INTERVAL):
+			case <-stop:
+				return
+			}
+			if isServer(downServerID) {
+				select {
+				case down <- downServerID:
+				case <-stop:
+				}
+			}
+		})
+		return
 	}
 
-	shardIDs := []string{}
-	for rows.Next() {
-		var shardID string
-		err := rows.Scan(&shardID)
-		if err != nil {
-			log.Fatal(err)
+	for _, shardID := range shardIDs {
+		if err := moveReplicaToReplacement(shardID, downServerID, newServerID); err != nil {
+			slog.Error("error moving replica to replacement server", "shard", shardID, "server", newServerName, logging.Error(err))
 		}
-		shardIDs = append(shardIDs, shardID)
 	}
-	rows.Close()
 
-	configNewServerInstance(newServerID, shardIDs, schemaConfig)
+	if err := removeServerRecord(downServerID); err != nil {
+		slog.Error("error removing server record", "server", downServerName, logging.Error(err))
+	}
 
-	for _, shardID := range shardIDs {
-		shardTConfigs[shardID].mutex.Lock()
-		shardTConfigs[shardID].chm.RemoveServer(downServerID)
+	addServerID(newServerID)
+	removeServerIDs(downServerID)
 
-		payload := ServerCopyPayload{
-			Shards: []string{shardID},
-		}
-		payloadData, err := json.Marshal(payload)
-		if err != nil {
-			log.Println("Error marshaling JSON: ", err)
-		}
+	serverReplacements.Inc()
+	watchServer(newServerID)
+}
 
-		existingServerID := shardTConfigs[shardID].chm.GetServerForRequest(getRandomID())
+// startReplacement starts and configures the server replacing a down one, and
+// returns the shards it is to take over.
+func startReplacement(downServerID int, newServerID int) ([]string, error) {
+	if err := spawnNewServerInstance(fmt.Sprintf("Server%d", newServerID), newServerID); err != nil {
+		return nil, err
+	}
+	shardIDs, err := getShardIDsForServer(downServerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := configNewServerInstance(context.Background(), newServerID, shardIDs, schemaConfig); err != nil {
+		return nil, err
+	}
+	// the replacement takes over the weight of the server it replaces
+	weight, err := getServerWeight(downServerID)
+	if err != nil {
+		return nil, err
+	}
+	return shardIDs, addServerRecord(newServerID, weight)
+}
 
-		req, err := http.NewRequest("GET", "http://"+getServerIP(fmt.Sprintf("Server%d", existingServerID))+":"+fmt.Sprint(SERVER_PORT)+"/copy", bytes.NewBuffer(payloadData))
-		if err != nil {
-			log.Println("Error copying from Server:", err)
-		}
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error copying from Server:", err)
+// moveReplicaToReplacement moves the replica of a shard on a down server to
+// its replacement, electing a new primary first if the down server was the
+// primary, and brings the new replica up to date. A new replica that cannot
+// be brought up to date is copied again in the background until it is.
+func moveReplicaToReplacement(shardID string, downServerID int, newServerID int) error {
+	unlock, ok := lockShard(shardID)
+	if !ok {
+		return fmt.Errorf("no shard %s", shardID)
+	}
+	removeFromShardHashMap(shardID, downServerID)
+	// the shard cannot take writes until it has a live primary again
+	existingServerID, _, err := getShardPrimary(db, shardID)
+	if err == nil && existingServerID == downServerID {
+		if err := electPrimary(context.Background(), shardID, downServerID); err != nil {
+			slog.Warn("error electing primary", "shard", shardID, logging.Error(err))
 		}
+		existingServerID, _, err = getShardPrimary(db, shardID)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Println("Error reading response body:", err)
+	if existingServerID == -1 || existingServerID == downServerID {
+		slog.Warn("no replica left to copy from", "shard", shardID)
+		if unlock, ok = lockShard(shardID); !ok {
+			return fmt.Errorf("no shard %s", shardID)
 		}
-
-		var respData ServerCopyResponse
-		json.Unmarshal(body, &respData)
-		resp.Body.Close()
-
-		shardData := respData[shardID]
-
-		valid_idx := getValidIDx(db, shardID)
-		curr_idx := valid_idx - len(shardData)
-
-		payloadWrite := ServerWritePayload{
-			Shard:        shardID,
-			CurrentIndex: curr_idx,
-			Data:         shardData,
+		defer unlock()
+		if err := replaceShardReplica(shardID, downServerID, newServerID); err != nil {
+			return err
 		}
-		payloadData, err = json.Marshal(payloadWrite)
-		if err != nil {
-			log.Println("Error marshaling JSON: ", err)
+		if existingServerID == downServerID {
+			return electPrimary(context.Background(), shardID)
 		}
+		return nil
+	}
 
-		resp, err = http.Post("http://"+getServerIP(fmt.Sprintf("Server%d", newServerID))+":"+fmt.Sprint(SERVER_PORT)+"/write", "application/json", bytes.NewBuffer(payloadData))
-		if err != nil {
-			log.Println("Error writing to Server:", err)
-		}
+	err = placeReplacementReplica(shardID, existingServerID, downServerID, newServerID)
+	if err != nil && !errors.Is(err, errShardGone) && !errors.Is(err, errReplicasChanged) {
+		retryReplacementReplica(shardID, downServerID, newServerID)
+	}
+	return err
+}
 
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			log.Println("Error reading response body:", err)
-		}
+// placeReplacementReplica copies a shard from source to the server replacing
+// a down one and, once the copy has caught up with the primary, puts it in
+// place of the down server's replica. A copy that falls behind is kept out of
+// mapt and the shard's hash map, so nothing reads from it.
+func placeReplacementReplica(shardID string, sourceServerID int, downServerID int, newServerID int) error {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+
+	return copyReplica(shardID, sourceServerID, newServerID, func(int) error {
+		// from here on the primary passes changes on to the new replica
+		return replaceShardReplica(shardID, downServerID, newServerID)
+	})
+}
 
-		var respDataWrite ServerWriteResponse
-		json.Unmarshal(body, &respDataWrite)
-		resp.Body.Close()
+// retryReplacementReplica copies a shard to the server replacing a down one
+// again every HEARTBEAT_INTERVAL until the copy catches up, the shard is gone
+// or another move took the down server's place. If the new server leaves the
+// cluster first, the down server's replica is forgotten so the reconciler
+// makes up for it elsewhere.
+func retryReplacementReplica(shardID string, downServerID int, newServerID int) {
+	newServerName := fmt.Sprintf("Server%d", newServerID)
+	goBackground(func(stop <-chan struct{}) {
+		for {
+			select {
+			case <-time.After(HEARTBEAT_INTERVAL):
+			case <-stop:
+				return
+			}
 
-		if respDataWrite.CurrentIndex != valid_idx {
-			log.Println("Error writing to Server: Invalid Index")
+			if !isServer(newServerID) {
+				forgetShardReplica(shardID, downServerID)
+				return
+			}
+			primaryServerID, _, err := getShardPrimary(db, shardID)
+			if err == nil && primaryServerID == -1 {
+				err = fmt.Errorf("%s has no primary to copy from", shardID)
+			}
+			if err == nil {
+				err = placeReplacementReplica(shardID, primaryServerID, downServerID, newServerID)
+			}
+			if err == nil {
+				slog.Info("replacement replica caught up", "shard", shardID, "server", newServerName)
+				return
+			}
+			if errors.Is(err, errShardGone) || errors.Is(err, errReplicasChanged) {
+				slog.Warn("giving up on replacement replica", "shard", shardID, "server", newServerName, logging.Error(err))
+				return
+			}
+			slog.Warn("replacement replica is behind", "shard", shardID, "server", newServerName, logging.Error(err))
 		}
+	})
+}
 
-		shardTConfigs[shardID].chm.AddServer(newServerID)
-		shardTConfigs[shardID].mutex.Unlock()
+// forgetShardReplica removes the replica of a shard on a server from mapt.
+func forgetShardReplica(shardID string, serverID int) {
+	placementMutex.Lock()
+	defer placementMutex.Unlock()
+
+	if _, err := db.Exec("DELETE FROM mapt WHERE shard_id = ? AND server_id = ?;", shardID, serverID); err != nil {
+		slog.Error("error forgetting replica", "shard", shardID, "server", fmt.Sprintf("Server%d", serverID), logging.Error(err))
 	}
+}
 
-	_, err = db.Exec("UPDATE mapt SET server_id=? WHERE server_id=?", newServerID, downServerID)
+// replaceShardReplica moves a shard's replica from one server to another in
+// mapt and in the shard's hash map.
+func replaceShardReplica(shardID string, oldServerID int, newServerID int) error {
+	result, err := db.Exec("UPDATE mapt SET server_id=? WHERE server_id=? AND shard_id=?", newServerID, oldServerID, shardID)
 	if err != nil {
-		log.Println("Error updating mapt: ", err)
+		return err
 	}
-
-	newServerIDs := []int{}
-	for _, serverID := range serverIDs {
-		if serverID != downServerID {
-			newServerIDs = append(newServerIDs, serverID)
-		}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
 	}
-	newServerIDs = append(newServerIDs, newServerID)
-	serverIDs = newServerIDs
-
-	go checkHeartbeat(newServerID, serverDown)
+	if changed != 1 {
+		return fmt.Errorf("Server%d does not hold %s: %w", oldServerID, shardID, errReplicasChanged)
+	}
+	weight, err := getServerWeight(newServerID)
+	if err != nil {
+		return err
+	}
+	addToShardHashMap(shardID, newServerID, weight)
+	return nil
 }
 
 func monitorServers(stopSignal chan os.Signal) {
-	for _, server := range serverIDs {
-		go checkHeartbeat(server, serverDown)
+	for _, server := range getServerIDs() {
+		watchServer(server)
 	}
 
 	for {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// getServerWeight returns the capacity weight of a server, 1 unless it was
+// given another.
+func getServerWeight(serverID int) (int, error) {
+	var weight int
+	err := db.QueryRow("SELECT COALESCE(weight, 1) FROM servert WHERE server_id = ?;", serverID).Scan(&weight)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return max(weight, 1), nil
+}
+
+// getServerWeights checks the weights an /init or /add request gives the new
+// servers and returns the weight of each of them.
+func getServerWeights(rawWeights map[string]int, newServerIDs []int) (map[int]int, error) {
+	weights := map[int]int{}
+	for _, serverID := range newServerIDs {
+		weights[serverID] = 1
+	}
+	for serverName, weight := range rawWeights {
+		serverID, err := getServerID(serverName)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := weights[serverID]; !ok {
+			return nil, fmt.Errorf("%s is not a new server", serverName)
+		}
+		if weight < 1 {
+			return nil, fmt.Errorf("weight of %s must be at least 1, got %d", serverName, weight)
+		}
+		weights[serverID] = weight
+	}
+	return weights, nil
+}
+
+// setServerWeight changes the weight of a server in servert and in the hash
+// map of every shard it holds.
+func setServerWeight(serverID int, weight int) error {
+	_, err := db.Exec("UPDATE servert SET weight = ? WHERE server_id = ?;", weight, serverID)
+	if err != nil {
+		return err
+	}
+
+	shardIDs, err := getShardIDsForServer(serverID)
+	if err != nil {
+		return err
+	}
+	for _, shardID := range shardIDs {
+		unlock, ok := lockShard(shardID)
+		if !ok {
+			continue
+		}
+		config, _ := getShardTConfig(shardID)
+		config.chm.SetWeight(serverID, weight)
+		unlock()
+	}
+	return nil
+}
+
+func weightHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var req WeightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding request: %v", err))
+		return
+	}
+	serverID, err := getExistingServerID(req.Server)
+	if err != nil {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+	if req.Weight < 1 {
+		writeError(w, r, apierror.Errorf(apierror.BadRequest, "Weight must be at least 1, got %d", req.Weight))
+		return
+	}
+
+	if err := setServerWeight(serverID, req.Weight); err != nil {
+		writeError(w, r, errorFrom(err, "error setting server weight").WithServer(req.Server))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Set the weight of %s to %d", req.Server, req.Weight),
+		"status":  "success",
+	})
+}
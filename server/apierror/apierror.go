@@ -0,0 +1,157 @@
+// Package apierror is the error model of the HTTP APIs of the load balancer
+// and the shard servers. Every request that fails is answered with one JSON
+// object, the error envelope:
+//
+//	{"status": "failure", "code": "unavailable", "message": "...", "shard": "sh2", "server": "Server1"}
+//
+// The code says what went wrong and fixes the HTTP status of the response:
+//
+//	bad_request         400  the request is malformed or invalid
+//	not_found           404  the shard, server or transaction does not exist
+//	method_not_allowed  405  the endpoint does not take the method
+//	conflict            409  the request clashes with the state of the cluster
+//	index_conflict      409  a replica is at another index than expected
+//	term_conflict       409  the change comes from a replaced primary
+//	internal            500  the load balancer or server failed on its own
+//	server_error        502  a shard server failed the request
+//	unavailable         503  a shard, primary or enough replicas could not be reached
+//	timeout             504  the request ran out of time
+//
+// Only unavailable and timeout are retryable: the same request may succeed
+// once the cluster has recovered. shard and server name the shard and server
+// the error concerns, when there is one.
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type Code string
+
+const (
+	BadRequest       Code = "bad_request"
+	NotFound         Code = "not_found"
+	MethodNotAllowed Code = "method_not_allowed"
+	Conflict         Code = "conflict"
+	IndexConflict    Code = "index_conflict"
+	TermConflict     Code = "term_conflict"
+	Internal         Code = "internal"
+	ServerError      Code = "server_error"
+	Unavailable      Code = "unavailable"
+	Timeout          Code = "timeout"
+)
+
+// Status returns the HTTP status errors with the code are answered with.
+func (c Code) Status() int {
+	switch c {
+	case BadRequest:
+		return http.StatusBadRequest
+	case NotFound:
+		return http.StatusNotFound
+	case MethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case Conflict, IndexConflict, TermConflict:
+		return http.StatusConflict
+	case ServerError:
+		return http.StatusBadGateway
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Timeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Retryable reports whether a request that failed with the code may succeed
+// if sent again.
+func (c Code) Retryable() bool {
+	return c == Unavailable || c == Timeout
+}
+
+// CodeForStatus returns the code of an HTTP status, for errors that only come
+// with a status.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return BadRequest
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusBadGateway:
+		return ServerError
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return Timeout
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return Internal
+}
+
+// Error is the error envelope.
+type Error struct {
+	Status  string `json:"status"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Shard   string `json:"shard,omitempty"`
+	Server  string `json:"server,omitempty"`
+
+	// CurrentIndex and CurrentTerm are where the shard is at, on
+	// index_conflict and term_conflict.
+	CurrentIndex *int `json:"current_idx,omitempty"`
+	CurrentTerm  *int `json:"current_term,omitempty"`
+	// Errors is the error of each shard a read failed on.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Errorf returns an error with a code and a formatted message.
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Status: "failure", Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithShard sets the shard the error concerns and returns e.
+func (e *Error) WithShard(shard string) *Error {
+	e.Shard = shard
+	return e
+}
+
+// WithServer sets the server the error concerns and returns e.
+func (e *Error) WithServer(server string) *Error {
+	e.Server = server
+	return e
+}
+
+// Write answers a request with e.
+func Write(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Code.Status())
+	json.NewEncoder(w).Encode(e)
+}
+
+// Read returns the error a response with a status other than 200 carries. A
+// body that is not an error envelope becomes the message of an error with the
+// code of the status.
+func Read(resp *http.Response) *Error {
+	body, _ := io.ReadAll(resp.Body)
+	var e Error
+	if err := json.Unmarshal(body, &e); err == nil && e.Code != "" {
+		e.Status = "failure"
+		return &e
+	}
+	return &Error{Status: "failure", Code: CodeForStatus(resp.StatusCode), Message: string(bytes.TrimSpace(body))}
+}
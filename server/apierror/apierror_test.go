@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	idx := 3
+	sent := Errorf(IndexConflict, "expected index %d but shard is at %d", 1, idx).WithShard("sh1").WithServer("Server2")
+	sent.CurrentIndex = &idx
+
+	recorder := httptest.NewRecorder()
+	Write(recorder, sent)
+	resp := recorder.Result()
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got status %d and content type %q, want 409 and JSON", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	got := Read(resp)
+	if got.Status != "failure" || got.Code != IndexConflict || got.Message != sent.Message || got.Shard != "sh1" || got.Server != "Server2" {
+		t.Errorf("got %+v, want %+v", got, sent)
+	}
+	if got.CurrentIndex == nil || *got.CurrentIndex != 3 || got.CurrentTerm != nil {
+		t.Errorf("got current_idx %v and current_term %v, want 3 and none", got.CurrentIndex, got.CurrentTerm)
+	}
+
+	// a body that is not an envelope, from a proxy say, keeps its status
+	recorder = httptest.NewRecorder()
+	http.Error(recorder, "upstream down", http.StatusServiceUnavailable)
+	got = Read(recorder.Result())
+	if got.Code != Unavailable || got.Message != "upstream down" {
+		t.Errorf("got code %q and message %q, want unavailable and the body", got.Code, got.Message)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	for _, code := range []Code{BadRequest, NotFound, MethodNotAllowed, Conflict, Internal, ServerError, Unavailable, Timeout} {
+		if got := CodeForStatus(code.Status()); got != code {
+			t.Errorf("CodeForStatus(%d) = %q, want %q", code.Status(), got, code)
+		}
+	}
+	for code, status := range map[Code]int{IndexConflict: 409, TermConflict: 409, "unknown": 500} {
+		if got := code.Status(); got != status {
+			t.Errorf("%q.Status() = %d, want %d", code, got, status)
+		}
+	}
+	if CodeForStatus(http.StatusTeapot) != BadRequest || CodeForStatus(http.StatusNotImplemented) != Internal {
+		t.Error("other 4xx statuses are not bad_request or other 5xx are not internal")
+	}
+
+	for _, code := range []Code{BadRequest, NotFound, MethodNotAllowed, Conflict, IndexConflict, TermConflict, Internal, ServerError} {
+		if code.Retryable() {
+			t.Errorf("%q is retryable", code)
+		}
+	}
+	if !Unavailable.Retryable() || !Timeout.Retryable() {
+		t.Error("unavailable or timeout is not retryable")
+	}
+}
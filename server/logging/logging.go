@@ -0,0 +1,71 @@
+// Package logging sets up the structured logs of the load balancer and the
+// shard servers: one JSON object per line, at the level LOG_LEVEL asks for,
+// with the request and trace ids of the request a line was logged for.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// contextHandler adds the ids of the request in the context of a record to
+// it.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		sc := span.Context()
+		r.AddAttrs(slog.String("request_id", sc.RequestID), slog.String("trace_id", sc.TraceID.String()))
+	} else if requestID := tracing.RequestID(ctx); requestID != "" {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel reads a level name, debug, info, warn or error, defaulting to
+// info.
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// New returns a logger writing JSON lines to w, tagged with the service that
+// logged them.
+func New(w io.Writer, level slog.Level, service string) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	return slog.New(contextHandler{handler}).With("service", service)
+}
+
+// Setup makes a logger writing to stderr at LOG_LEVEL the default of slog,
+// which the log package also writes through.
+func Setup(service string, attrs ...any) {
+	slog.SetDefault(New(os.Stderr, ParseLevel(os.Getenv("LOG_LEVEL")), service).With(attrs...))
+}
+
+// Error returns the attribute a failure is logged under.
+func Error(err error) slog.Attr {
+	return slog.Any("error", err)
+}
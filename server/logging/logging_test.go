@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// lines decodes the JSON lines a logger wrote.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	records := []map[string]interface{}{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo, "galaxydb-test").With("server", "Server1")
+
+	tracer := tracing.NewTracer("galaxydb-test", "")
+	ctx, span := tracer.Start(context.Background(), "request", tracing.SpanKindServer)
+	logger.ErrorContext(ctx, "error writing to shard", "shard", "sh1", Error(errors.New("disk full")))
+	span.End()
+
+	// a request id sent without a trace is still logged
+	remote := tracing.ContextWithRemote(context.Background(), tracing.SpanContext{RequestID: "client-42"})
+	logger.InfoContext(remote, "request served")
+	logger.Info("server started")
+
+	records := lines(t, &buf)
+	if len(records) != 3 {
+		t.Fatalf("got %d log lines, want 3", len(records))
+	}
+
+	want := map[string]interface{}{
+		"level":      "ERROR",
+		"msg":        "error writing to shard",
+		"service":    "galaxydb-test",
+		"server":     "Server1",
+		"shard":      "sh1",
+		"error":      "disk full",
+		"request_id": span.Context().RequestID,
+		"trace_id":   span.Context().TraceID.String(),
+	}
+	for key, value := range want {
+		if records[0][key] != value {
+			t.Errorf("%s: got %v, want %v", key, records[0][key], value)
+		}
+	}
+
+	if records[1]["request_id"] != "client-42" || records[1]["trace_id"] != nil {
+		t.Errorf("got request_id %v and trace_id %v, want client-42 and none", records[1]["request_id"], records[1]["trace_id"])
+	}
+	if _, ok := records[2]["request_id"]; ok {
+		t.Error("a line logged outside of a request has a request_id")
+	}
+}
+
+func TestLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"":      slog.LevelInfo,
+		"loud":  slog.LevelInfo,
+	} {
+		if got := ParseLevel(name); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelWarn, "galaxydb-test")
+	logger.Debug("hedging read")
+	logger.Info("request served")
+	logger.Warn("server is down")
+
+	records := lines(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "server is down" {
+		t.Errorf("got %v at level warn, want only the warning", records)
+	}
+}
@@ -0,0 +1,294 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format. Both the load balancer and the shard
+// servers expose theirs at /metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds the metrics of one process, or of one server in tests that
+// run several.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+// series is one combination of label values of a metric.
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+}
+
+// family is a metric with its label names and series.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newFamily(name string, help string, kind string, labelNames []string) *family {
+	return &family{name: name, help: help, kind: kind, labelNames: labelNames, series: map[string]*series{}}
+}
+
+// get returns the series for label values, creating it. Callers hold the
+// mutex.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s: got %d label values for %d labels", f.name, len(labelValues), len(f.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series in order of their label values, so the output
+// is stable. Callers hold the mutex.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	all := make([]*series, len(keys))
+	for i, key := range keys {
+		all[i] = f.series[key]
+	}
+	return all
+}
+
+func (f *family) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats label pairs, with extra ones such as le appended.
+func labels(names []string, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, per combination of label values.
+type Counter struct {
+	family *family
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labelNames)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.family.mutex.Lock()
+	defer c.family.mutex.Unlock()
+	c.family.get(labelValues).value += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	f := c.family
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.writeHeader(w)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labels(f.labelNames, s.labelValues), formatFloat(s.value))
+	}
+}
+
+// Histogram counts observations, such as latencies, into buckets.
+type Histogram struct {
+	family  *family
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{family: newFamily(name, help, "histogram", labelNames), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.family.mutex.Lock()
+	defer h.family.mutex.Unlock()
+
+	s := h.family.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	f := h.family
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.writeHeader(w)
+	for _, s := range f.sorted() {
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labelNames, s.labelValues, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labelNames, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels(f.labelNames, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels(f.labelNames, s.labelValues), s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose values are read when the metrics are served.
+// collect calls set once per combination of label values.
+type GaugeFunc struct {
+	family  *family
+	collect func(set func(v float64, labelValues ...string))
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, labelNames []string, collect func(set func(v float64, labelValues ...string))) *GaugeFunc {
+	g := &GaugeFunc{family: newFamily(name, help, "gauge", labelNames), collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	f := g.family
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.series = map[string]*series{}
+	g.collect(func(v float64, labelValues ...string) {
+		f.get(labelValues).value = v
+	})
+	f.writeHeader(w)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labels(f.labelNames, s.labelValues), formatFloat(s.value))
+	}
+}
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// HTTPMetrics count the requests a server serves and how long they take.
+type HTTPMetrics struct {
+	requests  *Counter
+	durations *Histogram
+}
+
+// NewHTTPMetrics registers prefix_http_requests_total and
+// prefix_http_request_duration_seconds.
+func (r *Registry) NewHTTPMetrics(prefix string) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests:  r.NewCounter(prefix+"_http_requests_total", "HTTP requests served, by endpoint, method and status code.", "endpoint", "method", "code"),
+		durations: r.NewHistogram(prefix+"_http_request_duration_seconds", "Time taken to serve HTTP requests, by endpoint.", DefaultBuckets, "endpoint"),
+	}
+}
+
+// Instrument records the requests mux serves. Requests are labelled with the
+// pattern they matched, so unknown paths do not each get their own series.
+func (m *HTTPMetrics) Instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, endpoint := mux.Handler(req)
+		if endpoint == "" {
+			endpoint = "other"
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(recorder, req)
+		m.durations.Since(start, endpoint)
+		m.requests.Inc(endpoint, req.Method, strconv.Itoa(recorder.status))
+	})
+}
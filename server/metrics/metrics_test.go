@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests.", "path")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	r.NewGaugeFunc("slots", "Slots.", []string{"shard", "server"}, func(set func(float64, ...string)) {
+		set(3, "sh2", "1")
+		set(5, "sh1", `a"b`)
+	})
+
+	requests.Inc("/b")
+	requests.Add(2, "/a")
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	var out strings.Builder
+	r.Write(&out)
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{path="/a"} 2
+requests_total{path="/b"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.55
+latency_seconds_count 3
+# HELP slots Slots.
+# TYPE slots gauge
+slots{shard="sh1",server="a\"b"} 5
+slots{shard="sh2",server="1"} 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,39 @@
+// Package querylang is what the load balancer and the shard servers agree on
+// about /query and /aggregate: the operators a filter may use, the functions
+// an aggregate may compute and the column an aggregate ends up in. The load
+// balancer checks requests with it before fanning them out, and the servers
+// again before building their SQL.
+package querylang
+
+import "strings"
+
+var ops = map[string]bool{
+	"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "LIKE": true, "IN": true,
+}
+
+var aggregateFuncs = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+}
+
+// IsOp reports whether a filter may use op, in any case.
+func IsOp(op string) bool {
+	return ops[strings.ToUpper(op)]
+}
+
+// IsAggregateFunc reports whether an aggregate may compute fn, in any case.
+func IsAggregateFunc(fn string) bool {
+	return aggregateFuncs[strings.ToUpper(fn)]
+}
+
+// AggregateName returns the column an aggregate of fn over column ends up in:
+// as if set, else the function and the column, as in sum_Stud_marks, or only
+// the function for a count of rows.
+func AggregateName(fn string, column string, as string) string {
+	if as != "" {
+		return as
+	}
+	if column == "" {
+		return strings.ToLower(fn)
+	}
+	return strings.ToLower(fn) + "_" + column
+}
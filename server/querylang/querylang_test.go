@@ -0,0 +1,34 @@
+package querylang
+
+import "testing"
+
+func TestIsOp(t *testing.T) {
+	for op, want := range map[string]bool{"=": true, ">=": true, "like": true, "In": true, "<>": false, "BETWEEN": false, "": false} {
+		if got := IsOp(op); got != want {
+			t.Errorf("IsOp(%q) = %v, want %v", op, got, want)
+		}
+	}
+}
+
+func TestIsAggregateFunc(t *testing.T) {
+	for fn, want := range map[string]bool{"COUNT": true, "avg": true, "Max": true, "MEDIAN": false, "": false} {
+		if got := IsAggregateFunc(fn); got != want {
+			t.Errorf("IsAggregateFunc(%q) = %v, want %v", fn, got, want)
+		}
+	}
+}
+
+func TestAggregateName(t *testing.T) {
+	for _, test := range []struct {
+		fn, column, as string
+		want           string
+	}{
+		{"COUNT", "", "", "count"},
+		{"SUM", "Stud_marks", "", "sum_Stud_marks"},
+		{"avg", "Stud_marks", "mean", "mean"},
+	} {
+		if got := AggregateName(test.fn, test.column, test.as); got != test.want {
+			t.Errorf("AggregateName(%q, %q, %q) = %q, want %q", test.fn, test.column, test.as, got, test.want)
+		}
+	}
+}
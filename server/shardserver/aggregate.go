@@ -0,0 +1,146 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/querylang"
+)
+
+// name returns the column an aggregate ends up in.
+func (a Aggregate) name() string {
+	return querylang.AggregateName(a.Func, a.Column, a.As)
+}
+
+// buildAggregate returns the SQL for an aggregation on a shard and its
+// arguments.
+func (sc *schema) buildAggregate(req AggregateRequest) (string, []interface{}, error) {
+	if len(req.Aggregates) == 0 {
+		return "", nil, errors.New("no aggregates")
+	}
+
+	names := map[string]bool{}
+	columns := []string{}
+	for _, column := range req.GroupBy {
+		if _, ok := sc.dtypeOf(column); !ok {
+			return "", nil, fmt.Errorf("unknown column %q", column)
+		}
+		if names[column] {
+			return "", nil, fmt.Errorf("duplicate group column %q", column)
+		}
+		names[column] = true
+		columns = append(columns, column)
+	}
+	for _, a := range req.Aggregates {
+		fn := strings.ToUpper(a.Func)
+		if !querylang.IsAggregateFunc(fn) {
+			return "", nil, fmt.Errorf("unknown aggregate function %q", a.Func)
+		}
+		argument := "*"
+		if a.Column != "" {
+			kind, ok := sc.dtypeOf(a.Column)
+			if !ok {
+				return "", nil, fmt.Errorf("unknown column %q", a.Column)
+			}
+			if (fn == "SUM" || fn == "AVG") && kind == kindText {
+				return "", nil, fmt.Errorf("%s of text column %q", fn, a.Column)
+			}
+			argument = a.Column
+		} else if fn != "COUNT" {
+			return "", nil, fmt.Errorf("%s needs a column", fn)
+		}
+
+		name := a.name()
+		if !identifierRegexp.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid aggregate name %q", name)
+		}
+		if names[name] {
+			return "", nil, fmt.Errorf("duplicate column %q", name)
+		}
+		names[name] = true
+		columns = append(columns, fmt.Sprintf("%s(%s) AS %s", fn, argument, name))
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	for _, p := range req.Where {
+		condition, conditionArgs, err := sc.predicateSQL(p)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), req.Shard)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if len(req.GroupBy) > 0 {
+		groups := strings.Join(req.GroupBy, ", ")
+		query += " GROUP BY " + groups + " ORDER BY " + groups
+	}
+	return query, args, nil
+}
+
+func (s *Server) aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody AggregateRequest
+	if err := decodeJSON(r, &reqBody); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	shard := reqBody.Shard
+	if !validIdentifier(shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+		return
+	}
+	query, args, err := sc.buildAggregate(reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid aggregation: %v", err))
+		return
+	}
+
+	// as for /read, the aggregates and the index they are at are read in
+	// one transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error aggregating shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	defer tx.Rollback()
+
+	data, err := fetchDataFromShard(tx, query, args...)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error aggregating shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	idx, err := lastIndex(tx, shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+
+	s.metrics.shardReads.Inc(shard)
+	response := AggregateResponse{
+		Data:       data,
+		CurrentIdx: idx,
+		Status:     "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,55 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	server := newTestServer(t)
+	call(t, server, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{
+		{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "a"}, {"id": 4},
+	}}, http.StatusOK, nil)
+
+	for _, test := range []struct {
+		req  AggregateRequest
+		want string
+	}{
+		{
+			AggregateRequest{Aggregates: []Aggregate{{Func: "count"}, {Func: "count", Column: "name"}, {Func: "avg", Column: "id"}}},
+			`[{"avg_id":2.5,"count":4,"count_name":3}]`,
+		},
+		{
+			AggregateRequest{Aggregates: []Aggregate{{Func: "SUM", Column: "id", As: "total"}, {Func: "MAX", Column: "id"}}, GroupBy: []string{"name"}},
+			`[{"max_id":4,"name":null,"total":4},{"max_id":3,"name":"a","total":4},{"max_id":2,"name":"b","total":2}]`,
+		},
+		{
+			AggregateRequest{Aggregates: []Aggregate{{Func: "MIN", Column: "name"}}, Where: []Predicate{{Column: "id", Op: ">", Value: 1}}},
+			`[{"min_name":"a"}]`,
+		},
+	} {
+		test.req.Shard = "sh1"
+		var resp AggregateResponse
+		call(t, server, "POST", "/aggregate", test.req, http.StatusOK, &resp)
+		data, err := json.Marshal(resp.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("aggregate %+v: got %s, want %s", test.req, data, test.want)
+		}
+	}
+
+	for _, bad := range []AggregateRequest{
+		{},
+		{Aggregates: []Aggregate{{Func: "MEDIAN", Column: "id"}}},
+		{Aggregates: []Aggregate{{Func: "SUM"}}},
+		{Aggregates: []Aggregate{{Func: "SUM", Column: "name"}}},
+		{Aggregates: []Aggregate{{Func: "COUNT", As: "id"}}, GroupBy: []string{"id"}},
+		{Aggregates: []Aggregate{{Func: "COUNT"}}, GroupBy: []string{"age"}},
+	} {
+		bad.Shard = "sh1"
+		call(t, server, "POST", "/aggregate", bad, http.StatusBadRequest, nil)
+	}
+}
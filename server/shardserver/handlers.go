@@ -0,0 +1,451 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+)
+
+func (s *Server) heartbeatEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+}
+
+func (s *Server) configEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	// Decode the request body
+	var reqBody ConfigPayload
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+
+	if err := reqBody.Schema.validate(); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid schema: %v", err))
+		return
+	}
+	for _, shard := range reqBody.Shards {
+		if !validIdentifier(shard) {
+			s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.schema != nil && (s.schema.selectColumns() != reqBody.Schema.selectColumns() || fmt.Sprint(s.schema.Dtypes) != fmt.Sprint(reqBody.Schema.Dtypes)) {
+		s.writeError(w, r, apierror.Errorf(apierror.Conflict, "Server already configured with a different schema"))
+		return
+	}
+
+	var resMsg string
+	serverId := fmt.Sprintf("Server%s", s.id)
+	numberShards := len(reqBody.Shards)
+	for i, shard := range reqBody.Shards {
+		resMsg += fmt.Sprintf("%s:%s", serverId, shard)
+		if i == numberShards-1 {
+			resMsg += " configured"
+		} else {
+			resMsg += ", "
+		}
+	}
+
+	// initialize the shard tables in server database
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error creating tables: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	for _, shard := range reqBody.Shards {
+		_, err = tx.Exec(reqBody.Schema.createTableQuery(shard))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error creating shard table", "shard", shard, logging.Error(err))
+			s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error creating table for %s: %v", shard, err).WithShard(shard))
+			return
+		}
+	}
+	if err = saveSchema(tx, &reqBody.Schema); err != nil {
+		slog.ErrorContext(r.Context(), "error saving schema", logging.Error(err))
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error saving schema: %v", err))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		slog.ErrorContext(r.Context(), "error creating shard tables", logging.Error(err))
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error creating tables: %v", err))
+		return
+	}
+	s.schema = &reqBody.Schema
+	slog.InfoContext(r.Context(), "shards configured", "shards", reqBody.Shards)
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": resMsg,
+		"status":  "success",
+	})
+}
+
+// function to execute the query and return data from the shard
+func fetchDataFromShard(q queryer, query string, args ...interface{}) ([]Row, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	data := []Row{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err := rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := make(Row, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				entry[col] = string(b)
+			} else {
+				entry[col] = values[i]
+			}
+		}
+		data = append(data, entry)
+	}
+
+	return data, rows.Err()
+}
+
+// getSchema returns the configured schema, writing an error response if the
+// server has not been configured yet.
+func (s *Server) getSchema(w http.ResponseWriter, r *http.Request) *schema {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.schema == nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Conflict, "Server is not configured"))
+	}
+	return s.schema
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (s *Server) copyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	// Decode the request parameters
+	var reqBody CopyRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+
+	resp := make(map[string]interface{})
+
+	for _, shard := range reqBody.Shards {
+		if !validIdentifier(shard) {
+			s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+			return
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s", sc.selectColumns(), shard)
+		data, err := fetchDataFromShard(s.db, query)
+		if err != nil {
+			s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error fetching data from shard %s: %v", shard, err).WithShard(shard))
+			return
+		}
+		resp[shard] = data
+	}
+	resp["status"] = "success"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeEntries checks rows against the schema and turns them into the log
+// entries that insert them.
+func writeEntries(sc *schema, rows []Row) ([]LogEntry, error) {
+	entries := []LogEntry{}
+	for i, entry := range rows {
+		row, err := sc.convertRow(entry, true)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid data entry %d: %v", i, err)
+		}
+		key, ok := row[sc.keyColumn()].(int64)
+		if !ok {
+			return nil, fmt.Errorf("Invalid data entry %d: shard key must be an integer", i)
+		}
+		entries = append(entries, LogEntry{Op: OpWrite, Key: key, Data: row})
+	}
+	return entries, nil
+}
+
+func (s *Server) writeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody WriteRequest
+	err := decodeJSON(r, &reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	if !validIdentifier(reqBody.Shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+	entries, err := writeEntries(sc, reqBody.Data)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+
+	resp, err := s.commit(r.Context(), sc, reqBody.Shard, reqBody.Term, reqBody.CurrIndex, reqBody.Replicas, reqBody.MinAcks, entries)
+	if err != nil {
+		s.writeApplyError(w, r, reqBody.Shard, err, "Error writing data to shard %s", reqBody.Shard)
+		return
+	}
+	resp.Message = "Data entries added"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) readHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody ReadRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	shard := reqBody.Shard
+	if !validIdentifier(shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+		return
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s BETWEEN ? AND ?", sc.selectColumns(), shard, sc.keyColumn())
+	// the rows and the index they are at are read in one transaction, so the
+	// load balancer can tell how fresh they are
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading data from shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	defer tx.Rollback()
+
+	data, err := fetchDataFromShard(tx, query, reqBody.StudID.Low, reqBody.StudID.High)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading data from shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	idx, err := lastIndex(tx, shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+
+	s.metrics.shardReads.Inc(shard)
+	response := ReadResponse{
+		Data:       data,
+		CurrentIdx: idx,
+		Status:     "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) updateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+	var reqBody UpdateRequest
+	err := decodeJSON(r, &reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	shard := reqBody.Shard
+	if !validIdentifier(shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+		return
+	}
+	data, err := sc.convertRow(reqBody.Data, false)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid data entry: %v", err))
+		return
+	}
+
+	// the shard key picks the row and the shard, so it cannot be changed here
+	key := sc.keyColumn()
+	if value, ok := data[key]; ok {
+		if value != int64(reqBody.StudID) {
+			s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Cannot change shard key column %s", key))
+			return
+		}
+		delete(data, key)
+	}
+
+	resp, err := s.commit(r.Context(), sc, shard, reqBody.Term, reqBody.CurrIndex, reqBody.Replicas, reqBody.MinAcks, []LogEntry{{Op: OpUpdate, Key: int64(reqBody.StudID), Data: data}})
+	if err != nil {
+		s.writeApplyError(w, r, shard, err, "Error updating data in shard %s for Stud_id %d", shard, reqBody.StudID)
+		return
+	}
+	resp.Message = fmt.Sprintf("Data entry for Stud_id:%d updated", reqBody.StudID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+	var reqBody DeleteRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	shard := reqBody.Shard
+	if !validIdentifier(shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+		return
+	}
+	entry := LogEntry{Op: OpDelete, Key: int64(reqBody.StudID)}
+	if reqBody.High != nil {
+		entry = LogEntry{Op: OpDeleteRange, Key: int64(reqBody.StudID), High: int64(*reqBody.High)}
+	}
+	resp, err := s.commit(r.Context(), sc, shard, reqBody.Term, reqBody.CurrIndex, reqBody.Replicas, reqBody.MinAcks, []LogEntry{entry})
+	if err != nil {
+		s.writeApplyError(w, r, shard, err, "Error deleting data in shard %s for Stud_id %d", shard, reqBody.StudID)
+		return
+	}
+	resp.Message = fmt.Sprintf("Data entry with Stud_id:%d removed", reqBody.StudID)
+	if reqBody.High != nil {
+		resp.Message = fmt.Sprintf("Data entries with Stud_id from %d to %d removed", reqBody.StudID, *reqBody.High-1)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// dropHandler removes shards that were moved off the server, along with their
+// logs, terms and prepared transactions.
+func (s *Server) dropHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+	var reqBody DropRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	for _, shard := range reqBody.Shards {
+		if !validIdentifier(shard) {
+			s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+			return
+		}
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error dropping shards: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	for _, shard := range reqBody.Shards {
+		if _, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", shard)); err != nil {
+			s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error dropping shard %s: %v", shard, err).WithShard(shard))
+			return
+		}
+		for _, query := range []string{
+			"DELETE FROM galaxy_log WHERE shard = ?",
+			"DELETE FROM galaxy_terms WHERE shard = ?",
+			"DELETE FROM galaxy_prepared WHERE shard = ?",
+		} {
+			if _, err := tx.Exec(query, shard); err != nil {
+				s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error dropping shard %s: %v", shard, err).WithShard(shard))
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error dropping shards: %v", err))
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["message"] = fmt.Sprintf("Server%s dropped %v", s.id, reqBody.Shards)
+	resp["status"] = "success"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,322 @@
+package shardserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// Every change to a shard is appended to galaxy_log in the same transaction
+// that applies it. Entries are numbered per shard from 1 and the number of the
+// last one is the shard's index: the curr_idx a request expects to find and
+// the current_idx it leaves behind. A replica that is behind asks another one
+// for the entries after its own index and replays them.
+const initLogTable = `CREATE TABLE IF NOT EXISTS galaxy_log (
+						shard TEXT,
+						seq INTEGER,
+						op TEXT,
+						key INTEGER,
+						high INTEGER,
+						data TEXT,
+						PRIMARY KEY (shard, seq)
+					)`
+
+const (
+	OpWrite  = "write"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	// OpDeleteRange deletes the rows with shard keys from Key up to, but not
+	// including, High
+	OpDeleteRange = "delete_range"
+
+	defaultLogLimit = 1000
+)
+
+type LogEntry struct {
+	Seq  int    `json:"seq"`
+	Op   string `json:"op"`
+	Key  int64  `json:"key"`
+	High int64  `json:"high,omitempty"`
+	Data Row    `json:"data,omitempty"`
+}
+
+type LogRequest struct {
+	Shard string `json:"shard"`
+	After int    `json:"after"`
+	Limit int    `json:"limit"`
+}
+
+type LogResponse struct {
+	Entries    []LogEntry `json:"entries"`
+	CurrentIdx int        `json:"current_idx"`
+	Status     string     `json:"status"`
+}
+
+type ReplayRequest struct {
+	Shard   string     `json:"shard"`
+	Term    int        `json:"term"`
+	Entries []LogEntry `json:"entries"`
+}
+
+// indexConflictError is returned when a request expects a shard index other
+// than the one the replica is at.
+type indexConflictError struct {
+	expected int
+	current  int
+}
+
+func (e *indexConflictError) Error() string {
+	return fmt.Sprintf("expected index %d but shard is at %d", e.expected, e.current)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+func lastIndex(q queryer, shard string) (int, error) {
+	var idx int
+	err := q.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM galaxy_log WHERE shard = ?", shard).Scan(&idx)
+	return idx, err
+}
+
+func applyEntry(tx *sql.Tx, sc *schema, shard string, entry LogEntry) error {
+	switch entry.Op {
+	case OpWrite:
+		columns := []string{}
+		placeholders := []string{}
+		values := []interface{}{}
+		for col, value := range entry.Data {
+			columns = append(columns, col)
+			placeholders = append(placeholders, "?")
+			values = append(values, value)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", shard, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+		_, err := tx.Exec(query, values...)
+		return err
+	case OpUpdate:
+		assignments := []string{}
+		values := []interface{}{}
+		for col, value := range entry.Data {
+			assignments = append(assignments, col+" = ?")
+			values = append(values, value)
+		}
+		if len(assignments) == 0 {
+			return nil
+		}
+
+		query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", shard, strings.Join(assignments, ", "), sc.keyColumn())
+		_, err := tx.Exec(query, append(values, entry.Key)...)
+		return err
+	case OpDelete:
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", shard, sc.keyColumn())
+		_, err := tx.Exec(query, entry.Key)
+		return err
+	case OpDeleteRange:
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s >= ? AND %s < ?", shard, sc.keyColumn(), sc.keyColumn())
+		_, err := tx.Exec(query, entry.Key, entry.High)
+		return err
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Op)
+	}
+}
+
+// applyEntries applies entries to a shard that is expected to be at index
+// currIdx and logs them as the entries that follow it, numbering them. It
+// returns the new index of the shard. term and primary tag the change for
+// checkTerm.
+func (s *Server) applyEntries(sc *schema, shard string, term int, primary bool, currIdx int, entries []LogEntry) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTerm(tx, shard, term, primary); err != nil {
+		return 0, err
+	}
+
+	idx, err := lastIndex(tx, shard)
+	if err != nil {
+		return 0, err
+	}
+	if idx != currIdx {
+		return 0, &indexConflictError{expected: currIdx, current: idx}
+	}
+
+	for i, entry := range entries {
+		if err := applyEntry(tx, sc, shard, entry); err != nil {
+			return 0, err
+		}
+
+		idx++
+		entries[i].Seq = idx
+		var data interface{}
+		if entry.Data != nil {
+			encoded, err := json.Marshal(entry.Data)
+			if err != nil {
+				return 0, err
+			}
+			data = string(encoded)
+		}
+		var high interface{}
+		if entry.Op == OpDeleteRange {
+			high = entry.High
+		}
+		_, err = tx.Exec("INSERT INTO galaxy_log (shard, seq, op, key, high, data) VALUES (?, ?, ?, ?, ?, ?)", shard, idx, entry.Op, entry.Key, high, data)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.metrics.shardWrites.Add(float64(len(entries)), shard)
+	return idx, nil
+}
+
+func (s *Server) readLog(shard string, after int, limit int) ([]LogEntry, error) {
+	rows, err := s.db.Query("SELECT seq, op, key, high, data FROM galaxy_log WHERE shard = ? AND seq > ? ORDER BY seq LIMIT ?", shard, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LogEntry{}
+	for rows.Next() {
+		var entry LogEntry
+		var high sql.NullInt64
+		var data sql.NullString
+		if err := rows.Scan(&entry.Seq, &entry.Op, &entry.Key, &high, &data); err != nil {
+			return nil, err
+		}
+		entry.High = high.Int64
+		if data.Valid {
+			decoder := json.NewDecoder(strings.NewReader(data.String))
+			decoder.UseNumber()
+			if err := decoder.Decode(&entry.Data); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// apiError tells the caller which index the shard is really at, so it can
+// bring the replica up to date.
+func (e *indexConflictError) apiError(shard string) *apierror.Error {
+	apiErr := apierror.Errorf(apierror.IndexConflict, "%v", e).WithShard(shard)
+	apiErr.CurrentIndex = &e.current
+	return apiErr
+}
+
+func (s *Server) logHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody LogRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	if reqBody.Limit <= 0 {
+		reqBody.Limit = defaultLogLimit
+	}
+
+	entries, err := s.readLog(reqBody.Shard, reqBody.After, reqBody.Limit)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", reqBody.Shard, err).WithShard(reqBody.Shard))
+		return
+	}
+	idx, err := lastIndex(s.db, reqBody.Shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", reqBody.Shard, err).WithShard(reqBody.Shard))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LogResponse{
+		Entries:    entries,
+		CurrentIdx: idx,
+		Status:     "success",
+	})
+}
+
+// replayHandler applies entries fetched from another replica's log. Entries
+// the replica already has are skipped, so a batch can safely be replayed
+// twice, but there must be no gap before the first new one.
+func (s *Server) replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody ReplayRequest
+	err := decodeJSON(r, &reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	if !validIdentifier(reqBody.Shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+
+	idx, err := lastIndex(s.db, reqBody.Shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", reqBody.Shard, err).WithShard(reqBody.Shard))
+		return
+	}
+
+	entries := []LogEntry{}
+	for _, entry := range reqBody.Entries {
+		if entry.Seq <= idx {
+			continue
+		}
+		if entry.Seq != idx+len(entries)+1 {
+			s.writeError(w, r, (&indexConflictError{expected: entry.Seq - 1, current: idx}).apiError(reqBody.Shard))
+			return
+		}
+		if entry.Data != nil {
+			entry.Data, err = sc.convertRow(entry.Data, entry.Op == OpWrite)
+			if err != nil {
+				s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid log entry %d: %v", entry.Seq, err))
+				return
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	idx, err = s.applyEntries(sc, reqBody.Shard, reqBody.Term, false, idx, entries)
+	if err != nil {
+		s.writeApplyError(w, r, reqBody.Shard, err, "Error replaying log of shard %s", reqBody.Shard)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WriteResponse{
+		Message:    fmt.Sprintf("%d log entries replayed", len(entries)),
+		CurrentIdx: idx,
+		Status:     "success",
+	})
+}
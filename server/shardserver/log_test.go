@@ -0,0 +1,166 @@
+package shardserver
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "galaxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := New("1", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(func() {
+		httpServer.Close()
+		db.Close()
+	})
+
+	call(t, httpServer, "POST", "/config", ConfigPayload{
+		Schema: schema{Columns: []string{"id", "name"}, Dtypes: []string{"INTEGER", "TEXT"}},
+		Shards: []string{"sh1"},
+	}, http.StatusOK, nil)
+	return httpServer
+}
+
+func call(t *testing.T, server *httptest.Server, method string, endpoint string, in interface{}, wantStatus int, out interface{}) {
+	t.Helper()
+
+	payload, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, server.URL+endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: got status %d, want %d", method, endpoint, resp.StatusCode, wantStatus)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogIndex(t *testing.T) {
+	server := newTestServer(t)
+
+	var writeResp WriteResponse
+	call(t, server, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{
+		{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"},
+	}}, http.StatusOK, &writeResp)
+	if writeResp.CurrentIdx != 3 {
+		t.Fatalf("got index %d after writing 3 rows, want 3", writeResp.CurrentIdx)
+	}
+
+	// a request built for an older index is refused with the real one
+	var conflict apierror.Error
+	call(t, server, "POST", "/write", WriteRequest{Shard: "sh1", CurrIndex: 1, Data: []Row{{"id": 4}}}, http.StatusConflict, &conflict)
+	if conflict.Code != apierror.IndexConflict || conflict.CurrentIndex == nil || *conflict.CurrentIndex != 3 || conflict.Shard != "sh1" {
+		t.Errorf("got %+v, want an index conflict of sh1 at index 3", conflict)
+	}
+
+	call(t, server, "PUT", "/update", UpdateRequest{Shard: "sh1", CurrIndex: 3, StudID: 2, Data: Row{"name": "B"}}, http.StatusOK, &writeResp)
+	call(t, server, "DELETE", "/delete", DeleteRequest{Shard: "sh1", CurrIndex: 4, StudID: 3}, http.StatusOK, &writeResp)
+	if writeResp.CurrentIdx != 5 {
+		t.Fatalf("got index %d after update and delete, want 5", writeResp.CurrentIdx)
+	}
+
+	var logResp LogResponse
+	call(t, server, "GET", "/log", LogRequest{Shard: "sh1", After: 1, Limit: 3}, http.StatusOK, &logResp)
+	if logResp.CurrentIdx != 5 || len(logResp.Entries) != 3 {
+		t.Fatalf("got %d entries and index %d, want 3 entries and index 5", len(logResp.Entries), logResp.CurrentIdx)
+	}
+	for i, op := range []string{OpWrite, OpWrite, OpUpdate} {
+		if entry := logResp.Entries[i]; entry.Seq != i+2 || entry.Op != op {
+			t.Errorf("entry %d: got seq %d op %s, want seq %d op %s", i, entry.Seq, entry.Op, i+2, op)
+		}
+	}
+}
+
+func TestLogReplay(t *testing.T) {
+	source := newTestServer(t)
+	target := newTestServer(t)
+
+	call(t, source, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{{"id": 1, "name": "a"}, {"id": 2, "name": "b"}}}, http.StatusOK, nil)
+	call(t, source, "PUT", "/update", UpdateRequest{Shard: "sh1", CurrIndex: 2, StudID: 1, Data: Row{"name": "A"}}, http.StatusOK, nil)
+	call(t, source, "DELETE", "/delete", DeleteRequest{Shard: "sh1", CurrIndex: 3, StudID: 2}, http.StatusOK, nil)
+
+	var first, rest LogResponse
+	call(t, source, "GET", "/log", LogRequest{Shard: "sh1", Limit: 2}, http.StatusOK, &first)
+	call(t, source, "GET", "/log", LogRequest{Shard: "sh1", After: 2}, http.StatusOK, &rest)
+
+	// a batch that skips entries is refused
+	call(t, target, "POST", "/replay", ReplayRequest{Shard: "sh1", Entries: rest.Entries}, http.StatusConflict, nil)
+
+	var replayResp WriteResponse
+	call(t, target, "POST", "/replay", ReplayRequest{Shard: "sh1", Entries: first.Entries}, http.StatusOK, &replayResp)
+	// replaying a batch again changes nothing
+	call(t, target, "POST", "/replay", ReplayRequest{Shard: "sh1", Entries: first.Entries}, http.StatusOK, &replayResp)
+	call(t, target, "POST", "/replay", ReplayRequest{Shard: "sh1", Entries: rest.Entries}, http.StatusOK, &replayResp)
+	if replayResp.CurrentIdx != 4 {
+		t.Fatalf("got index %d after replay, want 4", replayResp.CurrentIdx)
+	}
+
+	var sourceData, targetData map[string]json.RawMessage
+	call(t, source, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &sourceData)
+	call(t, target, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &targetData)
+	if string(targetData["sh1"]) != string(sourceData["sh1"]) {
+		t.Errorf("got %s on target, want %s", targetData["sh1"], sourceData["sh1"])
+	}
+	if string(targetData["sh1"]) != `[{"id":1,"name":"A"}]` {
+		t.Errorf("got %s after replay", targetData["sh1"])
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	source := newTestServer(t)
+	target := newTestServer(t)
+
+	call(t, source, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{{"id": 1}, {"id": 5}, {"id": 9}, {"id": 10}}}, http.StatusOK, nil)
+	high := 10
+	call(t, source, "DELETE", "/delete", DeleteRequest{Shard: "sh1", CurrIndex: 4, StudID: 5, High: &high}, http.StatusOK, nil)
+
+	// the range survives the trip through the log
+	var logResp LogResponse
+	call(t, source, "GET", "/log", LogRequest{Shard: "sh1"}, http.StatusOK, &logResp)
+	call(t, target, "POST", "/replay", ReplayRequest{Shard: "sh1", Entries: logResp.Entries}, http.StatusOK, nil)
+
+	for _, server := range []*httptest.Server{source, target} {
+		var data map[string]json.RawMessage
+		call(t, server, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &data)
+		if string(data["sh1"]) != `[{"id":1,"name":null},{"id":10,"name":null}]` {
+			t.Errorf("got %s after deleting [5, 10)", data["sh1"])
+		}
+	}
+
+	call(t, source, "DELETE", "/drop", DropRequest{Shards: []string{"sh1"}}, http.StatusOK, nil)
+	call(t, source, "GET", "/log", LogRequest{Shard: "sh1"}, http.StatusOK, &logResp)
+	if logResp.CurrentIdx != 0 {
+		t.Errorf("got index %d after drop, want 0", logResp.CurrentIdx)
+	}
+	call(t, source, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusInternalServerError, nil)
+}
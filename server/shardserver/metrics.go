@@ -0,0 +1,23 @@
+package shardserver
+
+import "github.com/Sarita-Singh/galaxyDB/server/metrics"
+
+// serverMetrics are the metrics a shard server serves at /metrics.
+type serverMetrics struct {
+	registry          *metrics.Registry
+	http              *metrics.HTTPMetrics
+	shardReads        *metrics.Counter
+	shardWrites       *metrics.Counter
+	replicationErrors *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	registry := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:          registry,
+		http:              registry.NewHTTPMetrics("galaxydb_server"),
+		shardReads:        registry.NewCounter("galaxydb_server_shard_reads_total", "Reads, queries and aggregations served, by shard.", "shard"),
+		shardWrites:       registry.NewCounter("galaxydb_server_shard_writes_total", "Changes applied, as primary or replica, by shard.", "shard"),
+		replicationErrors: registry.NewCounter("galaxydb_server_replication_errors_total", "Changes a primary failed to pass on to a replica, by replica address.", "replica"),
+	}
+}
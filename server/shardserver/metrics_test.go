@@ -0,0 +1,36 @@
+package shardserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	server := newTestServer(t)
+	call(t, server, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{{"id": 1}, {"id": 2}}}, http.StatusOK, nil)
+	call(t, server, "POST", "/read", ReadRequest{Shard: "sh1"}, http.StatusOK, nil)
+	call(t, server, "POST", "/read", ReadRequest{Shard: "sh1;"}, http.StatusBadRequest, nil)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`galaxydb_server_http_requests_total{endpoint="/read",method="POST",code="200"} 1`,
+		`galaxydb_server_http_requests_total{endpoint="/read",method="POST",code="400"} 1`,
+		`galaxydb_server_http_request_duration_seconds_count{endpoint="/write"} 1`,
+		`galaxydb_server_shard_reads_total{shard="sh1"} 1`,
+		`galaxydb_server_shard_writes_total{shard="sh1"} 2`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package shardserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/querylang"
+)
+
+// Queries page with keyset cursors: a cursor holds the values of the sort
+// columns, the shard key last, of the last row of a page, and the next page
+// starts at the first row sorting after them. Pages stay consistent while
+// rows are written, and a cursor can be passed on to every shard of a table
+// since it does not depend on where the row came from.
+
+// sortColumns returns the columns rows are sorted by: the ones asked for and
+// the shard key, unless already among them.
+func (sc *schema) sortColumns(orderBy []OrderBy) []OrderBy {
+	columns := []OrderBy{}
+	for _, order := range orderBy {
+		columns = append(columns, order)
+		if order.Column == sc.keyColumn() {
+			return columns
+		}
+	}
+	return append(columns, OrderBy{Column: sc.keyColumn()})
+}
+
+func encodeCursor(values []interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var values []interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return values, nil
+}
+
+// predicateSQL returns the condition for a predicate and its arguments.
+func (sc *schema) predicateSQL(p Predicate) (string, []interface{}, error) {
+	kind, ok := sc.dtypeOf(p.Column)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown column %q", p.Column)
+	}
+	op := strings.ToUpper(p.Op)
+	if !querylang.IsOp(op) {
+		return "", nil, fmt.Errorf("unknown operator %q", p.Op)
+	}
+
+	switch op {
+	case "IN":
+		values, ok := p.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return "", nil, fmt.Errorf("column %q: IN takes a non-empty list", p.Column)
+		}
+		args := []interface{}{}
+		for _, value := range values {
+			v, err := convertValue(p.Column, kind, value)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, v)
+		}
+		return fmt.Sprintf("%s IN (?%s)", p.Column, strings.Repeat(", ?", len(args)-1)), args, nil
+	case "LIKE":
+		pattern, ok := p.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("column %q: LIKE takes a string", p.Column)
+		}
+		return p.Column + " LIKE ?", []interface{}{pattern}, nil
+	}
+
+	if p.Value == nil {
+		switch op {
+		case "=":
+			return p.Column + " IS NULL", nil, nil
+		case "!=":
+			return p.Column + " IS NOT NULL", nil, nil
+		default:
+			return "", nil, fmt.Errorf("column %q: %s takes a value", p.Column, op)
+		}
+	}
+	v, err := convertValue(p.Column, kind, p.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s %s ?", p.Column, op), []interface{}{v}, nil
+}
+
+// afterSQL returns the condition for rows sorting after the values of a
+// cursor. SQLite sorts nulls first, so they come before every value going up
+// and after every value going down.
+func (sc *schema) afterSQL(sortColumns []OrderBy, values []interface{}) (string, []interface{}, error) {
+	if len(values) != len(sortColumns) {
+		return "", nil, errors.New("invalid cursor")
+	}
+
+	alternatives := []string{}
+	args := []interface{}{}
+	equal := []string{}
+	equalArgs := []interface{}{}
+	for i, order := range sortColumns {
+		kind, _ := sc.dtypeOf(order.Column)
+		v, err := convertValue(order.Column, kind, values[i])
+		if err != nil {
+			return "", nil, errors.New("invalid cursor")
+		}
+
+		var after string
+		var afterArgs []interface{}
+		switch {
+		case v == nil && !order.Desc:
+			after = order.Column + " IS NOT NULL"
+		case v == nil && order.Desc:
+			after = "0"
+		case !order.Desc:
+			after, afterArgs = order.Column+" > ?", []interface{}{v}
+		default:
+			after, afterArgs = fmt.Sprintf("(%s < ? OR %s IS NULL)", order.Column, order.Column), []interface{}{v}
+		}
+		alternatives = append(alternatives, "("+strings.Join(append(append([]string{}, equal...), after), " AND ")+")")
+		args = append(append(args, equalArgs...), afterArgs...)
+
+		equal = append(equal, order.Column+" IS ?")
+		equalArgs = append(equalArgs, v)
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args, nil
+}
+
+// buildQuery returns the SQL for a query on a shard and its arguments, and
+// the columns the rows are sorted by.
+func (sc *schema) buildQuery(req QueryRequest) (string, []interface{}, []OrderBy, error) {
+	for _, order := range req.OrderBy {
+		if _, ok := sc.dtypeOf(order.Column); !ok {
+			return "", nil, nil, fmt.Errorf("unknown column %q", order.Column)
+		}
+	}
+	sortColumns := sc.sortColumns(req.OrderBy)
+
+	selected := map[string]bool{}
+	columns := []string{}
+	for _, column := range req.Columns {
+		if _, ok := sc.dtypeOf(column); !ok {
+			return "", nil, nil, fmt.Errorf("unknown column %q", column)
+		}
+		if !selected[column] {
+			selected[column] = true
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		columns = append(columns, sc.Columns...)
+	}
+	// the sort columns are needed to build the next cursor
+	for _, order := range sortColumns {
+		if !selected[order.Column] {
+			selected[order.Column] = true
+			columns = append(columns, order.Column)
+		}
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	for _, p := range req.Where {
+		condition, conditionArgs, err := sc.predicateSQL(p)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if req.Cursor != "" {
+		values, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		condition, conditionArgs, err := sc.afterSQL(sortColumns, values)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), req.Shard)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	orders := []string{}
+	for _, order := range sortColumns {
+		if order.Desc {
+			orders = append(orders, order.Column+" DESC")
+		} else {
+			orders = append(orders, order.Column+" ASC")
+		}
+	}
+	query += " ORDER BY " + strings.Join(orders, ", ")
+	if req.Limit > 0 {
+		// one more row tells whether there is a next page
+		query += " LIMIT ?"
+		args = append(args, req.Limit+1)
+	}
+	return query, args, sortColumns, nil
+}
+
+// runQuery runs a query on a shard, returning a page of rows with only the
+// columns asked for and the cursor of the next page, if any.
+func (sc *schema) runQuery(q queryer, req QueryRequest) ([]Row, string, error) {
+	query, args, sortColumns, err := sc.buildQuery(req)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := fetchDataFromShard(q, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if req.Limit > 0 && len(data) > req.Limit {
+		data = data[:req.Limit]
+		last := data[len(data)-1]
+		values := []interface{}{}
+		for _, order := range sortColumns {
+			values = append(values, last[order.Column])
+		}
+		if nextCursor, err = encodeCursor(values); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if len(req.Columns) > 0 {
+		wanted := map[string]bool{}
+		for _, column := range req.Columns {
+			wanted[column] = true
+		}
+		for _, row := range data {
+			for column := range row {
+				if !wanted[column] {
+					delete(row, column)
+				}
+			}
+		}
+	}
+	return data, nextCursor, nil
+}
+
+func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody QueryRequest
+	if err := decodeJSON(r, &reqBody); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	shard := reqBody.Shard
+	if !validIdentifier(shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", shard).WithShard(shard))
+		return
+	}
+	if reqBody.Limit < 0 {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "limit must not be negative"))
+		return
+	}
+	if _, _, _, err := sc.buildQuery(reqBody); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid query: %v", err))
+		return
+	}
+
+	// as for /read, the rows and the index they are at are read in one
+	// transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error querying shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	defer tx.Rollback()
+
+	data, nextCursor, err := sc.runQuery(tx, reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error querying shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+	idx, err := lastIndex(tx, shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", shard, err).WithShard(shard))
+		return
+	}
+
+	s.metrics.shardReads.Inc(shard)
+	response := QueryResponse{
+		Data:       data,
+		NextCursor: nextCursor,
+		CurrentIdx: idx,
+		Status:     "success",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,78 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	server := newTestServer(t)
+	call(t, server, "POST", "/write", WriteRequest{Shard: "sh1", Data: []Row{
+		{"id": 1, "name": "d"}, {"id": 2, "name": "b"}, {"id": 3, "name": "a"},
+		{"id": 4, "name": "b"}, {"id": 5}, {"id": 6, "name": "c"},
+	}}, http.StatusOK, nil)
+
+	query := func(req QueryRequest) QueryResponse {
+		t.Helper()
+		var resp QueryResponse
+		call(t, server, "POST", "/query", req, http.StatusOK, &resp)
+		return resp
+	}
+	assertData := func(got []Row, want string) {
+		t.Helper()
+		data, err := json.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("got %s, want %s", data, want)
+		}
+	}
+
+	resp := query(QueryRequest{Shard: "sh1", Columns: []string{"name"}, Where: []Predicate{
+		{Column: "id", Op: ">=", Value: 2},
+		{Column: "name", Op: "in", Value: []interface{}{"a", "b"}},
+	}})
+	assertData(resp.Data, `[{"name":"b"},{"name":"a"},{"name":"b"}]`)
+	if resp.NextCursor != "" {
+		t.Errorf("got cursor %q without a limit", resp.NextCursor)
+	}
+	resp = query(QueryRequest{Shard: "sh1", Columns: []string{"id"}, Where: []Predicate{{Column: "name", Op: "=", Value: nil}}})
+	assertData(resp.Data, `[{"id":5}]`)
+
+	// pages by name going down, nulls last, then by id
+	pages := []string{
+		`[{"id":1,"name":"d"},{"id":6,"name":"c"}]`,
+		`[{"id":2,"name":"b"},{"id":4,"name":"b"}]`,
+		`[{"id":3,"name":"a"},{"id":5,"name":null}]`,
+	}
+	req := QueryRequest{Shard: "sh1", OrderBy: []OrderBy{{Column: "name", Desc: true}}, Limit: 2}
+	for i, want := range pages {
+		resp = query(req)
+		assertData(resp.Data, want)
+		if (resp.NextCursor == "") != (i == len(pages)-1) {
+			t.Fatalf("page %d: got cursor %q", i, resp.NextCursor)
+		}
+		req.Cursor = resp.NextCursor
+	}
+
+	// going up nulls come first
+	req = QueryRequest{Shard: "sh1", Columns: []string{"id"}, OrderBy: []OrderBy{{Column: "name"}}, Limit: 3}
+	resp = query(req)
+	assertData(resp.Data, `[{"id":5},{"id":3},{"id":2}]`)
+	req.Cursor = resp.NextCursor
+	resp = query(req)
+	assertData(resp.Data, `[{"id":4},{"id":6},{"id":1}]`)
+
+	for _, bad := range []QueryRequest{
+		{Shard: "sh1", Columns: []string{"age"}},
+		{Shard: "sh1", Where: []Predicate{{Column: "id", Op: "~", Value: 1}}},
+		{Shard: "sh1", Where: []Predicate{{Column: "id", Op: ">", Value: "x"}}},
+		{Shard: "sh1", OrderBy: []OrderBy{{Column: "age"}}},
+		{Shard: "sh1", Cursor: "not a cursor"},
+		{Shard: "sh1", Limit: -1},
+	} {
+		call(t, server, "POST", "/query", bad, http.StatusBadRequest, nil)
+	}
+}
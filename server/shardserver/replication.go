@@ -0,0 +1,274 @@
+package shardserver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/logging"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// Each shard has one primary, picked by the load balancer under a term that
+// grows with every election. Only the primary takes writes from the load
+// balancer. It orders them in its log and pushes the new entries to the other
+// replicas through /replay, tagged with its term. A server remembers the
+// highest term it has seen for a shard and refuses anything tagged with an
+// older one, which fences off a primary that has been replaced.
+const initTermTable = `CREATE TABLE IF NOT EXISTS galaxy_terms (
+						shard TEXT PRIMARY KEY,
+						term INTEGER,
+						is_primary INTEGER
+					)`
+
+const replicationTimeout = 5 * time.Second
+
+var replicationClient = &http.Client{Transport: tracing.NewTransport(nil), Timeout: replicationTimeout}
+
+type PromoteRequest struct {
+	Shard    string   `json:"shard"`
+	Term     int      `json:"term"`
+	Replicas []string `json:"replicas"`
+}
+
+// termConflictError is returned for requests tagged with a term older than
+// the one the server has seen, or from a second primary in the same term.
+type termConflictError struct {
+	term    int
+	current int
+}
+
+func (e *termConflictError) Error() string {
+	return fmt.Sprintf("term %d is stale, shard is at term %d", e.term, e.current)
+}
+
+// checkTerm fences a change to a shard tagged with term, coming from the load
+// balancer if primary is set and from the shard's primary otherwise, and
+// records the term if it is new.
+func checkTerm(tx *sql.Tx, shard string, term int, primary bool) error {
+	var current int
+	var isPrimary bool
+	err := tx.QueryRow("SELECT term, is_primary FROM galaxy_terms WHERE shard = ?", shard).Scan(&current, &isPrimary)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if term < current || (term == current && term > 0 && isPrimary != primary) {
+		return &termConflictError{term: term, current: current}
+	}
+	if term > current {
+		_, err = tx.Exec("INSERT OR REPLACE INTO galaxy_terms (shard, term, is_primary) VALUES (?, ?, ?)", shard, term, primary)
+		return err
+	}
+	return nil
+}
+
+// apiError tells the caller the term the shard is at.
+func (e *termConflictError) apiError(shard string) *apierror.Error {
+	apiErr := apierror.Errorf(apierror.TermConflict, "%v", e).WithShard(shard)
+	apiErr.CurrentTerm = &e.current
+	return apiErr
+}
+
+// writeApplyError writes the response for an error from applyEntries.
+func (s *Server) writeApplyError(w http.ResponseWriter, r *http.Request, shard string, err error, format string, args ...interface{}) {
+	var indexConflict *indexConflictError
+	var termConflict *termConflictError
+	switch {
+	case errors.As(err, &indexConflict):
+		s.writeError(w, r, indexConflict.apiError(shard))
+	case errors.As(err, &termConflict):
+		s.writeError(w, r, termConflict.apiError(shard))
+	default:
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, format+": %v", append(args, err)...).WithShard(shard))
+	}
+}
+
+func postReplay(ctx context.Context, address string, request ReplayRequest) (int, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+"/replay", bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := apierror.Read(resp)
+		if apiErr.Code == apierror.IndexConflict && apiErr.CurrentIndex != nil {
+			return *apiErr.CurrentIndex, &indexConflictError{current: *apiErr.CurrentIndex}
+		}
+		return 0, fmt.Errorf("replica %s refused entries: %v", address, apiErr)
+	}
+
+	var respData WriteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		return 0, err
+	}
+	return respData.CurrentIdx, nil
+}
+
+// replicateTo sends entries to one replica. If the replica turns out to be
+// behind, it is first sent everything in the log after its index.
+func (s *Server) replicateTo(ctx context.Context, address string, shard string, term int, entries []LogEntry) (err error) {
+	ctx, span := tracing.Start(ctx, "replicate", tracing.SpanKindInternal)
+	span.SetAttribute("galaxydb.shard", shard)
+	span.SetAttribute("galaxydb.replica", address)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	idx, err := postReplay(ctx, address, ReplayRequest{Shard: shard, Term: term, Entries: entries})
+	var conflict *indexConflictError
+	if err != nil && !errors.As(err, &conflict) {
+		return err
+	}
+
+	last, err := lastIndex(s.db, shard)
+	if err != nil {
+		return err
+	}
+	for idx < last {
+		missing, err := s.readLog(shard, idx, defaultLogLimit)
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			break
+		}
+		idx, err = postReplay(ctx, address, ReplayRequest{Shard: shard, Term: term, Entries: missing})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replicate pushes entries the primary has applied to all replicas at once.
+// It returns as soon as wait of them are up to date, with the number that are
+// and the ones that failed so far, and leaves the rest to finish in the
+// background, still traced under ctx.
+func (s *Server) replicate(ctx context.Context, shard string, term int, replicas []string, entries []LogEntry, wait int) (int, []string) {
+	ctx = context.WithoutCancel(ctx)
+	results := make(chan string, len(replicas))
+	for _, address := range replicas {
+		go func(address string) {
+			if err := s.replicateTo(ctx, address, shard, term, entries); err != nil {
+				slog.WarnContext(ctx, "error replicating to replica", "shard", shard, "replica", address, logging.Error(err))
+				s.metrics.replicationErrors.Inc(address)
+				results <- address
+				return
+			}
+			results <- ""
+		}(address)
+	}
+
+	acks := 0
+	failed := []string{}
+	for i := 0; i < len(replicas) && acks < wait; i++ {
+		if address := <-results; address != "" {
+			failed = append(failed, address)
+		} else {
+			acks++
+		}
+	}
+
+	return acks, failed
+}
+
+// commit applies entries as the primary of a shard and replicates them to the
+// other replicas, waiting for minAcks servers in all, or for every one of
+// them if minAcks is 0.
+func (s *Server) commit(ctx context.Context, sc *schema, shard string, term int, currIdx int, replicas []string, minAcks int, entries []LogEntry) (*WriteResponse, error) {
+	_, span := tracing.Start(ctx, "apply", tracing.SpanKindInternal)
+	span.SetAttribute("galaxydb.shard", shard)
+	span.SetAttribute("galaxydb.entries", len(entries))
+	idx, err := s.applyEntries(sc, shard, term, true, currIdx, entries)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, err
+	}
+
+	wait := len(replicas)
+	if minAcks > 0 && minAcks-1 < wait {
+		wait = minAcks - 1
+	}
+	acks, failed := s.replicate(ctx, shard, term, replicas, entries, wait)
+
+	return &WriteResponse{
+		CurrentIdx:     idx,
+		Acks:           1 + acks,
+		FailedReplicas: failed,
+		Status:         "success",
+	}, nil
+}
+
+// promoteHandler makes the server the primary of a shard for a new term and
+// brings the other replicas up to date with its log.
+func (s *Server) promoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody PromoteRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	if !validIdentifier(reqBody.Shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+
+	s.writeMutex.Lock()
+	tx, err := s.db.Begin()
+	if err == nil {
+		err = checkTerm(tx, reqBody.Shard, reqBody.Term, true)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	s.writeMutex.Unlock()
+	if err != nil {
+		s.writeApplyError(w, r, reqBody.Shard, err, "Error promoting shard %s", reqBody.Shard)
+		return
+	}
+
+	acks, failed := s.replicate(r.Context(), reqBody.Shard, reqBody.Term, reqBody.Replicas, nil, len(reqBody.Replicas))
+	idx, err := lastIndex(s.db, reqBody.Shard)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error reading log of shard %s: %v", reqBody.Shard, err).WithShard(reqBody.Shard))
+		return
+	}
+	slog.InfoContext(r.Context(), "promoted to primary", "shard", reqBody.Shard, "term", reqBody.Term, "index", idx)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WriteResponse{
+		Message:        fmt.Sprintf("Server%s is primary of %s for term %d", s.id, reqBody.Shard, reqBody.Term),
+		CurrentIdx:     idx,
+		Acks:           1 + acks,
+		FailedReplicas: failed,
+		Status:         "success",
+	})
+}
@@ -0,0 +1,47 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReplicationAndFencing(t *testing.T) {
+	primary := newTestServer(t)
+	replica := newTestServer(t)
+	primaryAddress := strings.TrimPrefix(primary.URL, "http://")
+	replicaAddress := strings.TrimPrefix(replica.URL, "http://")
+
+	var promoteResp WriteResponse
+	call(t, primary, "POST", "/promote", PromoteRequest{Shard: "sh1", Term: 1, Replicas: []string{replicaAddress}}, http.StatusOK, &promoteResp)
+
+	var writeResp WriteResponse
+	call(t, primary, "POST", "/write", WriteRequest{Shard: "sh1", Term: 1, Replicas: []string{replicaAddress}, Data: []Row{
+		{"id": 1, "name": "a"}, {"id": 2, "name": "b"},
+	}}, http.StatusOK, &writeResp)
+	if writeResp.CurrentIdx != 2 || writeResp.Acks != 2 {
+		t.Fatalf("got index %d with %d acks, want index 2 with 2 acks", writeResp.CurrentIdx, writeResp.Acks)
+	}
+
+	// only the primary takes writes for its term
+	call(t, replica, "POST", "/write", WriteRequest{Shard: "sh1", CurrIndex: 2, Term: 1, Data: []Row{{"id": 3}}}, http.StatusConflict, nil)
+
+	var primaryData, replicaData map[string]json.RawMessage
+	call(t, primary, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &primaryData)
+	call(t, replica, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &replicaData)
+	if string(replicaData["sh1"]) != string(primaryData["sh1"]) {
+		t.Errorf("got %s on replica, want %s", replicaData["sh1"], primaryData["sh1"])
+	}
+
+	// once the replica is promoted, the old primary is fenced off
+	call(t, replica, "POST", "/promote", PromoteRequest{Shard: "sh1", Term: 2, Replicas: []string{primaryAddress}}, http.StatusOK, &promoteResp)
+	if promoteResp.CurrentIdx != 2 || promoteResp.Acks != 2 {
+		t.Fatalf("got index %d with %d acks after promotion, want index 2 with 2 acks", promoteResp.CurrentIdx, promoteResp.Acks)
+	}
+	call(t, primary, "PUT", "/update", UpdateRequest{Shard: "sh1", CurrIndex: 2, Term: 1, StudID: 1, Data: Row{"name": "A"}}, http.StatusConflict, nil)
+	call(t, replica, "PUT", "/update", UpdateRequest{Shard: "sh1", CurrIndex: 2, Term: 2, Replicas: []string{primaryAddress}, StudID: 1, Data: Row{"name": "A"}}, http.StatusOK, &writeResp)
+	if writeResp.CurrentIdx != 3 || writeResp.Acks != 2 {
+		t.Fatalf("got index %d with %d acks, want index 3 with 2 acks", writeResp.CurrentIdx, writeResp.Acks)
+	}
+}
@@ -0,0 +1,216 @@
+package shardserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+const initSchemaTable = `CREATE TABLE IF NOT EXISTS galaxy_schema (
+							id INTEGER PRIMARY KEY CHECK (id = 0),
+							columns TEXT,
+							dtypes TEXT
+						)`
+
+type dtypeKind int
+
+const (
+	kindInteger dtypeKind = iota
+	kindReal
+	kindNumber
+	kindText
+)
+
+// dtypeKinds maps the dtypes accepted in a schema to the kind of JSON value a
+// column of that dtype holds.
+var dtypeKinds = map[string]dtypeKind{
+	"INT":     kindInteger,
+	"INTEGER": kindInteger,
+	"REAL":    kindReal,
+	"FLOAT":   kindReal,
+	"DOUBLE":  kindReal,
+	"NUMBER":  kindNumber,
+	"STRING":  kindText,
+	"TEXT":    kindText,
+	"VARCHAR": kindText,
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validIdentifier reports whether name can be used as a table or column name.
+// Both end up formatted into SQL, so anything else is rejected.
+func validIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name) && !strings.HasPrefix(strings.ToLower(name), "galaxy_")
+}
+
+func (sc *schema) validate() error {
+	if len(sc.Columns) == 0 {
+		return errors.New("schema has no columns")
+	}
+	if len(sc.Columns) != len(sc.Dtypes) {
+		return fmt.Errorf("schema has %d columns but %d dtypes", len(sc.Columns), len(sc.Dtypes))
+	}
+
+	seen := make(map[string]bool)
+	for i, col := range sc.Columns {
+		if !validIdentifier(col) {
+			return fmt.Errorf("invalid column name %q", col)
+		}
+		if seen[col] {
+			return fmt.Errorf("duplicate column %q", col)
+		}
+		seen[col] = true
+
+		if _, ok := dtypeKinds[strings.ToUpper(sc.Dtypes[i])]; !ok {
+			return fmt.Errorf("unsupported dtype %q for column %q", sc.Dtypes[i], col)
+		}
+	}
+
+	kind := dtypeKinds[strings.ToUpper(sc.Dtypes[0])]
+	if kind != kindInteger && kind != kindNumber {
+		return fmt.Errorf("shard key column %q must be numeric", sc.Columns[0])
+	}
+	return nil
+}
+
+// keyColumn is the column shards are ranged over, the first one in the schema.
+func (sc *schema) keyColumn() string {
+	return sc.Columns[0]
+}
+
+func (sc *schema) dtypeOf(col string) (dtypeKind, bool) {
+	for i, c := range sc.Columns {
+		if c == col {
+			return dtypeKinds[strings.ToUpper(sc.Dtypes[i])], true
+		}
+	}
+	return 0, false
+}
+
+// convertValue checks a decoded JSON value against the kind of its column and
+// returns it in the form it is stored in SQLite.
+func convertValue(col string, kind dtypeKind, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case kindText:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case kindInteger, kindReal, kindNumber:
+		var f float64
+		switch v := value.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil && kind != kindReal {
+				return i, nil
+			}
+			var err error
+			if f, err = v.Float64(); err != nil {
+				return nil, fmt.Errorf("column %q: %v is not a number", col, value)
+			}
+		case float64:
+			f = v
+		case int:
+			f = float64(v)
+		case int64:
+			f = float64(v)
+		default:
+			return nil, fmt.Errorf("column %q: %v is not a number", col, value)
+		}
+
+		if kind == kindReal {
+			return f, nil
+		}
+		if f == math.Trunc(f) {
+			return int64(f), nil
+		}
+		if kind == kindNumber {
+			return f, nil
+		}
+		return nil, fmt.Errorf("column %q: %v is not an integer", col, value)
+	}
+	return nil, fmt.Errorf("column %q: %v is not a string", col, value)
+}
+
+// convertRow checks every column of a row against the schema. If requireKey
+// is set the row must contain the shard key column.
+func (sc *schema) convertRow(row Row, requireKey bool) (Row, error) {
+	converted := make(Row, len(row))
+	for col, value := range row {
+		kind, ok := sc.dtypeOf(col)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+		v, err := convertValue(col, kind, value)
+		if err != nil {
+			return nil, err
+		}
+		converted[col] = v
+	}
+
+	if requireKey && converted[sc.keyColumn()] == nil {
+		return nil, fmt.Errorf("missing shard key column %q", sc.keyColumn())
+	}
+	return converted, nil
+}
+
+func (sc *schema) createTableQuery(shard string) string {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ( ", shard)
+	for i, col := range sc.Columns {
+		query += fmt.Sprintf("%s %s", col, sc.Dtypes[i])
+		if i < len(sc.Columns)-1 {
+			query += ", "
+		}
+	}
+	query += ")"
+	return query
+}
+
+func (sc *schema) selectColumns() string {
+	return strings.Join(sc.Columns, ", ")
+}
+
+// loadSchema reads the schema saved by a previous /config, if any.
+func loadSchema(db *sql.DB) (*schema, error) {
+	if _, err := db.Exec(initSchemaTable); err != nil {
+		return nil, err
+	}
+
+	var columns, dtypes string
+	err := db.QueryRow("SELECT columns, dtypes FROM galaxy_schema WHERE id = 0").Scan(&columns, &dtypes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &schema{}
+	if err := json.Unmarshal([]byte(columns), &sc.Columns); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(dtypes), &sc.Dtypes); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func saveSchema(tx *sql.Tx, sc *schema) error {
+	columns, err := json.Marshal(sc.Columns)
+	if err != nil {
+		return err
+	}
+	dtypes, err := json.Marshal(sc.Dtypes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO galaxy_schema (id, columns, dtypes) VALUES (0, ?, ?)", string(columns), string(dtypes))
+	return err
+}
@@ -0,0 +1,66 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema schema
+		valid  bool
+	}{
+		{"students", schema{[]string{"Stud_id", "Stud_name", "Stud_marks"}, []string{"Number", "String", "String"}}, true},
+		{"sql types", schema{[]string{"id", "price"}, []string{"INTEGER", "real"}}, true},
+		{"no columns", schema{}, false},
+		{"missing dtype", schema{[]string{"id", "name"}, []string{"INT"}}, false},
+		{"unknown dtype", schema{[]string{"id", "blob"}, []string{"INT", "BLOB"}}, false},
+		{"text key", schema{[]string{"name", "id"}, []string{"TEXT", "INT"}}, false},
+		{"injected column", schema{[]string{"id", "x INT); DROP TABLE sh1; --"}, []string{"INT", "INT"}}, false},
+		{"reserved column", schema{[]string{"id", "galaxy_seq"}, []string{"INT", "INT"}}, false},
+		{"duplicate column", schema{[]string{"id", "id"}, []string{"INT", "INT"}}, false},
+	}
+
+	for _, test := range tests {
+		err := test.schema.validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSchemaConvertRow(t *testing.T) {
+	sc := schema{
+		Columns: []string{"id", "name", "score", "weight"},
+		Dtypes:  []string{"INTEGER", "TEXT", "Number", "REAL"},
+	}
+
+	row, err := sc.convertRow(Row{"id": json.Number("12"), "name": "Ira", "score": json.Number("7.5"), "weight": json.Number("3")}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["id"] != int64(12) || row["name"] != "Ira" || row["score"] != 7.5 || row["weight"] != float64(3) {
+		t.Errorf("got %#v", row)
+	}
+
+	invalid := []Row{
+		{"name": "no key"},
+		{"id": json.Number("1.5")},
+		{"id": json.Number("1"), "name": json.Number("2")},
+		{"id": json.Number("1"), "score": "high"},
+		{"id": json.Number("1"), "unknown": "x"},
+	}
+	for _, row := range invalid {
+		if _, err := sc.convertRow(row, true); err == nil {
+			t.Errorf("expected an error for %v", row)
+		}
+	}
+
+	if _, err := sc.convertRow(Row{"score": json.Number("3")}, false); err != nil {
+		t.Errorf("unexpected error for a partial row: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package shardserver
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+	"github.com/Sarita-Singh/galaxyDB/server/tracing"
+)
+
+// Server holds the state of one shard server: its id, the database its
+// shard tables live in and the schema they were configured with.
+type Server struct {
+	id string
+	db *sql.DB
+
+	mutex  sync.RWMutex
+	schema *schema
+
+	// writeMutex serializes changes to the shards so that checking and
+	// advancing a shard's index is atomic
+	writeMutex sync.Mutex
+
+	metrics *serverMetrics
+	tracer  *tracing.Tracer
+}
+
+// New returns a server for db, picking up the schema of an earlier /config
+// if the database has one.
+func New(id string, db *sql.DB) (*Server, error) {
+	if _, err := db.Exec(initLogTable); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(initTermTable); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(initPreparedTable); err != nil {
+		return nil, err
+	}
+	sc, err := loadSchema(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		id:      id,
+		db:      db,
+		schema:  sc,
+		metrics: newServerMetrics(),
+		tracer:  tracing.NewTracer("galaxydb-server", "Server"+id, tracing.ExportersFromEnv()...),
+	}, nil
+}
+
+// Handler returns the HTTP handler serving all shard server endpoints. Every
+// request but heartbeats and scrapes is traced and logged with its request
+// id.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", s.heartbeatEndpoint)
+	mux.HandleFunc("/config", s.configEndpoint)
+	mux.HandleFunc("/copy", s.copyHandler)
+	mux.HandleFunc("/read", s.readHandler)
+	mux.HandleFunc("/query", s.queryHandler)
+	mux.HandleFunc("/aggregate", s.aggregateHandler)
+	mux.HandleFunc("/write", s.writeHandler)
+	mux.HandleFunc("/update", s.updateHandler)
+	mux.HandleFunc("/delete", s.deleteHandler)
+	mux.HandleFunc("/drop", s.dropHandler)
+	mux.HandleFunc("/log", s.logHandler)
+	mux.HandleFunc("/replay", s.replayHandler)
+	mux.HandleFunc("/promote", s.promoteHandler)
+	mux.HandleFunc("/prepare", s.prepareHandler)
+	mux.HandleFunc("/commit", s.commitHandler)
+	mux.HandleFunc("/abort", s.abortHandler)
+	mux.Handle("/metrics", s.metrics.registry.Handler())
+	return s.tracer.Middleware(s.metrics.http.Instrument(mux), "/heartbeat", "/metrics")
+}
+
+// writeError answers a request with the error envelope, naming the server.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, e *apierror.Error) {
+	e.Server = "Server" + s.id
+	apierror.Write(w, e)
+}
@@ -0,0 +1,251 @@
+package shardserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sarita-Singh/galaxyDB/server/apierror"
+)
+
+// A write spanning several shards is applied with a two-phase commit run by
+// the load balancer. In /prepare the primary of each shard checks that the
+// rows would apply at the expected index and term, without applying them,
+// and keeps them in galaxy_prepared. /commit then applies and replicates the
+// prepared rows like any other write, and /abort drops them.
+const initPreparedTable = `CREATE TABLE IF NOT EXISTS galaxy_prepared (
+							tx_id TEXT,
+							shard TEXT,
+							curr_idx INTEGER,
+							term INTEGER,
+							data TEXT,
+							PRIMARY KEY (tx_id, shard)
+						)`
+
+type PrepareRequest struct {
+	TxID      string `json:"tx_id"`
+	Shard     string `json:"shard"`
+	CurrIndex int    `json:"curr_idx"`
+	Term      int    `json:"term"`
+	Data      []Row  `json:"data"`
+}
+
+// TxRequest commits or aborts a prepared transaction on one shard. Replicas
+// and MinAcks are used on commit as in WriteRequest.
+type TxRequest struct {
+	TxID     string   `json:"tx_id"`
+	Shard    string   `json:"shard"`
+	Replicas []string `json:"replicas"`
+	MinAcks  int      `json:"min_acks"`
+}
+
+// invalidEntryError is returned when a prepared row cannot be applied.
+type invalidEntryError struct {
+	index int
+	err   error
+}
+
+func (e *invalidEntryError) Error() string {
+	return fmt.Sprintf("Invalid data entry %d: %v", e.index, e.err)
+}
+
+// prepare checks that entries apply to a shard at index currIdx under term
+// and saves them for a later commit.
+func (s *Server) prepare(sc *schema, txID string, shard string, term int, currIdx int, entries []LogEntry) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SAVEPOINT galaxy_prepare"); err != nil {
+		return err
+	}
+	if err := checkTerm(tx, shard, term, true); err != nil {
+		return err
+	}
+	idx, err := lastIndex(tx, shard)
+	if err != nil {
+		return err
+	}
+	if idx != currIdx {
+		return &indexConflictError{expected: currIdx, current: idx}
+	}
+	for i, entry := range entries {
+		if err := applyEntry(tx, sc, shard, entry); err != nil {
+			return &invalidEntryError{index: i, err: err}
+		}
+	}
+	// the entries were only applied to see that they can be
+	if _, err := tx.Exec("ROLLBACK TO galaxy_prepare"); err != nil {
+		return err
+	}
+
+	rows := []Row{}
+	for _, entry := range entries {
+		rows = append(rows, entry.Data)
+	}
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO galaxy_prepared (tx_id, shard, curr_idx, term, data) VALUES (?, ?, ?, ?, ?)", txID, shard, currIdx, term, string(data))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadPrepared returns what prepare saved for a transaction on a shard, or
+// sql.ErrNoRows.
+func (s *Server) loadPrepared(txID string, shard string) (int, int, []Row, error) {
+	var currIdx, term int
+	var data string
+	err := s.db.QueryRow("SELECT curr_idx, term, data FROM galaxy_prepared WHERE tx_id = ? AND shard = ?", txID, shard).Scan(&currIdx, &term, &data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	rows := []Row{}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
+		return 0, 0, nil, err
+	}
+	return currIdx, term, rows, nil
+}
+
+func (s *Server) dropPrepared(txID string, shard string) error {
+	_, err := s.db.Exec("DELETE FROM galaxy_prepared WHERE tx_id = ? AND shard = ?", txID, shard)
+	return err
+}
+
+func (s *Server) prepareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody PrepareRequest
+	err := decodeJSON(r, &reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	if !validIdentifier(reqBody.Shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+	entries, err := writeEntries(sc, reqBody.Data)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+
+	err = s.prepare(sc, reqBody.TxID, reqBody.Shard, reqBody.Term, reqBody.CurrIndex, entries)
+	var invalidEntry *invalidEntryError
+	if errors.As(err, &invalidEntry) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "%v", err))
+		return
+	}
+	if err != nil {
+		s.writeApplyError(w, r, reqBody.Shard, err, "Error preparing transaction %s on shard %s", reqBody.TxID, reqBody.Shard)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WriteResponse{
+		Message:    fmt.Sprintf("Transaction %s prepared on %s", reqBody.TxID, reqBody.Shard),
+		CurrentIdx: reqBody.CurrIndex,
+		Status:     "success",
+	})
+}
+
+func (s *Server) commitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody TxRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+	sc := s.getSchema(w, r)
+	if sc == nil {
+		return
+	}
+	if !validIdentifier(reqBody.Shard) {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Invalid shard name %q", reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+
+	currIdx, term, rows, err := s.loadPrepared(reqBody.TxID, reqBody.Shard)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.writeError(w, r, apierror.Errorf(apierror.NotFound, "Transaction %s is not prepared on shard %s", reqBody.TxID, reqBody.Shard).WithShard(reqBody.Shard))
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error loading transaction %s: %v", reqBody.TxID, err))
+		return
+	}
+	entries, err := writeEntries(sc, rows)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error loading transaction %s: %v", reqBody.TxID, err))
+		return
+	}
+
+	resp, err := s.commit(r.Context(), sc, reqBody.Shard, term, currIdx, reqBody.Replicas, reqBody.MinAcks, entries)
+	if err != nil {
+		s.writeApplyError(w, r, reqBody.Shard, err, "Error committing transaction %s on shard %s", reqBody.TxID, reqBody.Shard)
+		return
+	}
+	if err := s.dropPrepared(reqBody.TxID, reqBody.Shard); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error committing transaction %s: %v", reqBody.TxID, err))
+		return
+	}
+	resp.Message = fmt.Sprintf("Transaction %s committed on %s", reqBody.TxID, reqBody.Shard)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) abortHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, apierror.Errorf(apierror.MethodNotAllowed, "Method not supported"))
+		return
+	}
+
+	var reqBody TxRequest
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
+	if err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.BadRequest, "Error decoding JSON: %v", err))
+		return
+	}
+
+	if err := s.dropPrepared(reqBody.TxID, reqBody.Shard); err != nil {
+		s.writeError(w, r, apierror.Errorf(apierror.Internal, "Error aborting transaction %s: %v", reqBody.TxID, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WriteResponse{
+		Message: fmt.Sprintf("Transaction %s aborted on %s", reqBody.TxID, reqBody.Shard),
+		Status:  "success",
+	})
+}
@@ -0,0 +1,42 @@
+package shardserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPrepareCommitAbort(t *testing.T) {
+	server := newTestServer(t)
+	shardData := func() string {
+		var data map[string]json.RawMessage
+		call(t, server, "GET", "/copy", CopyRequest{Shards: []string{"sh1"}}, http.StatusOK, &data)
+		return string(data["sh1"])
+	}
+
+	call(t, server, "POST", "/prepare", PrepareRequest{TxID: "t1", Shard: "sh1", Data: []Row{{"id": 1, "name": "a"}}}, http.StatusOK, nil)
+	call(t, server, "POST", "/prepare", PrepareRequest{TxID: "t2", Shard: "sh1", Data: []Row{{"id": 2, "name": "b"}}}, http.StatusOK, nil)
+	if got := shardData(); got != `[]` {
+		t.Fatalf("got %s after prepare, want nothing applied", got)
+	}
+
+	// rows that do not fit the schema or an index that moved on are refused
+	call(t, server, "POST", "/prepare", PrepareRequest{TxID: "t3", Shard: "sh1", Data: []Row{{"id": "x"}}}, http.StatusBadRequest, nil)
+	call(t, server, "POST", "/prepare", PrepareRequest{TxID: "t3", Shard: "sh1", CurrIndex: 5, Data: []Row{{"id": 3}}}, http.StatusConflict, nil)
+
+	var commitResp WriteResponse
+	call(t, server, "POST", "/commit", TxRequest{TxID: "t1", Shard: "sh1"}, http.StatusOK, &commitResp)
+	if commitResp.CurrentIdx != 1 {
+		t.Errorf("got index %d after commit, want 1", commitResp.CurrentIdx)
+	}
+	call(t, server, "POST", "/commit", TxRequest{TxID: "t1", Shard: "sh1"}, http.StatusNotFound, nil)
+
+	// t2 was prepared for index 0 and can no longer commit, but can abort
+	call(t, server, "POST", "/commit", TxRequest{TxID: "t2", Shard: "sh1"}, http.StatusConflict, nil)
+	call(t, server, "POST", "/abort", TxRequest{TxID: "t2", Shard: "sh1"}, http.StatusOK, nil)
+	call(t, server, "POST", "/commit", TxRequest{TxID: "t2", Shard: "sh1"}, http.StatusNotFound, nil)
+
+	if got := shardData(); got != `[{"id":1,"name":"a"}]` {
+		t.Errorf("got %s after commit and abort", got)
+	}
+}
@@ -0,0 +1,150 @@
+package shardserver
+
+type ConfigPayload struct {
+	Schema schema   `json:"schema"`
+	Shards []string `json:"shards"`
+}
+
+type schema struct {
+	Columns []string `json:"columns"`
+	Dtypes  []string `json:"dtypes"`
+}
+
+type CopyRequest struct {
+	Shards []string `json:"shards"`
+}
+
+// Row is one entry of a shard table, keyed by column name.
+type Row map[string]interface{}
+
+// WriteRequest and the other changes the load balancer sends to the primary
+// of a shard carry the primary's term and the addresses of the replicas it
+// should pass the change on to. The primary answers once MinAcks servers,
+// itself included, have applied the change, or once all have if it is 0, and
+// goes on replicating in the background.
+type WriteRequest struct {
+	Shard     string   `json:"shard"`
+	CurrIndex int      `json:"curr_idx"`
+	Term      int      `json:"term"`
+	Replicas  []string `json:"replicas"`
+	MinAcks   int      `json:"min_acks"`
+	Data      []Row    `json:"data"`
+}
+
+// WriteResponse reports the index a change left the shard at and how many
+// replicas, the primary included, applied it.
+type WriteResponse struct {
+	Message        string   `json:"message"`
+	CurrentIdx     int      `json:"current_idx"`
+	Acks           int      `json:"acks"`
+	FailedReplicas []string `json:"failed_replicas,omitempty"`
+	Status         string   `json:"status"`
+}
+
+// ReadRequest selects the rows of a shard whose shard key, the first column
+// of the schema, lies in the given range. The field keeps its historical
+// Stud_id name on the wire.
+type ReadRequest struct {
+	Shard  string `json:"shard"`
+	StudID struct {
+		Low  int `json:"low"`
+		High int `json:"high"`
+	} `json:"Stud_id"`
+}
+
+// ReadResponse carries the index of the shard the rows were read at.
+type ReadResponse struct {
+	Data       []Row  `json:"data"`
+	CurrentIdx int    `json:"current_idx"`
+	Status     string `json:"status"`
+}
+
+// UpdateRequest sets the columns in Data for the row with the given shard key.
+type UpdateRequest struct {
+	Shard     string   `json:"shard"`
+	CurrIndex int      `json:"curr_idx"`
+	Term      int      `json:"term"`
+	Replicas  []string `json:"replicas"`
+	MinAcks   int      `json:"min_acks"`
+	StudID    int      `json:"Stud_id"`
+	Data      Row      `json:"data"`
+}
+
+// DeleteRequest deletes the row with the given shard key or, if High is set,
+// every row with a shard key from StudID up to, but not including, High.
+type DeleteRequest struct {
+	Shard     string   `json:"shard"`
+	CurrIndex int      `json:"curr_idx"`
+	Term      int      `json:"term"`
+	Replicas  []string `json:"replicas"`
+	MinAcks   int      `json:"min_acks"`
+	StudID    int      `json:"Stud_id"`
+	High      *int     `json:"high,omitempty"`
+}
+
+// DropRequest removes shards from a server, together with their logs.
+type DropRequest struct {
+	Shards []string `json:"shards"`
+}
+
+// Predicate compares a column with a value: Op is one of =, !=, <, <=, >, >=,
+// LIKE or IN, which takes a list. = and != with a null value test for null.
+type Predicate struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value"`
+}
+
+// OrderBy sorts by a column, ascending unless Desc is set.
+type OrderBy struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// QueryRequest selects the Columns, all if empty, of the rows of a shard that
+// match every predicate in Where, sorted by OrderBy and then by the shard
+// key. With Limit set at most that many rows are returned, and Cursor, the
+// NextCursor of the previous page, continues after its last row.
+type QueryRequest struct {
+	Shard   string      `json:"shard"`
+	Columns []string    `json:"columns"`
+	Where   []Predicate `json:"where"`
+	OrderBy []OrderBy   `json:"order_by"`
+	Limit   int         `json:"limit"`
+	Cursor  string      `json:"cursor"`
+}
+
+// QueryResponse has a NextCursor when there are rows after the last one.
+type QueryResponse struct {
+	Data       []Row  `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	CurrentIdx int    `json:"current_idx"`
+	Status     string `json:"status"`
+}
+
+// Aggregate applies Func, one of COUNT, SUM, AVG, MIN or MAX, to a column.
+// COUNT without a column counts rows. As names the result, by default the
+// function and column in lower case, as in avg_Stud_marks, or count.
+type Aggregate struct {
+	Func   string `json:"func"`
+	Column string `json:"column"`
+	As     string `json:"as"`
+}
+
+// AggregateRequest computes aggregates over the rows of a shard that match
+// every predicate in Where, for each group of rows with the same values in
+// the GroupBy columns.
+type AggregateRequest struct {
+	Shard      string      `json:"shard"`
+	Aggregates []Aggregate `json:"aggregates"`
+	Where      []Predicate `json:"where"`
+	GroupBy    []string    `json:"group_by"`
+}
+
+// AggregateResponse has one row per group, with the group columns and the
+// aggregates.
+type AggregateResponse struct {
+	Data       []Row  `json:"data"`
+	CurrentIdx int    `json:"current_idx"`
+	Status     string `json:"status"`
+}
@@ -0,0 +1,229 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types below are the OTLP/JSON encoding of an ExportTraceServiceRequest,
+// as an OpenTelemetry collector takes at /v1/traces. Ids are hex and 64-bit
+// integers are strings, as the protobuf JSON mapping has them.
+
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type SpanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// Value returns the value held, as a string, int64, float64 or bool.
+func (v AnyValue) Value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		i, _ := strconv.ParseInt(*v.IntValue, 10, 64)
+		return i
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	}
+	return nil
+}
+
+func attribute(key string, value interface{}) KeyValue {
+	var v AnyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	case bool:
+		v.BoolValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return KeyValue{Key: key, Value: v}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (s *Span) data() SpanData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data := SpanData{
+		TraceID:           s.context.TraceID.String(),
+		SpanID:            s.context.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        append([]KeyValue{attribute("request.id", s.context.RequestID)}, s.attributes...),
+		Status:            Status{Code: s.statusCode, Message: s.statusMessage},
+	}
+	if s.parentID.isValid() {
+		data.ParentSpanID = s.parentID.String()
+	}
+	return data
+}
+
+func (t *Tracer) exportRequest(spans []*Span) *ExportRequest {
+	data := make([]SpanData, len(spans))
+	for i, span := range spans {
+		data[i] = span.data()
+	}
+	return &ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   Resource{Attributes: t.resource},
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: "galaxydb"}, Spans: data}},
+	}}}
+}
+
+// Exporter sends finished spans somewhere. Export is only ever called by one
+// goroutine at a time.
+type Exporter interface {
+	Export(request *ExportRequest) error
+}
+
+// FileExporter appends each batch of spans to a file, as one line of JSON.
+// Several processes may share the file.
+type FileExporter struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileExporter(path string) *FileExporter {
+	return &FileExporter{path: path}
+}
+
+func (e *FileExporter) Export(request *ExportRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// a single write in append mode keeps lines of other processes whole
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// COLLECTOR_TIMEOUT is how long a collector gets to take a batch of spans.
+const COLLECTOR_TIMEOUT = 5 * time.Second
+
+// CollectorExporter posts batches of spans to an OTLP/HTTP collector.
+type CollectorExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewCollectorExporter returns an exporter posting to url, the full address
+// of the traces endpoint, such as http://localhost:4318/v1/traces.
+func NewCollectorExporter(url string) *CollectorExporter {
+	return &CollectorExporter{url: url, client: &http.Client{Timeout: COLLECTOR_TIMEOUT}}
+}
+
+func (e *CollectorExporter) Export(request *ExportRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("collector answered %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// ExportersFromEnv returns the exporters the environment asks for:
+// GALAXYDB_TRACES_FILE names a file to append spans to, and
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT followed
+// by /v1/traces, a collector to post them to.
+func ExportersFromEnv() []Exporter {
+	exporters := []Exporter{}
+	if path := os.Getenv("GALAXYDB_TRACES_FILE"); path != "" {
+		exporters = append(exporters, NewFileExporter(path))
+	}
+	if url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); url != "" {
+		exporters = append(exporters, NewCollectorExporter(url))
+	} else if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		exporters = append(exporters, NewCollectorExporter(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	}
+	return exporters
+}
@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	RequestIDHeader   = "X-Request-ID"
+)
+
+// MAX_REQUEST_ID_LENGTH is the longest request id taken from a client.
+const MAX_REQUEST_ID_LENGTH = 128
+
+// formatTraceparent writes sc as a version 00 traceparent, always sampled.
+func formatTraceparent(sc SpanContext) string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// parseTraceparent reads the trace and parent span ids of a traceparent
+// header, of version 00 or of a later version it is compatible with.
+func parseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.DecodeString(parts[3]); err != nil {
+		return sc, false
+	}
+	return sc, sc.TraceID.isValid() && sc.SpanID.isValid()
+}
+
+// validRequestID reports whether a client's request id is short and plain
+// enough to be logged and passed on as it is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns ctx with the trace context of an incoming request's
+// headers, if it has any.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := parseTraceparent(header.Get(TraceparentHeader))
+	if requestID := header.Get(RequestIDHeader); validRequestID(requestID) {
+		sc.RequestID = requestID
+		ok = true
+	}
+	if !ok {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+// Inject sets the headers that pass the trace context of ctx on to the server
+// a request goes to.
+func Inject(ctx context.Context, header http.Header) {
+	sc, ok := spanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	if sc.TraceID.isValid() && sc.SpanID.isValid() {
+		header.Set(TraceparentHeader, formatTraceparent(sc))
+	}
+	if sc.RequestID != "" {
+		header.Set(RequestIDHeader, sc.RequestID)
+	}
+}
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware serves each request under a server span, continuing the trace of
+// the caller if it sent one, answers with its request id and logs it. Paths
+// in untraced, such as heartbeats, are served as they are.
+func (t *Tracer) Middleware(next http.Handler, untraced ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range untraced {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ctx, span := t.Start(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path, SpanKindServer)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		w.Header().Set(RequestIDHeader, span.context.RequestID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttribute("http.response.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(errorStatus(recorder.status))
+		}
+		span.End()
+		slog.InfoContext(ctx, "request served", "method", r.Method, "path", r.URL.Path, "status", recorder.status, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+	})
+}
+
+// errorStatus is an HTTP status recorded as the error of a span.
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return "status " + http.StatusText(int(e))
+}
+
+// Transport records each request sent with a span in its context as a client
+// span, and passes the trace on to the server in the request headers.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport returns a Transport sending requests with base, or with
+// http.DefaultTransport if base is nil.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Path, SpanKindClient)
+	if span == nil {
+		return t.Base.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError(errorStatus(resp.StatusCode))
+	}
+	return resp, nil
+}
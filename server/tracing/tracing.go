@@ -0,0 +1,292 @@
+// Package tracing follows a request from the load balancer to the shard
+// servers it fans out to. Trace context travels in the W3C traceparent header
+// and the request id in X-Request-ID. Finished spans are exported in the
+// OTLP/JSON format, to a file or to a collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SpanKind says what side of a call a span is, with the values of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Status codes of a span, with the values of OTLP.
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// QUEUE_SIZE is how many finished spans may wait for export before new ones
+// are dropped.
+const QUEUE_SIZE = 4096
+
+// BATCH_SIZE is the most spans exported at once.
+const BATCH_SIZE = 512
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) isValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) isValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is what a span passes on to its children, in the process or
+// through headers.
+type SpanContext struct {
+	TraceID   TraceID
+	SpanID    SpanID
+	RequestID string
+}
+
+// Span is one timed operation of a trace. All methods of Span do nothing on a
+// nil span, which is what Start returns outside of a trace.
+type Span struct {
+	tracer   *Tracer
+	context  SpanContext
+	parentID SpanID
+	name     string
+	kind     SpanKind
+	start    time.Time
+
+	mutex         sync.Mutex
+	end           time.Time
+	attributes    []KeyValue
+	statusCode    int
+	statusMessage string
+	ended         bool
+}
+
+// Context returns the ids the span passes on to its children.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, integer, float or boolean value on the span.
+// Other values are recorded as their fmt.Sprint.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attribute(key, value))
+}
+
+// SetError marks the span as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.statusCode = statusError
+	s.statusMessage = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	s.tracer.enqueue(s)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns ctx with span as the parent of spans started from
+// it.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote returns ctx with sc, received from another process, as
+// the parent of spans started from it.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// spanContextFrom returns the span context spans started from ctx descend
+// from, local or remote.
+func spanContextFrom(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// RequestID returns the id of the request ctx belongs to, or "" outside of
+// one.
+func RequestID(ctx context.Context) string {
+	sc, _ := spanContextFrom(ctx)
+	return sc.RequestID
+}
+
+// Start starts a span as a child of the span in ctx, with the tracer of its
+// parent. Outside of a trace it returns ctx and a nil span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// Tracer records the spans of one process and exports them.
+type Tracer struct {
+	resource  []KeyValue
+	exporters []Exporter
+	queue     chan *Span
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending int
+	dropped int
+}
+
+// NewTracer returns a tracer for a service, and for one instance of it if
+// instance is not empty. Spans go to every exporter given; without any they
+// are only used to pass trace context on.
+func NewTracer(service string, instance string, exporters ...Exporter) *Tracer {
+	t := &Tracer{
+		resource:  []KeyValue{attribute("service.name", service)},
+		exporters: exporters,
+	}
+	if instance != "" {
+		t.resource = append(t.resource, attribute("service.instance.id", instance))
+	}
+	t.cond = sync.NewCond(&t.mutex)
+	if len(exporters) > 0 {
+		t.queue = make(chan *Span, QUEUE_SIZE)
+		go t.export()
+	}
+	return t
+}
+
+func newID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("tracing: cannot generate ids: %v", err))
+	}
+}
+
+// Start starts a span as a child of the span in ctx, local or remote, or as
+// the root of a new trace if there is none. It returns ctx with the new span.
+// The span keeps the request id of its parent, and without one the request
+// id is the trace id.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	// a caller may send a request id without a trace
+	parent, _ := spanContextFrom(ctx)
+	span.context.RequestID = parent.RequestID
+	if parent.TraceID.isValid() {
+		span.context.TraceID = parent.TraceID
+		span.parentID = parent.SpanID
+	} else {
+		newID(span.context.TraceID[:])
+	}
+	newID(span.context.SpanID[:])
+	if span.context.RequestID == "" {
+		span.context.RequestID = span.context.TraceID.String()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	if t.queue == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	select {
+	case t.queue <- span:
+		t.pending++
+	default:
+		t.dropped++
+		if t.dropped == 1 || t.dropped%QUEUE_SIZE == 0 {
+			slog.Warn("trace export queue full, spans dropped", "dropped", t.dropped)
+		}
+	}
+}
+
+// export sends the queued spans to the exporters, in batches of whatever has
+// queued up while the last batch was being sent.
+func (t *Tracer) export() {
+	for span := range t.queue {
+		batch := []*Span{span}
+	drain:
+		for len(batch) < BATCH_SIZE {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+			default:
+				break drain
+			}
+		}
+
+		request := t.exportRequest(batch)
+		for _, exporter := range t.exporters {
+			if err := exporter.Export(request); err != nil {
+				slog.Warn("error exporting spans", "spans", len(batch), "error", err)
+			}
+		}
+
+		t.mutex.Lock()
+		t.pending -= len(batch)
+		t.cond.Broadcast()
+		t.mutex.Unlock()
+	}
+}
+
+// Flush waits until every span ended so far has been exported.
+func (t *Tracer) Flush() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for t.pending > 0 {
+		t.cond.Wait()
+	}
+}
@@ -0,0 +1,250 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recorder keeps the spans exported to it.
+type recorder struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(request *ExportRequest) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, rs := range request.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			r.spans = append(r.spans, ss.Spans...)
+		}
+	}
+	return nil
+}
+
+// byName returns the spans exported under their names.
+func (r *recorder) byName() map[string]SpanData {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	spans := map[string]SpanData{}
+	for _, span := range r.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func attributeOf(span SpanData, key string) interface{} {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Value()
+		}
+	}
+	return nil
+}
+
+func TestTraceparent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(header)
+	if !ok {
+		t.Fatalf("%q not parsed", header)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("got trace %s span %s", sc.TraceID, sc.SpanID)
+	}
+	if got := formatTraceparent(sc); got != header {
+		t.Errorf("formatted as %q, want %q", got, header)
+	}
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(header); ok {
+			t.Errorf("%q parsed", header)
+		}
+	}
+	if _, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Error("later version not parsed")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &recorder{}
+	tracer := NewTracer("test", "", exporter)
+	var served context.Context
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.Context()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}), "/heartbeat")
+
+	// a caller's trace and request id are carried on
+	req := httptest.NewRequest("POST", "/write", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(RequestIDHeader, "client-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got != "client-42" {
+		t.Errorf("answered with request id %q, want client-42", got)
+	}
+	if got := RequestID(served); got != "client-42" {
+		t.Errorf("handler got request id %q, want client-42", got)
+	}
+
+	// without one a new trace is started, its id standing for the request id
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/fail", nil))
+	traceID := SpanFromContext(served).Context().TraceID.String()
+	if got := w.Header().Get(RequestIDHeader); got != traceID {
+		t.Errorf("answered with request id %q, want the trace id %s", got, traceID)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/heartbeat", nil))
+	if SpanFromContext(served) != nil {
+		t.Error("heartbeat traced")
+	}
+
+	tracer.Flush()
+	spans := exporter.byName()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2: %v", len(spans), spans)
+	}
+	write := spans["POST /write"]
+	if write.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || write.ParentSpanID != "00f067aa0ba902b7" || write.Kind != SpanKindServer {
+		t.Errorf("got write span %+v", write)
+	}
+	if got := attributeOf(write, "request.id"); got != "client-42" {
+		t.Errorf("write span has request id %v", got)
+	}
+	fail := spans["GET /fail"]
+	if fail.TraceID != traceID || fail.ParentSpanID != "" || fail.Status.Code != statusError {
+		t.Errorf("got fail span %+v", fail)
+	}
+	if got := attributeOf(fail, "http.response.status_code"); got != int64(http.StatusInternalServerError) {
+		t.Errorf("fail span has status %v", got)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	exporter := &recorder{}
+	tracer := NewTracer("test", "", exporter)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	// outside of a trace requests go out as they are
+	resp, err := client.Get(server.URL + "/read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if header.Get(TraceparentHeader) != "" || header.Get(RequestIDHeader) != "" {
+		t.Errorf("untraced request sent trace headers %v", header)
+	}
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindInternal)
+	req, err := http.NewRequestWithContext(ctx, "POST", server.URL+"/write", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Error("request of the caller modified")
+	}
+
+	tracer.Flush()
+	spans := exporter.byName()
+	clientSpan := spans["POST /write"]
+	if clientSpan.Kind != SpanKindClient || clientSpan.ParentSpanID != parent.Context().SpanID.String() || clientSpan.TraceID != parent.Context().TraceID.String() {
+		t.Errorf("got client span %+v", clientSpan)
+	}
+	if clientSpan.Status.Code != statusError {
+		t.Error("client span of a 400 not failed")
+	}
+	sc, ok := parseTraceparent(header.Get(TraceparentHeader))
+	if !ok || sc.TraceID.String() != clientSpan.TraceID || sc.SpanID.String() != clientSpan.SpanID {
+		t.Errorf("server got traceparent %q, want the client span %s", header.Get(TraceparentHeader), clientSpan.SpanID)
+	}
+	if got := header.Get(RequestIDHeader); got != clientSpan.TraceID {
+		t.Errorf("server got request id %q, want %s", got, clientSpan.TraceID)
+	}
+}
+
+func TestExportersFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	var collected ExportRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&collected)
+	}))
+	defer collector.Close()
+
+	t.Setenv("GALAXYDB_TRACES_FILE", path)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL+"/")
+	exporters := ExportersFromEnv()
+	if len(exporters) != 2 {
+		t.Fatalf("got %d exporters, want 2", len(exporters))
+	}
+
+	tracer := NewTracer("galaxydb-server", "Server1", exporters...)
+	_, span := tracer.Start(context.Background(), "apply", SpanKindInternal)
+	span.SetAttribute("galaxydb.shard", "sh1")
+	span.End()
+	tracer.Flush()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("nothing written to the file")
+	}
+	var written ExportRequest
+	if err := json.Unmarshal(scanner.Bytes(), &written); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, request := range map[string]ExportRequest{"file": written, "collector": collected} {
+		if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+			t.Errorf("%s: got %+v", name, request)
+			continue
+		}
+		resource := request.ResourceSpans[0].Resource
+		if len(resource.Attributes) != 2 || resource.Attributes[0].Value.Value() != "galaxydb-server" || resource.Attributes[1].Value.Value() != "Server1" {
+			t.Errorf("%s: got resource %+v", name, resource)
+		}
+		data := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		if data.Name != "apply" || data.TraceID != span.Context().TraceID.String() || attributeOf(data, "galaxydb.shard") != "sh1" || data.StartTimeUnixNano == "" {
+			t.Errorf("%s: got span %+v", name, data)
+		}
+	}
+}